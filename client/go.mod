@@ -1,6 +1,6 @@
 module github.com/liquidmetal-dev/controller-pkg/client
 
-go 1.19
+go 1.23
 
 require (
 	github.com/liquidmetal-dev/flintlock/api v0.0.0-20250205095343-755c4154ea88
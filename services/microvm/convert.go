@@ -4,33 +4,182 @@
 package microvm
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
 	types "github.com/liquidmetal-dev/controller-pkg/types/microvm"
 	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
 )
 
 const platformLiquidMetal = "liquid_metal"
 
-func convertToFlintlockAPI(mvmScope Scope) *flintlocktypes.MicroVMSpec {
-	mvmSpec := mvmScope.GetMicrovmSpec()
+var errResourceOverflow = errors.New("exceeds the maximum supported by flintlock")
+
+// SpecMutator is a hook to change the flintlock microvm spec after it has been
+// converted from the scope, for example to apply organisation wide settings.
+type SpecMutator func(scope Scope, spec *flintlocktypes.MicroVMSpec) error
+
+// Converter converts the microvm described by a Scope into a flintlock microvm spec.
+type Converter struct {
+	mutators []SpecMutator
+	naming   NamingStrategy
+}
+
+// NewConverter returns a Converter which runs the mutators, in order, after the
+// built in conversion.
+func NewConverter(mutators ...SpecMutator) *Converter {
+	return &Converter{mutators: mutators}
+}
+
+// Convert returns the flintlock microvm spec for the scope. It returns an error
+// rather than truncating resources which don't fit the flintlock API.
+//
+// This is a partial conversion: only the spec of the scope is converted and the
+// mutators run. It doesn't resolve the instance type or apply the preferred
+// provider, and it doesn't add the ownership labels, metadata or generated MAC
+// addresses, so it differs from what Service.Create sends. Use Service.Render for
+// the full request.
+func (c *Converter) Convert(scope Scope) (*flintlocktypes.MicroVMSpec, error) {
+	return c.convert(scope, scope.GetMicrovmSpec())
+}
+
+// convert converts the spec, which replaces the spec of the scope.
+func (c *Converter) convert(scope Scope, spec types.VMSpec) (*flintlocktypes.MicroVMSpec, error) {
+	apiVM, err := convertSpecToFlintlockAPI(scope, spec, c.microvmName(scope))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, mutate := range c.mutators {
+		if err := mutate(scope, apiVM); err != nil {
+			return nil, fmt.Errorf("running spec mutator %d: %w", i, err)
+		}
+	}
+
+	return apiVM, nil
+}
+
+// ForceLabels is a SpecMutator which sets the labels, replacing any with the same key.
+func ForceLabels(labels map[string]string) SpecMutator {
+	return func(_ Scope, spec *flintlocktypes.MicroVMSpec) error {
+		// Copy, as the labels are shared with the scope.
+		merged := make(map[string]string, len(spec.Labels)+len(labels))
+		for k, v := range spec.Labels {
+			merged[k] = v
+		}
+
+		for k, v := range labels {
+			merged[k] = v
+		}
+
+		spec.Labels = merged
+
+		return nil
+	}
+}
+
+// KernelCmdlineBaseline is a SpecMutator which adds the kernel command line args
+// that aren't already set by the spec, such as the recommended args of a provider.
+func KernelCmdlineBaseline(args types.KernelCmdLine) SpecMutator {
+	return func(_ Scope, spec *flintlocktypes.MicroVMSpec) error {
+		if spec.Kernel == nil {
+			return nil
+		}
+
+		cmdline := types.KernelCmdLineFromMap(spec.Kernel.Cmdline)
+		spec.Kernel.Cmdline = types.MergeKernelCmdLine(args, cmdline).ToMap()
+
+		return nil
+	}
+}
+
+// RewriteRegistry is a SpecMutator which replaces the from prefix of every container
+// image used by the microvm with the to prefix.
+func RewriteRegistry(from, to string) SpecMutator {
+	rewrite := func(image string) string {
+		if strings.HasPrefix(image, from) {
+			return to + strings.TrimPrefix(image, from)
+		}
+
+		return image
+	}
+
+	return func(_ Scope, spec *flintlocktypes.MicroVMSpec) error {
+		if spec.Kernel != nil {
+			spec.Kernel.Image = rewrite(spec.Kernel.Image)
+		}
+
+		if spec.Initrd != nil {
+			spec.Initrd.Image = rewrite(spec.Initrd.Image)
+		}
 
-	apiVM := newVM(
-		withProvider(&mvmSpec.Provider),
-		withNamespaceName(mvmScope.Name(), mvmScope.Namespace()),
-		withLabels(mvmScope.GetLabels()),
-		withResources(mvmSpec.VCPU, mvmSpec.MemoryMb),
-		withInitRD(mvmSpec.Initrd),
-		withNetworkInterfaces(mvmSpec.NetworkInterfaces),
-		withAdditionalVolumes(mvmSpec.AdditionalVolumes),
-		withKernel(mvmSpec.Kernel, mvmSpec.KernelCmdLine),
-		withRootVolume(mvmSpec.RootVolume),
-	)
+		for _, vol := range append([]*flintlocktypes.Volume{spec.RootVolume}, spec.AdditionalVolumes...) {
+			if vol.GetSource().GetContainerSource() != "" {
+				image := rewrite(vol.Source.GetContainerSource())
+				vol.Source.ContainerSource = &image
+			}
+		}
 
-	return apiVM
+		return nil
+	}
 }
 
-type specOption func(*flintlocktypes.MicroVMSpec)
+func checkResources(vcpu, memoryMiB int64) error {
+	if vcpu > math.MaxInt32 {
+		return fmt.Errorf("vcpu %d: %w", vcpu, errResourceOverflow)
+	}
 
-func newVM(opts ...specOption) *flintlocktypes.MicroVMSpec {
+	if memoryMiB > math.MaxInt32 {
+		return fmt.Errorf("memory %dMi: %w", memoryMiB, errResourceOverflow)
+	}
+
+	return nil
+}
+
+func convertSpecToFlintlockAPI(
+	mvmScope Scope,
+	mvmSpec types.VMSpec,
+	name MicroVMName,
+) (*flintlocktypes.MicroVMSpec, error) {
+	specOpts, err := VMSpecOptions(&mvmSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []SpecOption{
+		WithNamespaceName(name.ID, name.Namespace),
+		WithLabels(MergeLabels(mvmSpec.Labels, mvmScope.GetLabels())),
+	}
+
+	return NewVM(append(opts, specOpts...)...), nil
+}
+
+// VMSpecOptions returns the options which convert the fields of the VMSpec. It
+// returns an error rather than truncating resources which don't fit the flintlock API.
+func VMSpecOptions(mvmSpec *types.VMSpec) ([]SpecOption, error) {
+	resources, err := WithResources(mvmSpec.VCPU, mvmSpec.MemoryMiB())
+	if err != nil {
+		return nil, err
+	}
+
+	return []SpecOption{
+		WithProvider(&mvmSpec.Provider),
+		resources,
+		WithInitRD(mvmSpec.Initrd),
+		WithNetworkInterfaces(mvmSpec.NetworkInterfaces),
+		WithAdditionalVolumes(mvmSpec.AdditionalVolumes),
+		WithKernel(mvmSpec.Kernel, mvmSpec.KernelCmdLine),
+		WithRootVolume(mvmSpec.RootVolume),
+	}, nil
+}
+
+// SpecOption is a func to set part of a flintlock microvm spec.
+type SpecOption func(*flintlocktypes.MicroVMSpec)
+
+// NewVM returns a flintlock microvm spec built from the options.
+func NewVM(opts ...SpecOption) *flintlocktypes.MicroVMSpec {
 	s := &flintlocktypes.MicroVMSpec{
 		Interfaces:        []*flintlocktypes.NetworkInterface{},
 		AdditionalVolumes: []*flintlocktypes.Volume{},
@@ -44,33 +193,43 @@ func newVM(opts ...specOption) *flintlocktypes.MicroVMSpec {
 	return s
 }
 
-func withNamespaceName(name, namespace string) specOption {
+// WithNamespaceName sets the flintlock ID and namespace of the microvm.
+func WithNamespaceName(name, namespace string) SpecOption {
 	return func(s *flintlocktypes.MicroVMSpec) {
 		s.Id = name
 		s.Namespace = namespace
 	}
 }
 
-func withProvider(p *string) specOption {
+// WithProvider sets the microvm provider.
+func WithProvider(p *string) SpecOption {
 	return func(s *flintlocktypes.MicroVMSpec) {
 		s.Provider = p
 	}
 }
 
-func withLabels(labels map[string]string) specOption {
+// WithLabels sets the labels of the microvm, see MergeLabels.
+func WithLabels(labels map[string]string) SpecOption {
 	return func(s *flintlocktypes.MicroVMSpec) {
 		s.Labels = labels
 	}
 }
 
-func withResources(vcpu, mem int64) specOption {
+// WithResources sets the number of vcpus and the memory, in mebibytes, of the microvm.
+// It returns an error if they don't fit the flintlock API.
+func WithResources(vcpu, mem int64) (SpecOption, error) {
+	if err := checkResources(vcpu, mem); err != nil {
+		return nil, err
+	}
+
 	return func(s *flintlocktypes.MicroVMSpec) {
 		s.Vcpu = int32(vcpu)
 		s.MemoryInMb = int32(mem)
-	}
+	}, nil
 }
 
-func withInitRD(initrd *types.ContainerFileSource) specOption {
+// WithInitRD sets the initial ramdisk of the microvm, if there is one.
+func WithInitRD(initrd *types.ContainerFileSource) SpecOption {
 	return func(s *flintlocktypes.MicroVMSpec) {
 		if initrd != nil {
 			s.Initrd = &flintlocktypes.Initrd{
@@ -81,7 +240,8 @@ func withInitRD(initrd *types.ContainerFileSource) specOption {
 	}
 }
 
-func withNetworkInterfaces(interfaces []types.NetworkInterface) specOption {
+// WithNetworkInterfaces adds the network interfaces to the microvm.
+func WithNetworkInterfaces(interfaces []types.NetworkInterface) SpecOption {
 	return func(s *flintlocktypes.MicroVMSpec) {
 		for i := range interfaces {
 			iface := interfaces[i]
@@ -109,7 +269,8 @@ func withNetworkInterfaces(interfaces []types.NetworkInterface) specOption {
 	}
 }
 
-func withAdditionalVolumes(volumes []types.Volume) specOption {
+// WithAdditionalVolumes adds the non-root volumes to the microvm.
+func WithAdditionalVolumes(volumes []types.Volume) SpecOption {
 	return func(s *flintlocktypes.MicroVMSpec) {
 		for i := range volumes {
 			volume := volumes[i]
@@ -133,18 +294,20 @@ func withAdditionalVolumes(volumes []types.Volume) specOption {
 	}
 }
 
-func withKernel(k types.ContainerFileSource, cmdLine map[string]string) specOption {
+// WithKernel sets the kernel and kernel command line of the microvm.
+func WithKernel(k types.ContainerFileSource, cmdLine types.KernelCmdLine) SpecOption {
 	return func(s *flintlocktypes.MicroVMSpec) {
 		s.Kernel = &flintlocktypes.Kernel{
 			Image:            k.Image,
 			Filename:         &k.Filename,
-			Cmdline:          cmdLine,
+			Cmdline:          cmdLine.ToMap(),
 			AddNetworkConfig: true,
 		}
 	}
 }
 
-func withRootVolume(rv types.Volume) specOption {
+// WithRootVolume sets the root volume of the microvm.
+func WithRootVolume(rv types.Volume) SpecOption {
 	return func(s *flintlocktypes.MicroVMSpec) {
 		s.RootVolume = &flintlocktypes.Volume{
 			Id:         rv.ID,
@@ -155,3 +318,77 @@ func withRootVolume(rv types.Volume) specOption {
 		}
 	}
 }
+
+// ConvertFromFlintlockAPI converts a flintlock microvm spec back into a VMSpec. It
+// also returns the name, namespace and labels of the microvm, which are supplied by
+// the Scope rather than the VMSpec when converting to the flintlock API.
+func ConvertFromFlintlockAPI(apiVM *flintlocktypes.MicroVMSpec) (spec types.VMSpec, name, namespace string, labels map[string]string) {
+	spec = types.VMSpec{
+		Provider:   apiVM.GetProvider(),
+		VCPU:       int64(apiVM.Vcpu),
+		MemoryMb:   int64(apiVM.MemoryInMb),
+		RootVolume: convertVolumeFromFlintlockAPI(apiVM.RootVolume),
+	}
+
+	if kernel := apiVM.Kernel; kernel != nil {
+		spec.Kernel = types.ContainerFileSource{
+			Image:    kernel.Image,
+			Filename: kernel.GetFilename(),
+		}
+
+		if len(kernel.Cmdline) > 0 {
+			spec.KernelCmdLine = types.KernelCmdLineFromMap(kernel.Cmdline)
+		}
+	}
+
+	if initrd := apiVM.Initrd; initrd != nil {
+		spec.Initrd = &types.ContainerFileSource{
+			Image:    initrd.Image,
+			Filename: initrd.GetFilename(),
+		}
+	}
+
+	for _, vol := range apiVM.AdditionalVolumes {
+		spec.AdditionalVolumes = append(spec.AdditionalVolumes, convertVolumeFromFlintlockAPI(vol))
+	}
+
+	for _, apiIface := range apiVM.Interfaces {
+		iface := types.NetworkInterface{
+			GuestDeviceName: apiIface.DeviceId,
+			GuestMAC:        apiIface.GetGuestMac(),
+			Address:         apiIface.GetAddress().GetAddress(),
+		}
+
+		switch apiIface.Type {
+		case flintlocktypes.NetworkInterface_MACVTAP:
+			iface.Type = types.IfaceTypeMacvtap
+		case flintlocktypes.NetworkInterface_TAP:
+			iface.Type = types.IfaceTypeTap
+		}
+
+		spec.NetworkInterfaces = append(spec.NetworkInterfaces, iface)
+	}
+
+	if len(apiVM.Labels) > 0 {
+		labels = make(map[string]string, len(apiVM.Labels))
+		for k, v := range apiVM.Labels {
+			labels[k] = v
+		}
+	}
+
+	return spec, apiVM.Id, apiVM.Namespace, labels
+}
+
+func convertVolumeFromFlintlockAPI(vol *flintlocktypes.Volume) types.Volume {
+	if vol == nil {
+		return types.Volume{}
+	}
+
+	return types.Volume{
+		ID:           vol.Id,
+		Image:        vol.GetSource().GetContainerSource(),
+		ReadOnly:     vol.IsReadOnly,
+		MountPoint:   vol.GetMountPoint(),
+		VirtioFSPath: vol.GetSource().GetVirtiofsSource(),
+	}
+}
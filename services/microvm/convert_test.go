@@ -1,9 +1,16 @@
 package microvm
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
 	"testing"
+	"testing/quick"
 
 	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/utils/pointer"
 
 	"github.com/liquidmetal-dev/controller-pkg/services/microvm/fakes"
@@ -197,7 +204,7 @@ func Test_convertToFlintlockAPI(t *testing.T) {
 				Image:    strVal1,
 				Filename: strVal2,
 			},
-				KernelCmdLine: map[string]string{strVal3: strVal4},
+				KernelCmdLine: microvm.KernelCmdLine{{Name: strVal3, Value: strVal4}},
 			},
 			expected: func(g *WithT, converted *flintlocktypes.MicroVMSpec) {
 				g.Expect(converted.Kernel.Image).To(Equal(strVal1))
@@ -231,9 +238,285 @@ func Test_convertToFlintlockAPI(t *testing.T) {
 			mScope.NamespaceReturns(namespace)
 			mScope.GetLabelsReturns(map[string]string{keyVal1: strVal1})
 
-			convertedVM := convertToFlintlockAPI(mScope)
+			convertedVM, err := NewConverter().Convert(mScope)
+			g.Expect(err).NotTo(HaveOccurred())
 
 			tc.expected(g, convertedVM)
 		})
 	}
 }
+
+func Test_ConvertFromFlintlockAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	apiVM := &flintlocktypes.MicroVMSpec{
+		Id:         "foo",
+		Namespace:  "bar",
+		Labels:     map[string]string{"key1": "value1"},
+		Provider:   pointer.String("firecracker"),
+		Vcpu:       2,
+		MemoryInMb: 2048,
+		Kernel: &flintlocktypes.Kernel{
+			Image:    "kernel",
+			Filename: pointer.String("boot/vmlinux"),
+			Cmdline:  map[string]string{"console": "ttyS0"},
+		},
+		Initrd: &flintlocktypes.Initrd{Image: "initrd"},
+		RootVolume: &flintlocktypes.Volume{
+			Id:     "root",
+			Source: &flintlocktypes.VolumeSource{ContainerSource: pointer.String("os")},
+		},
+		AdditionalVolumes: []*flintlocktypes.Volume{
+			{
+				Id:         "shared",
+				IsReadOnly: true,
+				MountPoint: pointer.String("/mnt"),
+				Source:     &flintlocktypes.VolumeSource{VirtiofsSource: pointer.String("/shared")},
+			},
+		},
+		Interfaces: []*flintlocktypes.NetworkInterface{
+			{
+				DeviceId: "eth0",
+				Type:     flintlocktypes.NetworkInterface_TAP,
+				GuestMac: pointer.String("02:00:00:00:00:01"),
+				Address:  &flintlocktypes.StaticAddress{Address: "10.0.0.2/24"},
+			},
+		},
+	}
+
+	spec, name, namespace, labels := ConvertFromFlintlockAPI(apiVM)
+
+	g.Expect(name).To(Equal("foo"))
+	g.Expect(namespace).To(Equal("bar"))
+	g.Expect(labels).To(Equal(map[string]string{"key1": "value1"}))
+	g.Expect(spec).To(Equal(microvm.VMSpec{
+		Provider:      "firecracker",
+		VCPU:          2,
+		MemoryMb:      2048,
+		Kernel:        microvm.ContainerFileSource{Image: "kernel", Filename: "boot/vmlinux"},
+		KernelCmdLine: microvm.KernelCmdLine{{Name: "console", Value: "ttyS0"}},
+		Initrd:        &microvm.ContainerFileSource{Image: "initrd"},
+		RootVolume:    microvm.Volume{ID: "root", Image: "os"},
+		AdditionalVolumes: []microvm.Volume{
+			{ID: "shared", ReadOnly: true, MountPoint: "/mnt", VirtioFSPath: "/shared"},
+		},
+		NetworkInterfaces: []microvm.NetworkInterface{
+			{GuestDeviceName: "eth0", Type: microvm.IfaceTypeTap, GuestMAC: "02:00:00:00:00:01", Address: "10.0.0.2/24"},
+		},
+	}))
+}
+
+// roundTrip is a VMSpec, with the scope supplied name, namespace and labels, which
+// can be generated by testing/quick.
+type roundTrip struct {
+	spec      microvm.VMSpec
+	name      string
+	namespace string
+	labels    map[string]string
+}
+
+func (roundTrip) Generate(r *rand.Rand, _ int) reflect.Value {
+	str := func(prefix string) string {
+		if r.Intn(4) == 0 {
+			return ""
+		}
+
+		return fmt.Sprintf("%s-%d", prefix, r.Intn(1000))
+	}
+
+	strMap := func(prefix string) map[string]string {
+		n := r.Intn(3)
+		if n == 0 {
+			return nil
+		}
+
+		m := map[string]string{}
+		for i := 0; i < n; i++ {
+			m[fmt.Sprintf("%s%d", prefix, i)] = str("value")
+		}
+
+		return m
+	}
+
+	rt := roundTrip{
+		name:      str("name"),
+		namespace: str("ns"),
+		labels:    strMap("label"),
+		spec: microvm.VMSpec{
+			Provider:      str("provider"),
+			VCPU:          r.Int63n(64),
+			MemoryMb:      r.Int63n(65536),
+			RootVolume:    microvm.Volume{ID: str("root"), Image: str("image"), ReadOnly: r.Intn(2) == 0},
+			Kernel:        microvm.ContainerFileSource{Image: str("kernel"), Filename: str("file")},
+			KernelCmdLine: microvm.KernelCmdLineFromMap(strMap("arg")),
+		},
+	}
+
+	if r.Intn(2) == 0 {
+		rt.spec.Initrd = &microvm.ContainerFileSource{Image: str("initrd"), Filename: str("file")}
+	}
+
+	for i := r.Intn(3); i > 0; i-- {
+		vol := microvm.Volume{ID: str("vol"), ReadOnly: r.Intn(2) == 0, MountPoint: str("/mnt")}
+
+		if r.Intn(2) == 0 {
+			vol.Image = str("image")
+		} else {
+			vol.VirtioFSPath = str("/virtiofs")
+		}
+
+		rt.spec.AdditionalVolumes = append(rt.spec.AdditionalVolumes, vol)
+	}
+
+	for i := r.Intn(3); i > 0; i-- {
+		iface := microvm.NetworkInterface{
+			GuestDeviceName: str("eth"),
+			GuestMAC:        str("mac"),
+			Type:            microvm.IfaceTypeMacvtap,
+			Address:         str("addr"),
+		}
+
+		if r.Intn(2) == 0 {
+			iface.Type = microvm.IfaceTypeTap
+		}
+
+		rt.spec.NetworkInterfaces = append(rt.spec.NetworkInterfaces, iface)
+	}
+
+	return reflect.ValueOf(rt)
+}
+
+func (rt roundTrip) toFlintlockAPI() *flintlocktypes.MicroVMSpec {
+	mScope := new(fakes.FakeScope)
+	mScope.GetMicrovmSpecReturns(rt.spec)
+	mScope.NameReturns(rt.name)
+	mScope.NamespaceReturns(rt.namespace)
+	mScope.GetLabelsReturns(rt.labels)
+
+	converted, err := NewConverter().Convert(mScope)
+	if err != nil {
+		panic(err)
+	}
+
+	return converted
+}
+
+func Test_ConvertRoundTrip(t *testing.T) {
+	g := NewWithT(t)
+
+	cfg := &quick.Config{MaxCount: 500}
+
+	toAndFrom := func(rt roundTrip) bool {
+		spec, name, namespace, labels := ConvertFromFlintlockAPI(rt.toFlintlockAPI())
+
+		return reflect.DeepEqual(roundTrip{spec, name, namespace, labels}, rt)
+	}
+
+	g.Expect(quick.Check(toAndFrom, cfg)).To(Succeed())
+
+	fromAndTo := func(rt roundTrip) bool {
+		apiVM := rt.toFlintlockAPI()
+		spec, name, namespace, labels := ConvertFromFlintlockAPI(apiVM)
+
+		return proto.Equal(roundTrip{spec, name, namespace, labels}.toFlintlockAPI(), apiVM)
+	}
+
+	g.Expect(quick.Check(fromAndTo, cfg)).To(Succeed())
+}
+
+func Test_Converter(t *testing.T) {
+	g := NewWithT(t)
+
+	scopeLabels := map[string]string{"key1": "value1"}
+	cmdline := microvm.KernelCmdLine{{Name: "console", Value: "ttyS0"}, {Name: "console", Value: "hvc0"}}
+
+	mScope := new(fakes.FakeScope)
+	mScope.NameReturns("foo")
+	mScope.NamespaceReturns("bar")
+	mScope.GetLabelsReturns(scopeLabels)
+	mScope.GetMicrovmSpecReturns(microvm.VMSpec{
+		Kernel:        microvm.ContainerFileSource{Image: "docker.io/kernel:latest"},
+		KernelCmdLine: cmdline,
+		Initrd:        &microvm.ContainerFileSource{Image: "quay.io/initrd:latest"},
+		RootVolume:    microvm.Volume{ID: "root", Image: "docker.io/os:latest"},
+		AdditionalVolumes: []microvm.Volume{
+			{ID: "data", Image: "docker.io/data:latest"},
+			{ID: "shared", VirtioFSPath: "/shared"},
+		},
+	})
+
+	converter := NewConverter(
+		ForceLabels(map[string]string{"key1": "forced", "team": "a"}),
+		KernelCmdlineBaseline(microvm.KernelCmdLine{{Name: "console", Value: "tty0"}, {Name: "quiet"}}),
+		RewriteRegistry("docker.io/", "registry.internal/"),
+	)
+
+	converted, err := converter.Convert(mScope)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(converted.Id).To(Equal("foo"))
+	g.Expect(converted.Labels).To(Equal(map[string]string{"key1": "forced", "team": "a"}))
+	g.Expect(converted.Kernel.Cmdline).To(Equal(map[string]string{"console": "ttyS0 console=hvc0", "quiet": ""}))
+	g.Expect(converted.Kernel.Image).To(Equal("registry.internal/kernel:latest"))
+	g.Expect(converted.Initrd.Image).To(Equal("quay.io/initrd:latest"))
+	g.Expect(*converted.RootVolume.Source.ContainerSource).To(Equal("registry.internal/os:latest"))
+	g.Expect(*converted.AdditionalVolumes[0].Source.ContainerSource).To(Equal("registry.internal/data:latest"))
+	g.Expect(converted.AdditionalVolumes[1].Source.ContainerSource).To(BeNil())
+
+	// The scope is left untouched.
+	g.Expect(scopeLabels).To(Equal(map[string]string{"key1": "value1"}))
+	g.Expect(cmdline).To(Equal(microvm.KernelCmdLine{{Name: "console", Value: "ttyS0"}, {Name: "console", Value: "hvc0"}}))
+	g.Expect(mScope.GetMicrovmSpec().RootVolume.Image).To(Equal("docker.io/os:latest"))
+}
+
+func Test_ConverterError(t *testing.T) {
+	g := NewWithT(t)
+
+	mScope := new(fakes.FakeScope)
+
+	converter := NewConverter(func(Scope, *flintlocktypes.MicroVMSpec) error {
+		return fmt.Errorf("boom")
+	})
+
+	_, err := converter.Convert(mScope)
+	g.Expect(err).To(MatchError(ContainSubstring("boom")))
+}
+
+func Test_ConverterResourceOverflow(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name string
+		spec microvm.VMSpec
+	}{
+		{
+			name: "vcpu",
+			spec: microvm.VMSpec{VCPU: math.MaxInt32 + 1, MemoryMb: 2048},
+		},
+		{
+			name: "memoryMb",
+			spec: microvm.VMSpec{VCPU: 2, MemoryMb: math.MaxInt32 + 1},
+		},
+		{
+			name: "memory",
+			spec: microvm.VMSpec{VCPU: 2, Memory: resource.NewQuantity(1<<52, resource.BinarySI)},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mScope := new(fakes.FakeScope)
+			mScope.GetMicrovmSpecReturns(tc.spec)
+
+			_, err := NewConverter().Convert(mScope)
+			g.Expect(err).To(MatchError(errResourceOverflow))
+		})
+	}
+
+	mScope := new(fakes.FakeScope)
+	mScope.GetMicrovmSpecReturns(microvm.VMSpec{VCPU: 2, Memory: resource.NewQuantity(4*1024*microvm.Mebibyte, resource.BinarySI)})
+
+	converted, err := NewConverter().Convert(mScope)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(converted.MemoryInMb).To(Equal(int32(4096)))
+}
@@ -0,0 +1,377 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package drain plans and carries out the evacuation of the microvms on a flintlock
+// host, such as before the host is patched.
+package drain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/placement"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+const (
+	// DefaultMaxUnavailable is the number of microvms which are evacuated at once when
+	// WithMaxUnavailable isn't used.
+	DefaultMaxUnavailable = 1
+
+	// generatedSuffixLength is the length of the random suffix added by GenerateName.
+	generatedSuffixLength = 5
+)
+
+var (
+	// ErrHostNotCordoned is returned when planning the drain of a host which doesn't
+	// have a NoSchedule or NoExecute taint, so could have microvms placed on it
+	// while it is drained.
+	ErrHostNotCordoned = errors.New("host is not marked for maintenance")
+	// ErrNoReplaceFunc is returned when executing a plan with a drainer without a
+	// ReplaceFunc.
+	ErrNoReplaceFunc = errors.New("no replace func")
+
+	errNoOwner  = errors.New("microvm has no ownership labels")
+	errDeleting = errors.New("microvm is being deleted")
+	errNoSpec   = errors.New("microvm has no spec")
+)
+
+// BudgetFunc returns the disruption budget of an owner: the most microvms of the
+// owner which may be evacuated at once.
+type BudgetFunc func(owner microvm.Owner) int
+
+// RequestFunc returns the placement request for the replacement of a microvm.
+type RequestFunc func(ctx context.Context, mvm *flintlocktypes.MicroVM, owner microvm.Owner) (*placement.Request, error)
+
+// Drainer plans and executes the evacuation of the microvms on a host.
+type Drainer struct {
+	clientFor      placement.ClientFunc
+	scheduler      *placement.Scheduler
+	maxUnavailable int
+	budget         BudgetFunc
+	request        RequestFunc
+	replace        ReplaceFunc
+	ready          ReadyFunc
+	progress       ProgressFunc
+}
+
+// Option is a func to add an option to the drainer.
+type Option func(*Drainer)
+
+// WithScheduler sets the scheduler used to choose the host to move each microvm to.
+func WithScheduler(scheduler *placement.Scheduler) Option {
+	return func(d *Drainer) {
+		d.scheduler = scheduler
+	}
+}
+
+// WithMaxUnavailable sets the most microvms on the host which are evacuated at once,
+// whatever their owners. Values below 1 are treated as 1.
+func WithMaxUnavailable(n int) Option {
+	return func(d *Drainer) {
+		d.maxUnavailable = n
+	}
+}
+
+// WithBudget sets the disruption budgets of the owners of the microvms, which
+// further limit the microvms evacuated at once for owners with several microvms on
+// the host. Budgets below 1 are treated as 1, so every microvm can be evacuated.
+func WithBudget(budget BudgetFunc) Option {
+	return func(d *Drainer) {
+		d.budget = budget
+	}
+}
+
+// WithRequestFunc sets how the placement request for a replacement is built.
+// Flintlock doesn't store the placement constraints a microvm was created with, so
+// to carry them over to the replacement they must be looked up, such as from the
+// owner, and added to the request from RequestFromMicroVM. The default is
+// RequestFromMicroVM, which has no constraints.
+func WithRequestFunc(request RequestFunc) Option {
+	return func(d *Drainer) {
+		d.request = request
+	}
+}
+
+// WithReplaceFunc sets how the replacement of a microvm is created, such as with
+// CloneReplacer. It is required to execute a plan.
+func WithReplaceFunc(replace ReplaceFunc) Option {
+	return func(d *Drainer) {
+		d.replace = replace
+	}
+}
+
+// WithReadyFunc sets a check that the replacement of a microvm is ready, such as
+// it running, before the original is deleted. The default is WaitForRunning.
+func WithReadyFunc(ready ReadyFunc) Option {
+	return func(d *Drainer) {
+		d.ready = ready
+	}
+}
+
+// WithProgress sets a func called as each step of the plan progresses.
+func WithProgress(progress ProgressFunc) Option {
+	return func(d *Drainer) {
+		d.progress = progress
+	}
+}
+
+// New returns a drainer using clientFor to connect to the hosts.
+func New(clientFor placement.ClientFunc, opts ...Option) *Drainer {
+	d := &Drainer{
+		clientFor:      clientFor,
+		scheduler:      placement.New(),
+		maxUnavailable: DefaultMaxUnavailable,
+		request:        RequestFromMicroVM,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.ready == nil {
+		d.ready = WaitForRunning(clientFor)
+	}
+
+	return d
+}
+
+// Step is the evacuation of a microvm from the drained host.
+type Step struct {
+	// MicroVM is the microvm to evacuate.
+	MicroVM *flintlocktypes.MicroVM
+	// Owner is the owner of the microvm, from its ownership labels.
+	Owner microvm.Owner
+	// Target is the host to create the replacement on.
+	Target microvm.Host
+	// Wave is the wave the step is executed in. Steps in a wave are executed
+	// together, and no wave has more microvms than the max unavailable, or more
+	// microvms of an owner than its budget.
+	Wave int
+}
+
+// Skipped is a microvm which won't be evacuated.
+type Skipped struct {
+	// MicroVM is the microvm.
+	MicroVM *flintlocktypes.MicroVM
+	// Reason is why the microvm won't be evacuated.
+	Reason error
+}
+
+// Plan is an ordered plan to evacuate the microvms on a host.
+type Plan struct {
+	// Host is the host being drained.
+	Host microvm.Host
+	// Steps are the evacuations of the microvms, ordered by wave.
+	Steps []*Step
+	// Skipped are the microvms which won't be evacuated, such as those without an
+	// owner or which can't be placed on another host.
+	Skipped []Skipped
+}
+
+// Waves returns the steps of the plan grouped by wave, in order.
+func (p *Plan) Waves() [][]*Step {
+	waves := [][]*Step{}
+
+	for _, step := range p.Steps {
+		if step.Wave >= len(waves) {
+			waves = append(waves, []*Step{})
+		}
+
+		waves[step.Wave] = append(waves[step.Wave], step)
+	}
+
+	return waves
+}
+
+// Complete returns true if every microvm on the host is evacuated by the plan.
+func (p *Plan) Complete() bool {
+	return len(p.Skipped) == 0
+}
+
+// Plan lists the microvms on the host and plans their evacuation to the candidate
+// hosts, which should have their microvms refreshed, such as with placement.Refresh.
+// The host must have a NoSchedule or NoExecute taint. It is never chosen as a target.
+func (d *Drainer) Plan(ctx context.Context, host microvm.Host, candidates []placement.HostInfo) (*Plan, error) {
+	if !host.HasTaintEffect(microvm.TaintEffectNoSchedule) {
+		return nil, fmt.Errorf("%w: %s", ErrHostNotCordoned, host.Endpoint)
+	}
+
+	microvms, err := d.list(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []placement.HostInfo{}
+
+	for _, candidate := range candidates {
+		if candidate.Host.Endpoint == host.Endpoint {
+			continue
+		}
+
+		// The planned replacements are added to the microvms of the targets, so
+		// copy them to leave the candidates unchanged.
+		candidate.MicroVMs = append([]*flintlocktypes.MicroVM{}, candidate.MicroVMs...)
+		targets = append(targets, candidate)
+	}
+
+	plan := &Plan{Host: host, Steps: []*Step{}, Skipped: []Skipped{}}
+	waves := newWaves(d.maxUnavailable)
+
+	for _, mvm := range microvms {
+		if mvm.GetStatus().GetState() == flintlocktypes.MicroVMStatus_DELETING {
+			plan.Skipped = append(plan.Skipped, Skipped{MicroVM: mvm, Reason: errDeleting})
+
+			continue
+		}
+
+		owner, ok := microvm.OwnerFromLabels(mvm.GetSpec().GetLabels())
+		if !ok {
+			plan.Skipped = append(plan.Skipped, Skipped{MicroVM: mvm, Reason: errNoOwner})
+
+			continue
+		}
+
+		req, err := d.request(ctx, mvm, owner)
+		if err != nil {
+			plan.Skipped = append(plan.Skipped, Skipped{MicroVM: mvm, Reason: err})
+
+			continue
+		}
+
+		result, err := d.scheduler.Schedule(req, targets)
+		if err != nil {
+			plan.Skipped = append(plan.Skipped, Skipped{MicroVM: mvm, Reason: err})
+
+			continue
+		}
+
+		// Account for the replacement when placing the microvms after it.
+		result.Host.MicroVMs = append(result.Host.MicroVMs, mvm)
+
+		plan.Steps = append(plan.Steps, &Step{
+			MicroVM: mvm,
+			Owner:   owner,
+			Target:  result.Host.Host,
+			Wave:    waves.add(owner, d.budgetFor(owner)),
+		})
+	}
+
+	sort.SliceStable(plan.Steps, func(i, j int) bool {
+		return plan.Steps[i].Wave < plan.Steps[j].Wave
+	})
+
+	return plan, nil
+}
+
+// list returns the microvms on the host, ordered by owner and then by namespace and id.
+func (d *Drainer) list(ctx context.Context, host microvm.Host) ([]*flintlocktypes.MicroVM, error) {
+	client, err := d.clientFor(host)
+	if err != nil {
+		return nil, fmt.Errorf("creating client for host %s: %w", host.Endpoint, err)
+	}
+	defer client.Close()
+
+	resp, err := client.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("listing microvms on host %s: %w", host.Endpoint, err)
+	}
+
+	microvms := resp.GetMicrovm()
+
+	sort.SliceStable(microvms, func(i, j int) bool {
+		a, b := microvms[i].GetSpec(), microvms[j].GetSpec()
+		ownerA, _ := microvm.OwnerFromLabels(a.GetLabels())
+		ownerB, _ := microvm.OwnerFromLabels(b.GetLabels())
+
+		if ownerA != ownerB {
+			return ownerA.String() < ownerB.String()
+		}
+
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+
+		return a.GetId() < b.GetId()
+	})
+
+	return microvms, nil
+}
+
+func (d *Drainer) budgetFor(owner microvm.Owner) int {
+	if d.budget == nil {
+		return 0
+	}
+
+	if budget := d.budget(owner); budget > 1 {
+		return budget
+	}
+
+	return 1
+}
+
+// waves assigns the steps of a plan to waves.
+type waves struct {
+	maxUnavailable int
+	total          []int
+	owners         map[microvm.Owner][]int
+}
+
+func newWaves(maxUnavailable int) *waves {
+	if maxUnavailable < 1 {
+		maxUnavailable = 1
+	}
+
+	return &waves{maxUnavailable: maxUnavailable, owners: map[microvm.Owner][]int{}}
+}
+
+// add returns the first wave with room for another microvm of the owner, and
+// counts the microvm in it. A budget of 0 doesn't limit the microvms of the owner.
+func (w *waves) add(owner microvm.Owner, budget int) int {
+	wave := 0
+
+	for ; wave < len(w.total); wave++ {
+		if w.total[wave] >= w.maxUnavailable {
+			continue
+		}
+
+		if budget > 0 && wave < len(w.owners[owner]) && w.owners[owner][wave] >= budget {
+			continue
+		}
+
+		break
+	}
+
+	if wave == len(w.total) {
+		w.total = append(w.total, 0)
+	}
+
+	for len(w.owners[owner]) <= wave {
+		w.owners[owner] = append(w.owners[owner], 0)
+	}
+
+	w.total[wave]++
+	w.owners[owner][wave]++
+
+	return wave
+}
+
+// RequestFromMicroVM returns the placement request for a replacement of the
+// microvm, from its flintlock spec. It has no placement constraints, host selector
+// or tolerations, as flintlock doesn't store them.
+func RequestFromMicroVM(_ context.Context, mvm *flintlocktypes.MicroVM, _ microvm.Owner) (*placement.Request, error) {
+	spec := mvm.GetSpec()
+
+	return &placement.Request{
+		Spec: microvm.VMSpec{
+			Provider: spec.GetProvider(),
+			VCPU:     int64(spec.GetVcpu()),
+			MemoryMb: int64(spec.GetMemoryInMb()),
+		},
+		Labels: spec.GetLabels(),
+	}, nil
+}
@@ -0,0 +1,402 @@
+package drain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/pointer"
+
+	flclient "github.com/liquidmetal-dev/controller-pkg/client"
+	microvmsvc "github.com/liquidmetal-dev/controller-pkg/services/microvm"
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/placement"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+type fakeClient struct {
+	flintlockv1.MicroVMClient
+
+	mu        sync.Mutex
+	microvms  []*flintlocktypes.MicroVM
+	created   []string
+	specs     []*flintlocktypes.MicroVMSpec
+	deleted   []string
+	createErr error
+	state     *flintlocktypes.MicroVMStatus_MicroVMState
+}
+
+func (f *fakeClient) ListMicroVMs(
+	context.Context, *flintlockv1.ListMicroVMsRequest, ...grpc.CallOption,
+) (*flintlockv1.ListMicroVMsResponse, error) {
+	return &flintlockv1.ListMicroVMsResponse{Microvm: f.microvms}, nil
+}
+
+func (f *fakeClient) CreateMicroVM(
+	_ context.Context, req *flintlockv1.CreateMicroVMRequest, _ ...grpc.CallOption,
+) (*flintlockv1.CreateMicroVMResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+
+	f.created = append(f.created, req.Microvm.Id)
+	f.specs = append(f.specs, req.Microvm)
+	uid := "new-" + req.Microvm.Id
+	req.Microvm.Uid = &uid
+
+	return &flintlockv1.CreateMicroVMResponse{Microvm: &flintlocktypes.MicroVM{Spec: req.Microvm}}, nil
+}
+
+func (f *fakeClient) GetMicroVM(
+	_ context.Context, req *flintlockv1.GetMicroVMRequest, _ ...grpc.CallOption,
+) (*flintlockv1.GetMicroVMResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state := flintlocktypes.MicroVMStatus_CREATED
+	if f.state != nil {
+		state = *f.state
+	}
+
+	for _, spec := range f.specs {
+		if spec.GetUid() == req.Uid {
+			return &flintlockv1.GetMicroVMResponse{Microvm: &flintlocktypes.MicroVM{
+				Spec:   spec,
+				Status: &flintlocktypes.MicroVMStatus{State: state},
+			}}, nil
+		}
+	}
+
+	return nil, status.Error(codes.NotFound, "not found")
+}
+
+func (f *fakeClient) DeleteMicroVM(
+	_ context.Context, req *flintlockv1.DeleteMicroVMRequest, _ ...grpc.CallOption,
+) (*emptypb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.deleted = append(f.deleted, req.Uid)
+
+	return &emptypb.Empty{}, nil
+}
+
+func (f *fakeClient) Close() {}
+
+func mvm(id string, owner string, state flintlocktypes.MicroVMStatus_MicroVMState) *flintlocktypes.MicroVM {
+	uid := "uid-" + id
+	labels := map[string]string{}
+
+	if owner != "" {
+		labels = microvm.Owner{Kind: "Machine", Namespace: "default", Name: owner}.Labels()
+	}
+
+	return &flintlocktypes.MicroVM{
+		Spec: &flintlocktypes.MicroVMSpec{
+			Id: id, Namespace: "ns", Uid: &uid, Vcpu: 2, MemoryInMb: 2048, Labels: labels,
+			Interfaces: []*flintlocktypes.NetworkInterface{{
+				DeviceId: "eth0",
+				GuestMac: pointer.String("02:00:00:00:00:01"),
+				Address:  &flintlocktypes.StaticAddress{Address: "10.0.0.10/24"},
+			}},
+			Metadata: map[string]string{"user-data": "kubeadm join " + id},
+		},
+		Status: &flintlocktypes.MicroVMStatus{State: state},
+	}
+}
+
+var drainedHost = microvm.Host{
+	Name:     "old",
+	Endpoint: "10.0.0.1:9090",
+	Taints:   []microvm.Taint{{Key: "maintenance", Effect: microvm.TaintEffectNoExecute}},
+}
+
+func testSetup() (map[string]*fakeClient, []placement.HostInfo) {
+	clients := map[string]*fakeClient{
+		"10.0.0.1:9090": {microvms: []*flintlocktypes.MicroVM{
+			mvm("a-3", "a", flintlocktypes.MicroVMStatus_CREATED),
+			mvm("orphan", "", flintlocktypes.MicroVMStatus_CREATED),
+			mvm("a-1", "a", flintlocktypes.MicroVMStatus_CREATED),
+			mvm("b-1", "b", flintlocktypes.MicroVMStatus_CREATED),
+			mvm("a-2", "a", flintlocktypes.MicroVMStatus_FAILED),
+			mvm("a-4", "a", flintlocktypes.MicroVMStatus_DELETING),
+		}},
+		"10.0.0.2:9090": {},
+		"10.0.0.3:9090": {},
+	}
+
+	candidates := []placement.HostInfo{
+		{Host: drainedHost},
+		{Host: microvm.Host{Name: "new1", Endpoint: "10.0.0.2:9090"}, Capacity: placement.Resources{VCPU: 4, MemoryMiB: 4096}},
+		{Host: microvm.Host{Name: "new2", Endpoint: "10.0.0.3:9090"}, Capacity: placement.Resources{VCPU: 4, MemoryMiB: 4096}},
+	}
+
+	return clients, candidates
+}
+
+func clientFunc(clients map[string]*fakeClient) placement.ClientFunc {
+	return func(host microvm.Host) (flclient.Client, error) {
+		return clients[host.Endpoint], nil
+	}
+}
+
+func replacer(clients map[string]*fakeClient, opts ...CloneOption) ReplaceFunc {
+	metadata := func(_ context.Context, _ *Step, name microvmsvc.MicroVMName) (map[string]string, error) {
+		return map[string]string{"user-data": "kubeadm join " + name.ID}, nil
+	}
+
+	opts = append([]CloneOption{WithReplacementName(func(step *Step) string {
+		return step.MicroVM.Spec.Id + "-new"
+	})}, opts...)
+
+	return CloneReplacer(clientFunc(clients), metadata, opts...)
+}
+
+func stepIDs(steps []*Step) []string {
+	ids := []string{}
+
+	for _, step := range steps {
+		ids = append(ids, step.MicroVM.Spec.Id+"@"+step.Target.Name)
+	}
+
+	return ids
+}
+
+func Test_Plan(t *testing.T) {
+	g := NewWithT(t)
+
+	clients, candidates := testSetup()
+
+	budget := func(owner microvm.Owner) int {
+		if owner.Name == "a" {
+			return 2
+		}
+
+		return 0
+	}
+
+	drainer := New(clientFunc(clients), WithMaxUnavailable(3), WithBudget(budget))
+
+	plan, err := drainer.Plan(context.TODO(), drainedHost, candidates)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(stepIDs(plan.Steps)).To(Equal([]string{"a-1@new1", "a-2@new2", "b-1@new2", "a-3@new1"}))
+	g.Expect(plan.Waves()).To(HaveLen(2))
+	g.Expect(stepIDs(plan.Waves()[1])).To(Equal([]string{"a-3@new1"}))
+	g.Expect(plan.Steps[0].Owner).To(Equal(microvm.Owner{Kind: "Machine", Namespace: "default", Name: "a"}))
+
+	g.Expect(plan.Complete()).To(BeFalse())
+	g.Expect(plan.Skipped).To(HaveLen(2))
+	g.Expect(plan.Skipped[0].MicroVM.Spec.Id).To(Equal("orphan"))
+	g.Expect(plan.Skipped[0].Reason).To(MatchError(errNoOwner))
+	g.Expect(plan.Skipped[1].MicroVM.Spec.Id).To(Equal("a-4"))
+	g.Expect(plan.Skipped[1].Reason).To(MatchError(errDeleting))
+
+	g.Expect(candidates[1].MicroVMs).To(BeEmpty())
+}
+
+func Test_PlanMaxUnavailable(t *testing.T) {
+	g := NewWithT(t)
+
+	clients, candidates := testSetup()
+	clients["10.0.0.1:9090"].microvms = []*flintlocktypes.MicroVM{
+		mvm("a", "a", flintlocktypes.MicroVMStatus_CREATED),
+		mvm("b", "b", flintlocktypes.MicroVMStatus_CREATED),
+		mvm("c", "c", flintlocktypes.MicroVMStatus_CREATED),
+	}
+
+	plan, err := New(clientFunc(clients)).Plan(context.TODO(), drainedHost, candidates)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(plan.Waves()).To(HaveLen(3))
+
+	plan, err = New(clientFunc(clients), WithMaxUnavailable(2)).Plan(context.TODO(), drainedHost, candidates)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(plan.Waves()).To(HaveLen(2))
+	g.Expect(stepIDs(plan.Waves()[1])).To(Equal([]string{"c@new1"}))
+}
+
+func Test_PlanRequestFunc(t *testing.T) {
+	g := NewWithT(t)
+
+	clients, candidates := testSetup()
+	candidates[2].Host.Labels = map[string]string{microvm.ZoneLabel: "zone-b"}
+
+	request := func(ctx context.Context, mvm *flintlocktypes.MicroVM, owner microvm.Owner) (*placement.Request, error) {
+		if owner.Name == "b" {
+			return nil, errors.New("owner not found")
+		}
+
+		req, err := RequestFromMicroVM(ctx, mvm, owner)
+		if err != nil {
+			return nil, err
+		}
+
+		req.HostSelector = labels.SelectorFromSet(labels.Set{microvm.ZoneLabel: "zone-b"})
+
+		return req, nil
+	}
+
+	plan, err := New(clientFunc(clients), WithRequestFunc(request)).Plan(context.TODO(), drainedHost, candidates)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(stepIDs(plan.Steps)).To(Equal([]string{"a-1@new2", "a-2@new2"}))
+	g.Expect(plan.Skipped[3].MicroVM.Spec.Id).To(Equal("b-1"))
+	g.Expect(plan.Skipped[3].Reason).To(MatchError("owner not found"))
+}
+
+func Test_PlanUnschedulable(t *testing.T) {
+	g := NewWithT(t)
+
+	clients, candidates := testSetup()
+	candidates = candidates[:2]
+
+	plan, err := New(clientFunc(clients)).Plan(context.TODO(), drainedHost, candidates)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(stepIDs(plan.Steps)).To(Equal([]string{"a-1@new1", "a-2@new1"}))
+	g.Expect(plan.Skipped).To(HaveLen(4))
+	g.Expect(plan.Skipped[1].Reason).To(MatchError(placement.ErrUnschedulable))
+
+	host := drainedHost
+	host.Taints = nil
+
+	_, err = New(clientFunc(clients)).Plan(context.TODO(), host, candidates)
+	g.Expect(err).To(MatchError(ErrHostNotCordoned))
+}
+
+func Test_Execute(t *testing.T) {
+	g := NewWithT(t)
+
+	clients, candidates := testSetup()
+
+	phases := map[string][]Phase{}
+	progress := func(p Progress) {
+		phases[p.Step.MicroVM.Spec.Id] = append(phases[p.Step.MicroVM.Spec.Id], p.Phase)
+	}
+
+	var mu sync.Mutex
+
+	ready := []string{}
+	readyFunc := func(_ context.Context, step *Step, replacement *flintlocktypes.MicroVM) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		ready = append(ready, replacement.Spec.GetUid())
+
+		return nil
+	}
+
+	drainer := New(clientFunc(clients),
+		WithProgress(progress), WithReadyFunc(readyFunc), WithReplaceFunc(replacer(clients)))
+
+	plan, err := drainer.Plan(context.TODO(), drainedHost, candidates)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(plan.Waves()).To(HaveLen(4))
+
+	g.Expect(drainer.Execute(context.TODO(), plan)).To(Succeed())
+
+	g.Expect(clients["10.0.0.2:9090"].created).To(ConsistOf("a-1-new", "a-3-new"))
+	g.Expect(clients["10.0.0.3:9090"].created).To(ConsistOf("b-1-new", "a-2-new"))
+	g.Expect(clients["10.0.0.1:9090"].deleted).To(ConsistOf("uid-a-1", "uid-a-2", "uid-a-3", "uid-b-1"))
+	g.Expect(ready).To(ConsistOf("new-a-1-new", "new-a-2-new", "new-a-3-new", "new-b-1-new"))
+	g.Expect(phases["a-1"]).To(Equal([]Phase{
+		PhaseCreatingReplacement, PhaseWaitingForReplacement, PhaseDeletingOriginal, PhaseCompleted,
+	}))
+
+	// The plan's microvms still have their original uids.
+	g.Expect(plan.Steps[0].MicroVM.Spec.GetUid()).To(Equal("uid-a-1"))
+}
+
+func Test_ExecuteFailure(t *testing.T) {
+	g := NewWithT(t)
+
+	clients, candidates := testSetup()
+	clients["10.0.0.3:9090"].createErr = errors.New("out of disk")
+
+	failed := []Progress{}
+	progress := func(p Progress) {
+		if p.Phase == PhaseFailed {
+			failed = append(failed, p)
+		}
+	}
+
+	drainer := New(clientFunc(clients), WithMaxUnavailable(2), WithBudget(func(microvm.Owner) int { return 1 }),
+		WithProgress(progress), WithReplaceFunc(replacer(clients)))
+
+	plan, err := drainer.Plan(context.TODO(), drainedHost, candidates)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	err = drainer.Execute(context.TODO(), plan)
+	g.Expect(err).To(MatchError(ContainSubstring("draining host 10.0.0.1:9090, wave 0: evacuating microvm ns/b-1")))
+	g.Expect(err).To(MatchError(ContainSubstring("out of disk")))
+
+	g.Expect(failed).To(HaveLen(1))
+	g.Expect(clients["10.0.0.2:9090"].created).To(Equal([]string{"a-1-new"}))
+	g.Expect(clients["10.0.0.1:9090"].deleted).To(Equal([]string{"uid-a-1"}))
+
+	g.Expect(New(clientFunc(clients)).Execute(context.TODO(), plan)).To(MatchError(ErrNoReplaceFunc))
+}
+
+func Test_ExecuteWaitsForRunning(t *testing.T) {
+	g := NewWithT(t)
+
+	clients, candidates := testSetup()
+	failed := flintlocktypes.MicroVMStatus_FAILED
+	clients["10.0.0.3:9090"].state = &failed
+
+	drainer := New(clientFunc(clients), WithMaxUnavailable(2), WithBudget(func(microvm.Owner) int { return 1 }),
+		WithReplaceFunc(replacer(clients)))
+
+	plan, err := drainer.Plan(context.TODO(), drainedHost, candidates)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	err = drainer.Execute(context.TODO(), plan)
+	g.Expect(err).To(MatchError(ContainSubstring("evacuating microvm ns/b-1: waiting for replacement")))
+	g.Expect(err).To(MatchError(microvmsvc.ErrMicroVMFailed))
+
+	g.Expect(clients["10.0.0.3:9090"].created).To(Equal([]string{"b-1-new"}))
+	g.Expect(clients["10.0.0.1:9090"].deleted).To(Equal([]string{"uid-a-1"}))
+}
+
+func Test_CloneReplacer(t *testing.T) {
+	g := NewWithT(t)
+
+	clients, _ := testSetup()
+	original := clients["10.0.0.1:9090"].microvms[0]
+
+	step := &Step{
+		MicroVM: original,
+		Owner:   microvm.Owner{Kind: "Machine", Namespace: "default", Name: "a"},
+		Target:  microvm.Host{Endpoint: "10.0.0.2:9090"},
+	}
+
+	replacement, err := replacer(clients, WithNamingStrategy(microvmsvc.ClusterPrefixedNaming("mgmt")))(context.TODO(), step)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	spec := replacement.Spec
+	g.Expect(spec.Id).To(Equal("a-3-new"))
+	g.Expect(spec.Namespace).To(Equal("mgmt-default"))
+	g.Expect(spec.GetUid()).To(Equal("new-a-3-new"))
+	g.Expect(spec.Interfaces[0].GuestMac).To(BeNil())
+	g.Expect(spec.Interfaces[0].Address).To(BeNil())
+	g.Expect(spec.Metadata).To(Equal(map[string]string{"user-data": "kubeadm join a-3-new"}))
+	g.Expect(spec.Labels).To(Equal(original.Spec.Labels))
+
+	// The original is unchanged.
+	g.Expect(original.Spec.Id).To(Equal("a-3"))
+	g.Expect(original.Spec.Interfaces[0].GetGuestMac()).To(Equal("02:00:00:00:00:01"))
+	g.Expect(original.Spec.Metadata["user-data"]).To(Equal("kubeadm join a-3"))
+
+	generated := GenerateName(step)
+	g.Expect(generated).To(HavePrefix("a-3-"))
+	g.Expect(generated).To(HaveLen(len("a-3-") + generatedSuffixLength))
+}
@@ -0,0 +1,282 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package drain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+
+	microvmsvc "github.com/liquidmetal-dev/controller-pkg/services/microvm"
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/placement"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+// Phase is the progress of a step of a plan.
+type Phase string
+
+const (
+	// PhaseCreatingReplacement is when the replacement is being created on the target.
+	PhaseCreatingReplacement = Phase("CreatingReplacement")
+	// PhaseWaitingForReplacement is when the replacement has been requested and is
+	// being checked by the ReadyFunc.
+	PhaseWaitingForReplacement = Phase("WaitingForReplacement")
+	// PhaseDeletingOriginal is when the replacement is ready and the original is
+	// being deleted.
+	PhaseDeletingOriginal = Phase("DeletingOriginal")
+	// PhaseCompleted is when the microvm has been evacuated.
+	PhaseCompleted = Phase("Completed")
+	// PhaseFailed is when the step failed. The original is never deleted unless its
+	// replacement has been created and is ready.
+	PhaseFailed = Phase("Failed")
+)
+
+// ReplaceFunc creates the replacement of the microvm of the step on the target host.
+type ReplaceFunc func(ctx context.Context, step *Step) (*flintlocktypes.MicroVM, error)
+
+// ReadyFunc returns an error if the replacement of the microvm of the step isn't
+// ready, such as if it fails to start.
+type ReadyFunc func(ctx context.Context, step *Step, replacement *flintlocktypes.MicroVM) error
+
+// Progress is an update on a step of a plan being executed.
+type Progress struct {
+	// Step is the step.
+	Step *Step
+	// Phase is the phase the step has reached.
+	Phase Phase
+	// Replacement is the replacement microvm, once it has been created.
+	Replacement *flintlocktypes.MicroVM
+	// Err is why the step failed, in PhaseFailed.
+	Err error
+}
+
+// ProgressFunc is called as the steps of a plan progress. Calls are serialised,
+// even though the steps of a wave are executed concurrently.
+type ProgressFunc func(Progress)
+
+// MetadataFunc returns the metadata of the replacement of the microvm of the step,
+// keyed by metadata key (user-data, vendor-data, meta-data), for a microvm with the
+// name. The metadata of the original can't be reused, as its bootstrap data, such
+// as a kubeadm join, has already run.
+type MetadataFunc func(ctx context.Context, step *Step, name microvmsvc.MicroVMName) (map[string]string, error)
+
+// CloneOption is a func to configure CloneReplacer.
+type CloneOption func(*cloner)
+
+type cloner struct {
+	naming  microvmsvc.NamingStrategy
+	nameFor func(step *Step) string
+}
+
+// WithNamingStrategy sets how the replacement is named, which should be the naming
+// strategy of the service which created the microvms. The strategy is given the
+// generated name of the replacement and the namespace of the owner. Without it, the
+// replacement keeps the flintlock namespace of the original.
+func WithNamingStrategy(strategy microvmsvc.NamingStrategy) CloneOption {
+	return func(c *cloner) {
+		c.naming = strategy
+	}
+}
+
+// WithReplacementName sets how the name of the replacement is generated, before it
+// is mapped by the naming strategy. The default is GenerateName.
+func WithReplacementName(nameFor func(step *Step) string) CloneOption {
+	return func(c *cloner) {
+		c.nameFor = nameFor
+	}
+}
+
+// GenerateName returns the id of the microvm of the step with a random suffix.
+func GenerateName(step *Step) string {
+	return step.MicroVM.GetSpec().GetId() + "-" + utilrand.String(generatedSuffixLength)
+}
+
+// CloneReplacer returns a ReplaceFunc which creates a copy of the microvm on the
+// target host with a new name, and metadata from the metadata func. The guest MACs
+// and static addresses of the network interfaces are cleared, so the replacement
+// doesn't conflict with the original while both exist.
+func CloneReplacer(clientFor placement.ClientFunc, metadata MetadataFunc, opts ...CloneOption) ReplaceFunc {
+	c := &cloner{nameFor: GenerateName}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return func(ctx context.Context, step *Step) (*flintlocktypes.MicroVM, error) {
+		spec, ok := proto.Clone(step.MicroVM.GetSpec()).(*flintlocktypes.MicroVMSpec)
+		if !ok || spec == nil {
+			return nil, errNoSpec
+		}
+
+		spec.Uid = nil
+		spec.CreatedAt = nil
+		spec.UpdatedAt = nil
+		spec.DeletedAt = nil
+
+		for _, iface := range spec.Interfaces {
+			iface.GuestMac = nil
+			iface.Address = nil
+		}
+
+		name := c.replacementName(step, spec)
+		spec.Id = name.ID
+		spec.Namespace = name.Namespace
+
+		var err error
+
+		spec.Metadata, err = metadata(ctx, step, name)
+		if err != nil {
+			return nil, fmt.Errorf("creating metadata of replacement: %w", err)
+		}
+
+		client, err := clientFor(step.Target)
+		if err != nil {
+			return nil, fmt.Errorf("creating client for host %s: %w", step.Target.Endpoint, err)
+		}
+		defer client.Close()
+
+		resp, err := client.CreateMicroVM(ctx, &flintlockv1.CreateMicroVMRequest{Microvm: spec})
+		if err != nil {
+			return nil, fmt.Errorf("creating microvm on host %s: %w", step.Target.Endpoint, err)
+		}
+
+		return resp.GetMicrovm(), nil
+	}
+}
+
+func (c *cloner) replacementName(step *Step, spec *flintlocktypes.MicroVMSpec) microvmsvc.MicroVMName {
+	name := c.nameFor(step)
+
+	if c.naming == nil {
+		return microvmsvc.MicroVMName{
+			ID:        name,
+			Namespace: spec.GetNamespace(),
+			Hostname:  microvmsvc.SanitizeHostname(name),
+		}
+	}
+
+	return c.naming(name, step.Owner.Namespace)
+}
+
+// WaitForRunning returns a ReadyFunc which waits for the replacement to be running,
+// returning an error if it fails. It is the default ReadyFunc.
+func WaitForRunning(clientFor placement.ClientFunc, opts ...microvmsvc.WaitOption) ReadyFunc {
+	return func(ctx context.Context, step *Step, replacement *flintlocktypes.MicroVM) error {
+		client, err := clientFor(step.Target)
+		if err != nil {
+			return fmt.Errorf("creating client for host %s: %w", step.Target.Endpoint, err)
+		}
+		defer client.Close()
+
+		_, err = microvmsvc.WaitForMicroVMState(ctx, client,
+			replacement.GetSpec().GetUid(), microvm.VMStateRunning, opts...)
+
+		return err
+	}
+}
+
+// Execute carries out the plan one wave at a time. Each microvm is replaced on its
+// target host before the original is deleted. If any step of a wave fails the
+// later waves aren't started, and the errors of the failed steps are returned.
+func (d *Drainer) Execute(ctx context.Context, plan *Plan) error {
+	if d.replace == nil {
+		return ErrNoReplaceFunc
+	}
+
+	var mu sync.Mutex
+
+	report := func(progress Progress) {
+		if d.progress == nil {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		d.progress(progress)
+	}
+
+	for i, wave := range plan.Waves() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		errs := make([]error, len(wave))
+
+		var wg sync.WaitGroup
+
+		for j, step := range wave {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				errs[j] = d.executeStep(ctx, plan, step, report)
+			}()
+		}
+
+		wg.Wait()
+
+		if err := errors.Join(errs...); err != nil {
+			return fmt.Errorf("draining host %s, wave %d: %w", plan.Host.Endpoint, i, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *Drainer) executeStep(ctx context.Context, plan *Plan, step *Step, report func(Progress)) error {
+	fail := func(err error) error {
+		err = fmt.Errorf("evacuating microvm %s/%s: %w",
+			step.MicroVM.GetSpec().GetNamespace(), step.MicroVM.GetSpec().GetId(), err)
+		report(Progress{Step: step, Phase: PhaseFailed, Err: err})
+
+		return err
+	}
+
+	report(Progress{Step: step, Phase: PhaseCreatingReplacement})
+
+	replacement, err := d.replace(ctx, step)
+	if err != nil {
+		return fail(err)
+	}
+
+	report(Progress{Step: step, Phase: PhaseWaitingForReplacement, Replacement: replacement})
+
+	if err := d.ready(ctx, step, replacement); err != nil {
+		return fail(fmt.Errorf("waiting for replacement: %w", err))
+	}
+
+	report(Progress{Step: step, Phase: PhaseDeletingOriginal, Replacement: replacement})
+
+	if err := d.deleteOriginal(ctx, plan, step); err != nil {
+		return fail(err)
+	}
+
+	report(Progress{Step: step, Phase: PhaseCompleted, Replacement: replacement})
+
+	return nil
+}
+
+func (d *Drainer) deleteOriginal(ctx context.Context, plan *Plan, step *Step) error {
+	client, err := d.clientFor(plan.Host)
+	if err != nil {
+		return fmt.Errorf("creating client for host %s: %w", plan.Host.Endpoint, err)
+	}
+	defer client.Close()
+
+	if _, err := client.DeleteMicroVM(ctx, &flintlockv1.DeleteMicroVMRequest{
+		Uid: step.MicroVM.GetSpec().GetUid(),
+	}); err != nil {
+		return fmt.Errorf("deleting microvm on host %s: %w", plan.Host.Endpoint, err)
+	}
+
+	return nil
+}
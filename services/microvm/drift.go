@@ -0,0 +1,212 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	types "github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+// SpecDriftedCondition is the condition type to use when reporting that a microvm no
+// longer matches its spec.
+const SpecDriftedCondition = "SpecDrifted"
+
+// FieldDiff is a difference between the desired and actual value of a field.
+type FieldDiff struct {
+	// Path is the path of the field in the VMSpec.
+	Path string
+	// Desired is the value in the VMSpec.
+	Desired string
+	// Actual is the value of the running microvm.
+	Actual string
+}
+
+// String returns a human readable description of the difference.
+func (d FieldDiff) String() string {
+	return fmt.Sprintf("%s: desired %q, actual %q", d.Path, d.Desired, d.Actual)
+}
+
+// DiffMessage returns a message describing the differences, suitable for a condition.
+func DiffMessage(diffs []FieldDiff) string {
+	msgs := make([]string, 0, len(diffs))
+
+	for _, d := range diffs {
+		msgs = append(msgs, d.String())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Diff compares the spec of the scope with the running flintlock microvm, returning
+// the fields which differ. The spec is resolved and converted the same way as by
+// Create, including the spec mutators and naming strategy of the service. Fields the
+// spec leaves to be defaulted, such as the provider, MAC addresses and file names,
+// are only compared when set in the spec. It returns an error if the spec can't be
+// converted, such as resources which don't fit the flintlock API.
+func (s *Service) Diff(actual *flintlocktypes.MicroVM) ([]FieldDiff, error) {
+	spec, err := s.microvmSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	desired, err := s.converter.convert(s.scope, spec)
+	if err != nil {
+		return nil, fmt.Errorf("converting microvm spec: %w", err)
+	}
+
+	return diff(spec, desired, actual), nil
+}
+
+// diff compares the desired microvm, converted from the spec, with the actual one.
+func diff(spec types.VMSpec, desired *flintlocktypes.MicroVMSpec, actual *flintlocktypes.MicroVM) []FieldDiff {
+	d := &differ{}
+
+	if actual == nil || actual.Spec == nil {
+		d.compare("microvm", "present", "missing")
+
+		return d.diffs
+	}
+
+	current := actual.Spec
+
+	if spec.Provider != "" {
+		d.compare("provider", desired.GetProvider(), current.GetProvider())
+	}
+
+	d.compare("vcpu", desired.Vcpu, current.Vcpu)
+	d.compare("memoryMb", desired.MemoryInMb, current.MemoryInMb)
+
+	d.compareKernel(desired.Kernel, current.Kernel)
+	d.compareInitrd(desired.Initrd, current.Initrd)
+	d.compareVolume("rootVolume", desired.RootVolume, current.RootVolume)
+	d.compareAdditionalVolumes(desired.AdditionalVolumes, current.AdditionalVolumes)
+	d.compareInterfaces(spec.NetworkInterfaces, desired.Interfaces, current.Interfaces)
+
+	return d.diffs
+}
+
+type differ struct {
+	diffs []FieldDiff
+}
+
+func (d *differ) compare(path string, desired, actual interface{}) {
+	if reflect.DeepEqual(desired, actual) {
+		return
+	}
+
+	d.diffs = append(d.diffs, FieldDiff{
+		Path:    path,
+		Desired: fmt.Sprint(desired),
+		Actual:  fmt.Sprint(actual),
+	})
+}
+
+func (d *differ) compareKernel(desired, actual *flintlocktypes.Kernel) {
+	if actual == nil {
+		actual = &flintlocktypes.Kernel{}
+	}
+
+	d.compare("kernel.image", desired.Image, actual.Image)
+
+	if desired.GetFilename() != "" {
+		d.compare("kernel.filename", desired.GetFilename(), actual.GetFilename())
+	}
+
+	d.compare("kernelCmdline",
+		types.KernelCmdLineFromMap(desired.Cmdline).String(),
+		types.KernelCmdLineFromMap(actual.Cmdline).String(),
+	)
+}
+
+func (d *differ) compareInitrd(desired, actual *flintlocktypes.Initrd) {
+	if desired == nil || actual == nil {
+		d.compare("initrd", desired != nil, actual != nil)
+
+		return
+	}
+
+	d.compare("initrd.image", desired.Image, actual.Image)
+
+	if desired.GetFilename() != "" {
+		d.compare("initrd.filename", desired.GetFilename(), actual.GetFilename())
+	}
+}
+
+func (d *differ) compareVolume(path string, desired, actual *flintlocktypes.Volume) {
+	if actual == nil {
+		actual = &flintlocktypes.Volume{}
+	}
+
+	desiredSource, actualSource := desired.GetSource(), actual.GetSource()
+
+	d.compare(path+".id", desired.Id, actual.Id)
+	d.compare(path+".readOnly", desired.IsReadOnly, actual.IsReadOnly)
+	d.compare(path+".image", desiredSource.GetContainerSource(), actualSource.GetContainerSource())
+	d.compare(path+".virtiofsPath", desiredSource.GetVirtiofsSource(), actualSource.GetVirtiofsSource())
+	d.compare(path+".mountPoint", desired.GetMountPoint(), actual.GetMountPoint())
+}
+
+func (d *differ) compareAdditionalVolumes(desired, actual []*flintlocktypes.Volume) {
+	actualByID := map[string]*flintlocktypes.Volume{}
+	for _, vol := range actual {
+		actualByID[vol.Id] = vol
+	}
+
+	for _, vol := range desired {
+		path := fmt.Sprintf("volumes[%s]", vol.Id)
+
+		current, ok := actualByID[vol.Id]
+		if !ok {
+			d.compare(path, "present", "missing")
+
+			continue
+		}
+
+		delete(actualByID, vol.Id)
+		d.compareVolume(path, vol, current)
+	}
+
+	extra := make([]string, 0, len(actualByID))
+	for id := range actualByID {
+		extra = append(extra, id)
+	}
+
+	sort.Strings(extra)
+
+	for _, id := range extra {
+		d.compare(fmt.Sprintf("volumes[%s]", id), "missing", "present")
+	}
+}
+
+func (d *differ) compareInterfaces(
+	ifaces []types.NetworkInterface,
+	desired, actual []*flintlocktypes.NetworkInterface,
+) {
+	if len(desired) != len(actual) {
+		d.compare("networkInterfaces", len(desired), len(actual))
+
+		return
+	}
+
+	for i := range desired {
+		path := fmt.Sprintf("networkInterfaces[%d]", i)
+
+		d.compare(path+".guestDeviceName", desired[i].DeviceId, actual[i].DeviceId)
+
+		if ifaces[i].Type != "" {
+			d.compare(path+".type", desired[i].Type.String(), actual[i].Type.String())
+		}
+
+		if mac := desired[i].GetGuestMac(); mac != "" {
+			d.compare(path+".guestMac", strings.ToLower(mac), strings.ToLower(actual[i].GetGuestMac()))
+		}
+
+		d.compare(path+".address", desired[i].GetAddress().GetAddress(), actual[i].GetAddress().GetAddress())
+	}
+}
@@ -0,0 +1,179 @@
+package microvm
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/pointer"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+func driftSpec() microvm.VMSpec {
+	return microvm.VMSpec{
+		VCPU:       2,
+		MemoryMb:   2048,
+		RootVolume: microvm.Volume{ID: "root", Image: "os:latest"},
+		AdditionalVolumes: []microvm.Volume{
+			{ID: "data", Image: "data:latest", MountPoint: "/data"},
+		},
+		Kernel:        microvm.ContainerFileSource{Image: "kernel:latest"},
+		KernelCmdLine: microvm.KernelCmdLine{{Name: "console", Value: "ttyS0"}, {Name: "quiet"}},
+		NetworkInterfaces: []microvm.NetworkInterface{
+			{GuestDeviceName: "eth0"},
+			{GuestDeviceName: "eth1", Type: microvm.IfaceTypeTap, GuestMAC: "02:00:00:00:00:01", Address: "10.0.0.2/24"},
+		},
+	}
+}
+
+func Test_Diff(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name     string
+		spec     func(*microvm.VMSpec)
+		actual   func(*flintlocktypes.MicroVMSpec)
+		expected []string
+	}{
+		{
+			name:     "no drift",
+			spec:     func(*microvm.VMSpec) {},
+			actual:   func(*flintlocktypes.MicroVMSpec) {},
+			expected: []string{},
+		},
+		{
+			name: "defaulted fields are ignored",
+			spec: func(*microvm.VMSpec) {},
+			actual: func(s *flintlocktypes.MicroVMSpec) {
+				s.Provider = pointer.String(microvm.ProviderFirecracker)
+				s.Kernel.Filename = pointer.String("boot/vmlinux")
+				s.Interfaces[0].GuestMac = pointer.String("02:00:00:00:00:02")
+				s.Interfaces[1].GuestMac = pointer.String("02:00:00:00:00:01")
+			},
+			expected: []string{},
+		},
+		{
+			name: "spec changed",
+			spec: func(s *microvm.VMSpec) {
+				s.Provider = microvm.ProviderCloudHypervisor
+				s.VCPU = 4
+				s.KernelCmdLine[1].Value = "1"
+				s.AdditionalVolumes = append(s.AdditionalVolumes, microvm.Volume{ID: "extra", Image: "extra:latest"})
+				s.NetworkInterfaces[1].GuestMAC = "02:00:00:00:00:03"
+			},
+			actual: func(*flintlocktypes.MicroVMSpec) {},
+			expected: []string{
+				"provider",
+				"vcpu",
+				"kernelCmdline",
+				"volumes[extra]",
+				"networkInterfaces[1].guestMac",
+			},
+		},
+		{
+			name: "microvm changed on the host",
+			spec: func(*microvm.VMSpec) {},
+			actual: func(s *flintlocktypes.MicroVMSpec) {
+				s.MemoryInMb = 1024
+				s.RootVolume.IsReadOnly = true
+				s.AdditionalVolumes = append(s.AdditionalVolumes, &flintlocktypes.Volume{Id: "other"})
+				s.Interfaces = s.Interfaces[:1]
+				s.Initrd = &flintlocktypes.Initrd{Image: "initrd:latest"}
+			},
+			expected: []string{
+				"memoryMb",
+				"initrd",
+				"rootVolume.readOnly",
+				"volumes[other]",
+				"networkInterfaces",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			original := driftSpec()
+			opts, err := VMSpecOptions(&original)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			actual := NewVM(opts...)
+			tc.actual(actual)
+
+			spec := driftSpec()
+			tc.spec(&spec)
+
+			mScope := validScope()
+			mScope.GetMicrovmSpecReturns(spec)
+
+			diffs, err := New(mScope, nil, "host").Diff(&flintlocktypes.MicroVM{Spec: actual})
+			g.Expect(err).NotTo(HaveOccurred())
+
+			paths := []string{}
+			for _, d := range diffs {
+				paths = append(paths, d.Path)
+			}
+
+			g.Expect(paths).To(Equal(tc.expected))
+		})
+	}
+}
+
+func Test_DiffMissing(t *testing.T) {
+	g := NewWithT(t)
+
+	diffs, err := New(validScope(), nil, "host").Diff(nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(diffs).To(HaveLen(1))
+	g.Expect(DiffMessage(diffs)).To(Equal(`microvm: desired "present", actual "missing"`))
+}
+
+func Test_DiffResourceOverflow(t *testing.T) {
+	g := NewWithT(t)
+
+	spec := driftSpec()
+	opts, err := VMSpecOptions(&spec)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	actual := NewVM(opts...)
+
+	// Truncated to an int32 this would equal the actual vcpu.
+	spec.VCPU += math.MaxUint32 + 1
+
+	mScope := validScope()
+	mScope.GetMicrovmSpecReturns(spec)
+
+	_, err = New(mScope, nil, "host").Diff(&flintlocktypes.MicroVM{Spec: actual})
+	g.Expect(err).To(MatchError(errResourceOverflow))
+}
+
+func Test_DiffUsesServicePipeline(t *testing.T) {
+	g := NewWithT(t)
+
+	baseline := microvm.KernelCmdLine{{Name: "panic", Value: "1"}}
+
+	spec := driftSpec()
+	spec.NetworkInterfaces[0].Type = microvm.IfaceTypeMacvtap
+
+	mScope := validScope()
+	mScope.NameReturns("Worker_" + strings.Repeat("x", 70))
+	mScope.GetMicrovmSpecReturns(spec)
+
+	svc := New(mScope, nil, "host",
+		WithSpecMutators(RewriteRegistry("", "registry.internal/"), KernelCmdlineBaseline(baseline)),
+		WithNamingStrategy(HashedNaming(30, nil)),
+	)
+
+	rendered, err := svc.Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	actual := rendered.Request.Microvm
+	g.Expect(actual.Kernel.Image).To(Equal("registry.internal/kernel:latest"))
+
+	diffs, err := svc.Diff(&flintlocktypes.MicroVM{Spec: actual})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(diffs).To(BeEmpty())
+}
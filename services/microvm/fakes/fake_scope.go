@@ -10,6 +10,9 @@ type FakeScope struct {
 	ns     string
 	labels map[string]string
 	spec   microvm.VMSpec
+	keys   []microvm.SSHPublicKey
+	data   string
+	uid    string
 }
 
 func (s *FakeScope) NameReturns(name string) string {
@@ -48,14 +51,29 @@ func (s *FakeScope) GetMicrovmSpec() microvm.VMSpec {
 	return s.spec
 }
 
+func (s *FakeScope) GetInstanceIDReturns(uid string) string {
+	s.uid = uid
+	return s.GetInstanceID()
+}
+
 func (s *FakeScope) GetInstanceID() string {
-	return ""
+	return s.uid
+}
+
+func (s *FakeScope) GetRawBootstrapDataReturns(data string) (string, error) {
+	s.data = data
+	return s.GetRawBootstrapData()
 }
 
 func (s *FakeScope) GetRawBootstrapData() (string, error) {
-	return "", nil
+	return s.data, nil
+}
+
+func (s *FakeScope) GetSSHPublicKeysReturns(keys []microvm.SSHPublicKey) []microvm.SSHPublicKey {
+	s.keys = keys
+	return s.GetSSHPublicKeys()
 }
 
 func (s *FakeScope) GetSSHPublicKeys() []microvm.SSHPublicKey {
-	return nil
+	return s.keys
 }
@@ -0,0 +1,441 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package gc finds and deletes orphaned microvms: microvms on flintlock hosts whose
+// owner, recorded in their ownership labels, no longer exists.
+package gc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/placement"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const (
+	// DefaultGracePeriod is how long a microvm must be orphaned before it is deleted
+	// when no grace period is configured.
+	DefaultGracePeriod = 10 * time.Minute
+	// DefaultInterval is how often Start collects orphans when no interval is configured.
+	DefaultInterval = 5 * time.Minute
+)
+
+// OwnerExistsFunc returns whether the owner of a microvm still exists.
+type OwnerExistsFunc func(ctx context.Context, owner microvm.Owner) (bool, error)
+
+// HostsFunc returns the hosts to collect orphans from.
+type HostsFunc func(ctx context.Context) ([]microvm.Host, error)
+
+// StaticHosts returns a HostsFunc for a fixed set of hosts.
+func StaticHosts(hosts ...microvm.Host) HostsFunc {
+	return func(context.Context) ([]microvm.Host, error) {
+		return hosts, nil
+	}
+}
+
+// ManagedBy returns a selector of the microvms created with the managed-by label
+// managedBy, such as set by the WithManagedBy option of the microvm service, in the
+// cluster clusterName. An empty clusterName selects microvms without a cluster, such
+// as those created for scopes which don't implement OwnershipScope.
+func ManagedBy(managedBy, clusterName string) labels.Selector {
+	selector := labels.SelectorFromSet(labels.Set{microvm.ManagedByLabel: managedBy})
+
+	if clusterName != "" {
+		return selector.Add(mustRequirement(microvm.ClusterNameLabel, selection.Equals, clusterName))
+	}
+
+	return selector.Add(mustRequirement(microvm.ClusterNameLabel, selection.DoesNotExist))
+}
+
+func mustRequirement(key string, op selection.Operator, values ...string) labels.Requirement {
+	requirement, err := labels.NewRequirement(key, op, values)
+	if err != nil {
+		panic(err)
+	}
+
+	return *requirement
+}
+
+// Action is what the collector did with an orphan.
+type Action string
+
+const (
+	// ActionDeleted is an orphan which was deleted.
+	ActionDeleted = Action("Deleted")
+	// ActionWouldDelete is an orphan which would have been deleted, in dry-run mode.
+	ActionWouldDelete = Action("WouldDelete")
+	// ActionPending is an orphan within its grace period.
+	ActionPending = Action("Pending")
+	// ActionRateLimited is an orphan which will be deleted once the rate limit allows.
+	ActionRateLimited = Action("RateLimited")
+	// ActionFailed is a microvm which couldn't be checked or deleted.
+	ActionFailed = Action("Failed")
+)
+
+// Entry is an orphaned microvm in a report.
+type Entry struct {
+	// Host is the host the microvm is on.
+	Host microvm.Host
+	// MicroVM is the microvm.
+	MicroVM *flintlocktypes.MicroVM
+	// Owner is the owner of the microvm, from its ownership labels.
+	Owner microvm.Owner
+	// OrphanedSince is when the collector first found the microvm orphaned.
+	OrphanedSince time.Time
+	// Action is what the collector did with the microvm.
+	Action Action
+	// Err is why the action failed, for ActionFailed.
+	Err error
+}
+
+// Report is the result of collecting orphans once.
+type Report struct {
+	// DryRun is true if the collector is in dry-run mode, so nothing was deleted.
+	DryRun bool
+	// Entries are the orphans found, and microvms whose owner couldn't be checked,
+	// ordered by host and microvm.
+	Entries []Entry
+	// HostErrors are the hosts which couldn't be listed, keyed by endpoint.
+	HostErrors map[string]error
+}
+
+// Filter returns the entries with the action.
+func (r *Report) Filter(action Action) []Entry {
+	entries := []Entry{}
+
+	for _, entry := range r.Entries {
+		if entry.Action == action {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Collector finds orphaned microvms and deletes them once they have been orphaned
+// for the grace period. Microvms without ownership labels, or which don't match the
+// selector of the collector, are never collected.
+type Collector struct {
+	hosts       HostsFunc
+	clientFor   placement.ClientFunc
+	ownerExists OwnerExistsFunc
+	selector    labels.Selector
+
+	gracePeriod  time.Duration
+	interval     time.Duration
+	dryRun       bool
+	maxDeletions int
+	ratePeriod   time.Duration
+	onReport     func(*Report, error)
+
+	now       func() time.Time
+	orphaned  map[orphanKey]time.Time
+	deletions []time.Time
+}
+
+// Option is a func to add an option to the collector.
+type Option func(*Collector)
+
+// WithGracePeriod sets how long a microvm must be orphaned before it is deleted, so
+// microvms created before their owner is visible, such as from a cache, are kept.
+func WithGracePeriod(d time.Duration) Option {
+	return func(c *Collector) {
+		c.gracePeriod = d
+	}
+}
+
+// WithInterval sets how often Start collects orphans.
+func WithInterval(d time.Duration) Option {
+	return func(c *Collector) {
+		c.interval = d
+	}
+}
+
+// WithDryRun reports the orphans which would be deleted without deleting them. The
+// rate limit isn't applied.
+func WithDryRun() Option {
+	return func(c *Collector) {
+		c.dryRun = true
+	}
+}
+
+// WithRateLimit limits the collector to deleting at most maxDeletions microvms in
+// any period. Orphans over the limit are deleted by later collections.
+func WithRateLimit(maxDeletions int, period time.Duration) Option {
+	return func(c *Collector) {
+		c.maxDeletions = maxDeletions
+		c.ratePeriod = period
+	}
+}
+
+// WithReportFunc sets a func called with the report, or error, of each collection
+// by Start.
+func WithReportFunc(onReport func(*Report, error)) Option {
+	return func(c *Collector) {
+		c.onReport = onReport
+	}
+}
+
+// New returns a collector of the orphans on the hosts matching the selector, using
+// clientFor to connect to them and ownerExists to check the owners of their
+// microvms. The selector must only match microvms whose owners ownerExists can see,
+// such as with ManagedBy, as hosts can be shared with other management clusters
+// whose owners never exist locally. A nil selector matches nothing.
+func New(
+	hosts HostsFunc,
+	clientFor placement.ClientFunc,
+	ownerExists OwnerExistsFunc,
+	selector labels.Selector,
+	opts ...Option,
+) *Collector {
+	if selector == nil {
+		selector = labels.Nothing()
+	}
+
+	c := &Collector{
+		hosts:       hosts,
+		clientFor:   clientFor,
+		ownerExists: ownerExists,
+		selector:    selector,
+		gracePeriod: DefaultGracePeriod,
+		interval:    DefaultInterval,
+		now:         time.Now,
+		orphaned:    map[orphanKey]time.Time{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Start collects orphans every interval until the context is done. It can be added
+// to a controller-runtime manager as a Runnable.
+func (c *Collector) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := c.Collect(ctx)
+		if c.onReport != nil {
+			c.onReport(report, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect lists the microvms on the hosts once, deleting the orphans which have
+// passed their grace period. Hosts which can't be listed are recorded in the report.
+// It must not be called concurrently.
+func (c *Collector) Collect(ctx context.Context) (*Report, error) {
+	hosts, err := c.hosts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting hosts: %w", err)
+	}
+
+	report := &Report{DryRun: c.dryRun, Entries: []Entry{}, HostErrors: map[string]error{}}
+	owners := map[microvm.Owner]bool{}
+	seen := map[orphanKey]bool{}
+
+	for _, host := range hosts {
+		microvms, err := c.list(ctx, host)
+		if err != nil {
+			report.HostErrors[host.Endpoint] = err
+
+			// Keep the orphans of the host so their grace period isn't restarted.
+			for key := range c.orphaned {
+				if key.endpoint == host.Endpoint {
+					seen[key] = true
+				}
+			}
+
+			continue
+		}
+
+		for _, mvm := range microvms {
+			entry, ok := c.check(ctx, host, mvm, owners)
+			if !ok {
+				continue
+			}
+
+			seen[keyFor(host, mvm)] = true
+			report.Entries = append(report.Entries, entry)
+		}
+	}
+
+	// Forget microvms which are gone or whose owner has come back.
+	for key := range c.orphaned {
+		if !seen[key] {
+			delete(c.orphaned, key)
+		}
+	}
+
+	for i := range report.Entries {
+		c.collect(ctx, &report.Entries[i])
+	}
+
+	return report, nil
+}
+
+// check returns the report entry for the microvm if it is orphaned, or its owner
+// can't be checked.
+func (c *Collector) check(
+	ctx context.Context,
+	host microvm.Host,
+	mvm *flintlocktypes.MicroVM,
+	owners map[microvm.Owner]bool,
+) (Entry, bool) {
+	mvmLabels := mvm.GetSpec().GetLabels()
+	if !c.selector.Matches(labels.Set(mvmLabels)) {
+		return Entry{}, false
+	}
+
+	owner, ok := microvm.OwnerFromLabels(mvmLabels)
+	if !ok || mvm.GetStatus().GetState() == flintlocktypes.MicroVMStatus_DELETING {
+		return Entry{}, false
+	}
+
+	entry := Entry{Host: host, MicroVM: mvm, Owner: owner}
+
+	exists, checked := owners[owner]
+	if !checked {
+		var err error
+
+		exists, err = c.ownerExists(ctx, owner)
+		if err != nil {
+			entry.Action = ActionFailed
+			entry.Err = fmt.Errorf("checking owner %s exists: %w", owner, err)
+
+			return entry, true
+		}
+
+		owners[owner] = exists
+	}
+
+	if exists {
+		return Entry{}, false
+	}
+
+	key := keyFor(host, mvm)
+	if _, ok := c.orphaned[key]; !ok {
+		c.orphaned[key] = c.now()
+	}
+
+	entry.OrphanedSince = c.orphaned[key]
+
+	return entry, true
+}
+
+// collect decides the action for an orphan, deleting it if it is due.
+func (c *Collector) collect(ctx context.Context, entry *Entry) {
+	if entry.Action == ActionFailed {
+		return
+	}
+
+	now := c.now()
+
+	switch {
+	case now.Sub(entry.OrphanedSince) < c.gracePeriod:
+		entry.Action = ActionPending
+	case c.dryRun:
+		entry.Action = ActionWouldDelete
+	case !c.allowDeletion(now):
+		entry.Action = ActionRateLimited
+	default:
+		if err := c.delete(ctx, entry); err != nil {
+			entry.Action = ActionFailed
+			entry.Err = err
+
+			return
+		}
+
+		entry.Action = ActionDeleted
+		c.deletions = append(c.deletions, now)
+		delete(c.orphaned, keyFor(entry.Host, entry.MicroVM))
+	}
+}
+
+// allowDeletion returns true if deleting a microvm now is within the rate limit.
+func (c *Collector) allowDeletion(now time.Time) bool {
+	if c.maxDeletions <= 0 {
+		return true
+	}
+
+	recent := []time.Time{}
+
+	for _, deletion := range c.deletions {
+		if now.Sub(deletion) < c.ratePeriod {
+			recent = append(recent, deletion)
+		}
+	}
+
+	c.deletions = recent
+
+	return len(recent) < c.maxDeletions
+}
+
+func (c *Collector) list(ctx context.Context, host microvm.Host) ([]*flintlocktypes.MicroVM, error) {
+	client, err := c.clientFor(host)
+	if err != nil {
+		return nil, fmt.Errorf("creating client for host %s: %w", host.Endpoint, err)
+	}
+	defer client.Close()
+
+	resp, err := client.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("listing microvms on host %s: %w", host.Endpoint, err)
+	}
+
+	microvms := resp.GetMicrovm()
+
+	sort.SliceStable(microvms, func(i, j int) bool {
+		a, b := microvms[i].GetSpec(), microvms[j].GetSpec()
+
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+
+		return a.GetId() < b.GetId()
+	})
+
+	return microvms, nil
+}
+
+func (c *Collector) delete(ctx context.Context, entry *Entry) error {
+	client, err := c.clientFor(entry.Host)
+	if err != nil {
+		return fmt.Errorf("creating client for host %s: %w", entry.Host.Endpoint, err)
+	}
+	defer client.Close()
+
+	if _, err := client.DeleteMicroVM(ctx, &flintlockv1.DeleteMicroVMRequest{
+		Uid: entry.MicroVM.GetSpec().GetUid(),
+	}); err != nil {
+		return fmt.Errorf("deleting microvm on host %s: %w", entry.Host.Endpoint, err)
+	}
+
+	return nil
+}
+
+// orphanKey identifies a microvm across collections.
+type orphanKey struct {
+	endpoint string
+	uid      string
+}
+
+func keyFor(host microvm.Host, mvm *flintlocktypes.MicroVM) orphanKey {
+	return orphanKey{endpoint: host.Endpoint, uid: mvm.GetSpec().GetUid()}
+}
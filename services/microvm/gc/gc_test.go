@@ -0,0 +1,295 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"k8s.io/apimachinery/pkg/labels"
+
+	flclient "github.com/liquidmetal-dev/controller-pkg/client"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+type fakeClient struct {
+	flintlockv1.MicroVMClient
+
+	mu       sync.Mutex
+	microvms []*flintlocktypes.MicroVM
+	listErr  error
+	deleted  []string
+}
+
+func (f *fakeClient) ListMicroVMs(
+	context.Context, *flintlockv1.ListMicroVMsRequest, ...grpc.CallOption,
+) (*flintlockv1.ListMicroVMsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &flintlockv1.ListMicroVMsResponse{Microvm: f.microvms}, f.listErr
+}
+
+func (f *fakeClient) DeleteMicroVM(
+	_ context.Context, req *flintlockv1.DeleteMicroVMRequest, _ ...grpc.CallOption,
+) (*emptypb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.deleted = append(f.deleted, req.Uid)
+
+	remaining := []*flintlocktypes.MicroVM{}
+	for _, mvm := range f.microvms {
+		if mvm.Spec.GetUid() != req.Uid {
+			remaining = append(remaining, mvm)
+		}
+	}
+
+	f.microvms = remaining
+
+	return &emptypb.Empty{}, nil
+}
+
+func (f *fakeClient) Close() {}
+
+const testManagedBy = "test-controller"
+
+func ownerLabels(owner, clusterName string) map[string]string {
+	labels := microvm.Owner{Kind: "Machine", Namespace: "default", Name: owner}.Labels()
+	labels[microvm.ManagedByLabel] = testManagedBy
+	labels[microvm.ClusterNameLabel] = clusterName
+
+	return labels
+}
+
+func mvm(id, owner string) *flintlocktypes.MicroVM {
+	uid := "uid-" + id
+	labels := map[string]string{}
+
+	if owner != "" {
+		labels = ownerLabels(owner, "local")
+	}
+
+	return &flintlocktypes.MicroVM{
+		Spec:   &flintlocktypes.MicroVMSpec{Id: id, Namespace: "ns", Uid: &uid, Labels: labels},
+		Status: &flintlocktypes.MicroVMStatus{State: flintlocktypes.MicroVMStatus_CREATED},
+	}
+}
+
+type testEnv struct {
+	hosts   []microvm.Host
+	clients map[string]*fakeClient
+	checked map[string]int
+	now     time.Time
+}
+
+func newTestEnv() *testEnv {
+	deleting := mvm("dead-4", "dead")
+	deleting.Status.State = flintlocktypes.MicroVMStatus_DELETING
+
+	// The owners of the microvms of another management cluster never exist locally.
+	foreign := mvm("foreign-1", "dead")
+	foreign.Spec.Labels = ownerLabels("dead", "other")
+
+	return &testEnv{
+		hosts: []microvm.Host{{Endpoint: "10.0.0.1:9090"}, {Endpoint: "10.0.0.2:9090"}},
+		clients: map[string]*fakeClient{
+			"10.0.0.1:9090": {microvms: []*flintlocktypes.MicroVM{
+				mvm("live-1", "live"), mvm("dead-2", "dead"), mvm("dead-1", "dead"), mvm("unowned", ""),
+			}},
+			"10.0.0.2:9090": {microvms: []*flintlocktypes.MicroVM{
+				mvm("dead-3", "dead"), mvm("flaky-1", "flaky"), deleting, foreign,
+			}},
+		},
+		checked: map[string]int{},
+		now:     time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (e *testEnv) collector(opts ...Option) *Collector {
+	clientFor := func(host microvm.Host) (flclient.Client, error) {
+		return e.clients[host.Endpoint], nil
+	}
+
+	ownerExists := func(_ context.Context, owner microvm.Owner) (bool, error) {
+		e.checked[owner.Name]++
+
+		if owner.Name == "flaky" {
+			return false, errors.New("timeout")
+		}
+
+		return owner.Name == "live", nil
+	}
+
+	c := New(StaticHosts(e.hosts...), clientFor, ownerExists, ManagedBy(testManagedBy, "local"), opts...)
+	c.now = func() time.Time { return e.now }
+
+	return c
+}
+
+func (e *testEnv) setOwner(endpoint, id, owner string) {
+	for _, mvm := range e.clients[endpoint].microvms {
+		if mvm.Spec.Id == id {
+			mvm.Spec.Labels = ownerLabels(owner, "local")
+		}
+	}
+}
+
+func entryIDs(entries []Entry) []string {
+	ids := []string{}
+
+	for _, entry := range entries {
+		ids = append(ids, entry.MicroVM.Spec.Id)
+	}
+
+	return ids
+}
+
+func Test_Collect(t *testing.T) {
+	g := NewWithT(t)
+
+	env := newTestEnv()
+	c := env.collector(WithGracePeriod(time.Hour), WithRateLimit(2, time.Hour))
+
+	report, err := c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.DryRun).To(BeFalse())
+	g.Expect(entryIDs(report.Filter(ActionPending))).To(Equal([]string{"dead-1", "dead-2", "dead-3"}))
+	g.Expect(report.Filter(ActionPending)[0].OrphanedSince).To(Equal(env.now))
+	g.Expect(report.Filter(ActionPending)[0].Owner.Name).To(Equal("dead"))
+
+	failed := report.Filter(ActionFailed)
+	g.Expect(entryIDs(failed)).To(Equal([]string{"flaky-1"}))
+	g.Expect(failed[0].Err).To(MatchError("checking owner Machine/default/flaky exists: timeout"))
+
+	g.Expect(env.checked).To(Equal(map[string]int{"live": 1, "dead": 1, "flaky": 1}))
+
+	env.now = env.now.Add(time.Hour)
+
+	report, err = c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(entryIDs(report.Filter(ActionDeleted))).To(Equal([]string{"dead-1", "dead-2"}))
+	g.Expect(entryIDs(report.Filter(ActionRateLimited))).To(Equal([]string{"dead-3"}))
+	g.Expect(env.clients["10.0.0.1:9090"].deleted).To(Equal([]string{"uid-dead-1", "uid-dead-2"}))
+
+	env.now = env.now.Add(30 * time.Minute)
+
+	report, err = c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(entryIDs(report.Filter(ActionRateLimited))).To(Equal([]string{"dead-3"}))
+
+	env.now = env.now.Add(30 * time.Minute)
+
+	report, err = c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(entryIDs(report.Filter(ActionDeleted))).To(Equal([]string{"dead-3"}))
+	g.Expect(env.clients["10.0.0.2:9090"].deleted).To(Equal([]string{"uid-dead-3"}))
+}
+
+func Test_CollectDryRun(t *testing.T) {
+	g := NewWithT(t)
+
+	env := newTestEnv()
+	c := env.collector(WithDryRun(), WithGracePeriod(time.Hour), WithRateLimit(1, time.Hour))
+
+	_, err := c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	env.now = env.now.Add(time.Hour)
+
+	report, err := c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.DryRun).To(BeTrue())
+	g.Expect(entryIDs(report.Filter(ActionWouldDelete))).To(Equal([]string{"dead-1", "dead-2", "dead-3"}))
+	g.Expect(env.clients["10.0.0.1:9090"].deleted).To(BeEmpty())
+	g.Expect(env.clients["10.0.0.2:9090"].deleted).To(BeEmpty())
+}
+
+func Test_CollectGracePeriod(t *testing.T) {
+	g := NewWithT(t)
+
+	env := newTestEnv()
+	c := env.collector(WithGracePeriod(time.Hour))
+
+	_, err := c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// The host being unavailable doesn't restart the grace period of its orphans.
+	env.now = env.now.Add(30 * time.Minute)
+	env.clients["10.0.0.2:9090"].listErr = errors.New("unavailable")
+
+	report, err := c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.HostErrors).To(HaveKey("10.0.0.2:9090"))
+	g.Expect(entryIDs(report.Entries)).To(Equal([]string{"dead-1", "dead-2"}))
+
+	// dead-1's owner coming back restarts its grace period.
+	env.now = env.now.Add(30 * time.Minute)
+	env.clients["10.0.0.2:9090"].listErr = nil
+	env.setOwner("10.0.0.1:9090", "dead-1", "live")
+
+	report, err = c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(entryIDs(report.Filter(ActionDeleted))).To(Equal([]string{"dead-2", "dead-3"}))
+
+	env.setOwner("10.0.0.1:9090", "dead-1", "dead")
+
+	report, err = c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(entryIDs(report.Filter(ActionPending))).To(Equal([]string{"dead-1"}))
+}
+
+func Test_CollectSkipsOtherClusters(t *testing.T) {
+	g := NewWithT(t)
+
+	env := newTestEnv()
+	c := env.collector(WithGracePeriod(0))
+
+	report, err := c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(entryIDs(report.Filter(ActionDeleted))).To(Equal([]string{"dead-1", "dead-2", "dead-3"}))
+	g.Expect(env.clients["10.0.0.2:9090"].deleted).NotTo(ContainElement("uid-foreign-1"))
+	g.Expect(env.clients["10.0.0.2:9090"].microvms).To(ContainElement(HaveField("Spec.Id", "foreign-1")))
+
+	unclustered := ManagedBy(testManagedBy, "")
+	g.Expect(unclustered.Matches(labels.Set{microvm.ManagedByLabel: testManagedBy})).To(BeTrue())
+	g.Expect(unclustered.Matches(labels.Set(ownerLabels("dead", "local")))).To(BeFalse())
+
+	env = newTestEnv()
+	c = New(StaticHosts(env.hosts...), func(host microvm.Host) (flclient.Client, error) {
+		return env.clients[host.Endpoint], nil
+	}, func(context.Context, microvm.Owner) (bool, error) { return false, nil }, nil)
+
+	report, err = c.Collect(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(report.Entries).To(BeEmpty(), "a nil selector matches nothing")
+}
+
+func Test_Start(t *testing.T) {
+	g := NewWithT(t)
+
+	env := newTestEnv()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	reports := 0
+	c := env.collector(WithInterval(time.Millisecond), WithReportFunc(func(report *Report, err error) {
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(report.Entries).NotTo(BeEmpty())
+
+		reports++
+		if reports == 2 {
+			cancel()
+		}
+	}))
+
+	g.Expect(c.Start(ctx)).To(Succeed())
+	g.Expect(reports).To(Equal(2))
+}
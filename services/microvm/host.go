@@ -0,0 +1,89 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	flclient "github.com/liquidmetal-dev/controller-pkg/client"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+var errSecretKeyMissing = errors.New("secret is missing key")
+
+// SecretGetter returns the data of a secret referred to by a host, such as from
+// the kubernetes API.
+type SecretGetter func(ctx context.Context, ref microvm.SecretReference) (map[string][]byte, error)
+
+// HostClientOptions returns the client options to connect to the host, reading the
+// basic auth token and TLS config from the secrets the host refers to. getSecret
+// is only called when the host refers to a secret.
+func HostClientOptions(ctx context.Context, host *microvm.Host, getSecret SecretGetter) ([]flclient.Options, error) {
+	opts := []flclient.Options{}
+
+	if host.AuthSecretRef != nil {
+		data, err := hostSecret(ctx, *host.AuthSecretRef, getSecret, microvm.AuthTokenSecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("getting auth secret for host %s: %w", host.Endpoint, err)
+		}
+
+		opts = append(opts, flclient.WithBasicAuth(string(data[microvm.AuthTokenSecretKey])))
+	}
+
+	if host.TLSSecretRef != nil {
+		data, err := hostSecret(ctx, *host.TLSSecretRef, getSecret,
+			microvm.TLSCertSecretKey, microvm.TLSKeySecretKey, microvm.TLSCASecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("getting tls secret for host %s: %w", host.Endpoint, err)
+		}
+
+		opts = append(opts, flclient.WithTLS(&flclient.TLSConfig{
+			Cert:   data[microvm.TLSCertSecretKey],
+			Key:    data[microvm.TLSKeySecretKey],
+			CACert: data[microvm.TLSCASecretKey],
+		}))
+	}
+
+	return opts, nil
+}
+
+// NewHostClient returns a client for the host created by factory, with the options
+// from HostClientOptions followed by opts.
+func NewHostClient(
+	ctx context.Context,
+	host *microvm.Host,
+	getSecret SecretGetter,
+	factory flclient.FactoryFunc,
+	opts ...flclient.Options,
+) (flclient.Client, error) {
+	hostOpts, err := HostClientOptions(ctx, host, getSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return factory(host.Endpoint, append(hostOpts, opts...)...)
+}
+
+// hostSecret gets the secret, checking it has the keys.
+func hostSecret(
+	ctx context.Context,
+	ref microvm.SecretReference,
+	getSecret SecretGetter,
+	keys ...string,
+) (map[string][]byte, error) {
+	data, err := getSecret(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if len(data[key]) == 0 {
+			return nil, fmt.Errorf("%w %s: %s", errSecretKeyMissing, ref.Name, key)
+		}
+	}
+
+	return data, nil
+}
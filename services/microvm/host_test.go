@@ -0,0 +1,71 @@
+package microvm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	flclient "github.com/liquidmetal-dev/controller-pkg/client"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+func Test_HostClientOptions(t *testing.T) {
+	g := NewWithT(t)
+
+	secrets := map[string]map[string][]byte{
+		"auth": {microvm.AuthTokenSecretKey: []byte("s3cr3t")},
+		"tls": {
+			microvm.TLSCertSecretKey: []byte("not a cert"),
+			microvm.TLSKeySecretKey:  []byte("not a key"),
+			microvm.TLSCASecretKey:   []byte("not a ca"),
+		},
+	}
+
+	getSecret := func(_ context.Context, ref microvm.SecretReference) (map[string][]byte, error) {
+		data, ok := secrets[ref.Name]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+
+		return data, nil
+	}
+
+	host := &microvm.Host{Endpoint: "127.0.0.1:9090"}
+
+	opts, err := HostClientOptions(context.TODO(), host, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(opts).To(BeEmpty())
+
+	host.AuthSecretRef = &microvm.SecretReference{Name: "auth"}
+
+	opts, err = HostClientOptions(context.TODO(), host, getSecret)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(opts).To(HaveLen(1))
+
+	client, err := NewHostClient(context.TODO(), host, getSecret, flclient.NewFlintlockClient)
+	g.Expect(err).NotTo(HaveOccurred())
+	client.Close()
+
+	host.TLSSecretRef = &microvm.SecretReference{Name: "tls"}
+
+	opts, err = HostClientOptions(context.TODO(), host, getSecret)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(opts).To(HaveLen(2))
+
+	// The TLS config is loaded by the client, so the invalid cert shows it was used.
+	_, err = NewHostClient(context.TODO(), host, getSecret, flclient.NewFlintlockClient)
+	g.Expect(err).To(MatchError(ContainSubstring("certificate")))
+
+	host.AuthSecretRef = &microvm.SecretReference{Name: "tls"}
+
+	_, err = HostClientOptions(context.TODO(), host, getSecret)
+	g.Expect(err).To(MatchError(errSecretKeyMissing))
+	g.Expect(err).To(MatchError(ContainSubstring("getting auth secret for host 127.0.0.1:9090")))
+
+	host.AuthSecretRef = &microvm.SecretReference{Name: "missing"}
+
+	_, err = HostClientOptions(context.TODO(), host, getSecret)
+	g.Expect(err).To(MatchError(ContainSubstring("not found")))
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	ignition "github.com/coreos/ignition/v2/config/v3_4"
+	igntypes "github.com/coreos/ignition/v2/config/v3_4/types"
+	"k8s.io/utils/pointer"
+)
+
+// BootstrapFormat is the format of the bootstrap data supplied to a microvm.
+type BootstrapFormat string
+
+const (
+	// BootstrapFormatCloudConfig is cloud-init user data. This is the default.
+	BootstrapFormatCloudConfig = BootstrapFormat("cloud-config")
+	// BootstrapFormatIgnition is an Ignition v3 config, as used by Flatcar and
+	// Fedora CoreOS.
+	BootstrapFormatIgnition = BootstrapFormat("ignition")
+)
+
+const (
+	ignitionHostnamePath     = "/etc/hostname"
+	ignitionInstanceDataPath = "/etc/liquidmetal/meta-data"
+	ignitionFileMode         = 0o644
+)
+
+// BootstrapFormatScope can be implemented by a Scope to declare the format of the
+// data returned by GetRawBootstrapData. It takes precedence over WithBootstrapFormat.
+type BootstrapFormatScope interface {
+	// GetBootstrapFormat returns the format of the bootstrap data.
+	GetBootstrapFormat() BootstrapFormat
+}
+
+func (s *Service) getBootstrapFormat() BootstrapFormat {
+	if fs, ok := s.scope.(BootstrapFormatScope); ok && fs.GetBootstrapFormat() != "" {
+		return fs.GetBootstrapFormat()
+	}
+
+	if s.bootstrapFormat != "" {
+		return s.bootstrapFormat
+	}
+
+	return BootstrapFormatCloudConfig
+}
+
+// createIgnitionData translates the SSH keys, hostname and instance metadata into an
+// Ignition config, merges the bootstrap data and any additional user data on top
+// and validates the result.
+func (s *Service) createIgnitionData(instanceData []byte) (string, error) {
+	cfg := s.baseIgnitionConfig(instanceData)
+
+	bootData, err := s.scope.GetRawBootstrapData()
+	if err != nil {
+		return "", fmt.Errorf("getting bootstrap data: %w", err)
+	}
+
+	parts := append([]UserDataPart{{Content: []byte(bootData), Filename: "bootstrap"}}, s.userData...)
+
+	for _, part := range parts {
+		child, err := parseIgnition(part.Content)
+		if err != nil {
+			return "", fmt.Errorf("parsing ignition config %s: %w", part.Filename, err)
+		}
+
+		if child != nil {
+			cfg = ignition.Merge(cfg, *child)
+		}
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("marshalling ignition config: %w", err)
+	}
+
+	if _, rpt, err := ignition.Parse(data); err != nil {
+		return "", fmt.Errorf("validating ignition config: %w: %s", err, rpt.String())
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (s *Service) baseIgnitionConfig(instanceData []byte) igntypes.Config {
+	cfg := igntypes.Config{
+		Ignition: igntypes.Ignition{
+			Version: igntypes.MaxVersion.String(),
+		},
+		Storage: igntypes.Storage{
+			Files: []igntypes.File{
+				ignitionFile(ignitionHostnamePath, []byte(s.MicroVMName().Hostname+"\n")),
+				ignitionFile(ignitionInstanceDataPath, instanceData),
+			},
+		},
+	}
+
+	for _, key := range s.scope.GetSSHPublicKeys() {
+		user := igntypes.PasswdUser{
+			Name: key.User,
+		}
+
+		for _, authorizedKey := range key.AuthorizedKeys {
+			user.SSHAuthorizedKeys = append(user.SSHAuthorizedKeys, igntypes.SSHAuthorizedKey(authorizedKey))
+		}
+
+		cfg.Passwd.Users = append(cfg.Passwd.Users, user)
+	}
+
+	return cfg
+}
+
+// parseIgnition parses an Ignition config of any supported v3 version. Empty data
+// returns a nil config.
+func parseIgnition(data []byte) (*igntypes.Config, error) {
+	if DetectUserDataFormat(data) == UserDataFormatBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil {
+			return nil, fmt.Errorf("decoding base64 ignition config: %w", err)
+		}
+
+		data = decoded
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil //nolint: nilnil // there is nothing to merge
+	}
+
+	cfg, rpt, err := ignition.ParseCompatibleVersion(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, rpt.String())
+	}
+
+	return &cfg, nil
+}
+
+func ignitionFile(path string, contents []byte) igntypes.File {
+	return igntypes.File{
+		Node: igntypes.Node{
+			Path:      path,
+			Overwrite: pointer.Bool(true),
+		},
+		FileEmbedded1: igntypes.FileEmbedded1{
+			Contents: igntypes.Resource{
+				Source: pointer.String("data:;base64," + base64.StdEncoding.EncodeToString(contents)),
+			},
+			Mode: pointer.Int(ignitionFileMode),
+		},
+	}
+}
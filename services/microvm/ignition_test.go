@@ -0,0 +1,128 @@
+package microvm
+
+import (
+	"encoding/base64"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	ignition "github.com/coreos/ignition/v2/config/v3_4"
+	igntypes "github.com/coreos/ignition/v2/config/v3_4/types"
+
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/fakes"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+type ignitionScope struct {
+	*fakes.FakeScope
+}
+
+func (ignitionScope) GetBootstrapFormat() BootstrapFormat {
+	return BootstrapFormatIgnition
+}
+
+func Test_IgnitionMetadata(t *testing.T) {
+	g := NewWithT(t)
+
+	var (
+		machineName = "foo"
+		user        = "core"
+		key1        = "ssh-ed25519 AAAA1"
+		key2        = "ssh-ed25519 AAAA2"
+		bootstrap   = `{"ignition":{"version":"3.3.0"},"passwd":{"users":[{"name":"core","sshAuthorizedKeys":["` + key2 + `"]}]},"systemd":{"units":[{"name":"kubeadm.service","enabled":true}]}}`
+	)
+
+	tt := []struct {
+		name     string
+		scope    func(*fakes.FakeScope) Scope
+		opts     []Option
+		data     string
+		expected func(*WithT, map[string]string, error)
+	}{
+		{
+			name: "cloud-config by default",
+			scope: func(s *fakes.FakeScope) Scope {
+				return s
+			},
+			expected: func(g *WithT, metadata map[string]string, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(metadata).To(HaveKey("vendor-data"))
+			},
+		},
+		{
+			name: "ignition via scope",
+			scope: func(s *fakes.FakeScope) Scope {
+				return ignitionScope{s}
+			},
+			data: base64.StdEncoding.EncodeToString([]byte(bootstrap)),
+			expected: func(g *WithT, metadata map[string]string, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(metadata).NotTo(HaveKey("vendor-data"))
+				g.Expect(metadata).To(HaveKey("meta-data"))
+
+				cfg := decodeIgnition(g, metadata["user-data"])
+				g.Expect(cfg.Passwd.Users).To(HaveLen(1))
+				g.Expect(cfg.Passwd.Users[0].SSHAuthorizedKeys).To(ConsistOf(
+					igntypes.SSHAuthorizedKey(key1),
+					igntypes.SSHAuthorizedKey(key2),
+				))
+				g.Expect(cfg.Systemd.Units).To(HaveLen(1))
+				g.Expect(cfg.Storage.Files).To(HaveLen(2))
+				g.Expect(cfg.Storage.Files[0].Path).To(Equal(ignitionHostnamePath))
+				g.Expect(*cfg.Storage.Files[0].Contents.Source).To(Equal(
+					"data:;base64," + base64.StdEncoding.EncodeToString([]byte(machineName+"\n")),
+				))
+			},
+		},
+		{
+			name: "ignition via option without bootstrap data",
+			scope: func(s *fakes.FakeScope) Scope {
+				return s
+			},
+			opts: []Option{WithBootstrapFormat(BootstrapFormatIgnition)},
+			expected: func(g *WithT, metadata map[string]string, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+
+				cfg := decodeIgnition(g, metadata["user-data"])
+				g.Expect(cfg.Passwd.Users[0].SSHAuthorizedKeys).To(ConsistOf(igntypes.SSHAuthorizedKey(key1)))
+			},
+		},
+		{
+			name: "invalid bootstrap ignition",
+			scope: func(s *fakes.FakeScope) Scope {
+				return ignitionScope{s}
+			},
+			data: `{"ignition":{"version":"9.9.9"}}`,
+			expected: func(g *WithT, _ map[string]string, err error) {
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mScope := new(fakes.FakeScope)
+			mScope.NameReturns(machineName)
+			mScope.GetRawBootstrapDataReturns(tc.data)
+			mScope.GetSSHPublicKeysReturns([]microvm.SSHPublicKey{{User: user, AuthorizedKeys: []string{key1}}})
+
+			svc := New(tc.scope(mScope), nil, "host", tc.opts...)
+			spec := &flintlocktypes.MicroVMSpec{Metadata: map[string]string{}}
+
+			err := svc.addMetadata(spec, &microvm.VMSpec{})
+
+			tc.expected(g, spec.Metadata, err)
+		})
+	}
+}
+
+func decodeIgnition(g *WithT, data string) igntypes.Config {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	cfg, _, err := ignition.Parse(decoded)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	return cfg
+}
@@ -0,0 +1,237 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"fmt"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	"github.com/liquidmetal-dev/flintlock/client/cloudinit/instance"
+)
+
+// These constants represent the instance metadata key names set by the service, in
+// addition to the ones defined by the flintlock instance package. They follow the
+// cloud-init standardized instance data names where one exists.
+const (
+	// AvailabilityZoneKey is the instance metadata key name representing the failure domain of the instance.
+	AvailabilityZoneKey = "availability_zone"
+	// RegionKey is the instance metadata key name representing the region of the instance.
+	RegionKey = "region"
+	// InstanceTypeKey is the instance metadata key name representing the size of the instance.
+	InstanceTypeKey = "instance_type"
+	// TagsKey is the instance metadata key name containing the labels of the instance.
+	TagsKey = "tags"
+	// VMHostKey is the instance metadata key name representing the flintlock host of the instance.
+	VMHostKey = "vm_host"
+)
+
+// InstanceData is the cloud-init instance metadata supplied to a microvm.
+// See https://cloudinit.readthedocs.io/en/latest/reference/instancedata.html
+type InstanceData map[string]interface{}
+
+// MetadataInput is what the metadata builders build the metadata of a microvm from.
+type MetadataInput struct {
+	// Scope is the scope of the microvm.
+	Scope Scope
+	// HostID is the flintlock host the microvm is being created on.
+	HostID string
+	// Spec is the spec of the microvm, with its instance type and provider resolved.
+	Spec *microvm.VMSpec
+	// Labels are the labels sent to flintlock, including the reserved ownership labels.
+	Labels map[string]string
+	// Hostname is the hostname of the guest from the NamingStrategy of the service.
+	Hostname string
+}
+
+// InstanceMetadataScope can be implemented by a Scope to provide additional instance
+// metadata to the default InstanceDataBuilder.
+type InstanceMetadataScope interface {
+	// GetFailureDomain returns the failure domain (availability zone) of the microvm.
+	GetFailureDomain() string
+	// GetRegion returns the region of the microvm.
+	GetRegion() string
+	// GetInstanceMetadata returns custom keys to add to the instance metadata.
+	GetInstanceMetadata() map[string]string
+}
+
+// InstanceDataBuilder builds the cloud-init instance metadata for a microvm.
+type InstanceDataBuilder interface {
+	// Build returns the instance metadata for the microvm described by the input.
+	Build(in *MetadataInput) (InstanceData, error)
+}
+
+// InstanceDataOption is a func to add keys to the instance metadata of a microvm.
+type InstanceDataOption func(in *MetadataInput, data InstanceData) error
+
+type instanceDataBuilder struct {
+	base InstanceDataBuilder
+	opts []InstanceDataOption
+}
+
+// NewInstanceDataBuilder returns an InstanceDataBuilder which applies the given
+// options, in order, to empty instance metadata.
+func NewInstanceDataBuilder(opts ...InstanceDataOption) InstanceDataBuilder {
+	return &instanceDataBuilder{opts: opts}
+}
+
+// ExtendInstanceDataBuilder returns an InstanceDataBuilder which applies the given
+// options on top of the instance metadata produced by base.
+func ExtendInstanceDataBuilder(base InstanceDataBuilder, opts ...InstanceDataOption) InstanceDataBuilder {
+	return &instanceDataBuilder{base: base, opts: opts}
+}
+
+// DefaultInstanceDataBuilder returns the builder used by the service when no other
+// builder is configured.
+func DefaultInstanceDataBuilder() InstanceDataBuilder {
+	return NewInstanceDataBuilder(DefaultInstanceDataOptions()...)
+}
+
+// DefaultInstanceDataOptions returns the options used by DefaultInstanceDataBuilder.
+// Custom keys from the scope are applied first so they can't replace the standard keys.
+func DefaultInstanceDataOptions() []InstanceDataOption {
+	return []InstanceDataOption{
+		WithScopeMetadata(),
+		WithLocalHostname(),
+		WithPlatform(platformLiquidMetal),
+		WithVMHost(),
+		WithInstanceID(),
+		WithFailureDomain(),
+		WithInstanceType(),
+		WithTags(),
+	}
+}
+
+// Build fullfills the InstanceDataBuilder interface.
+func (b *instanceDataBuilder) Build(in *MetadataInput) (InstanceData, error) {
+	data := InstanceData{}
+
+	if b.base != nil {
+		var err error
+
+		data, err = b.base.Build(in)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, opt := range b.opts {
+		if err := opt(in, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// WithInstanceDataKey sets the key to the value.
+func WithInstanceDataKey(key string, value interface{}) InstanceDataOption {
+	return func(_ *MetadataInput, data InstanceData) error {
+		data[key] = value
+
+		return nil
+	}
+}
+
+// WithLocalHostname sets the local hostname to the hostname of the guest.
+func WithLocalHostname() InstanceDataOption {
+	return func(in *MetadataInput, data InstanceData) error {
+		data[instance.LocalHostnameKey] = in.Hostname
+
+		return nil
+	}
+}
+
+// WithPlatform sets the platform name.
+func WithPlatform(name string) InstanceDataOption {
+	return WithInstanceDataKey(instance.PlatformKey, name)
+}
+
+// WithVMHost sets the flintlock host the microvm is created on.
+func WithVMHost() InstanceDataOption {
+	return func(in *MetadataInput, data InstanceData) error {
+		data[VMHostKey] = in.HostID
+
+		return nil
+	}
+}
+
+// WithInstanceID sets the instance id to the microvm UID, if the scope knows it.
+func WithInstanceID() InstanceDataOption {
+	return func(in *MetadataInput, data InstanceData) error {
+		if id := in.Scope.GetInstanceID(); id != "" {
+			data[instance.InstanceIDKey] = id
+		}
+
+		return nil
+	}
+}
+
+// WithFailureDomain sets the availability zone and region from scopes which
+// implement InstanceMetadataScope.
+func WithFailureDomain() InstanceDataOption {
+	return func(in *MetadataInput, data InstanceData) error {
+		ms, ok := in.Scope.(InstanceMetadataScope)
+		if !ok {
+			return nil
+		}
+
+		if fd := ms.GetFailureDomain(); fd != "" {
+			data[AvailabilityZoneKey] = fd
+		}
+
+		if region := ms.GetRegion(); region != "" {
+			data[RegionKey] = region
+		}
+
+		return nil
+	}
+}
+
+// WithInstanceType sets the instance type of the microvm. Microvms without an
+// instance type get one derived from their VCPU and memory.
+func WithInstanceType() InstanceDataOption {
+	return func(in *MetadataInput, data InstanceData) error {
+		data[InstanceTypeKey] = instanceTypeName(in.Spec)
+
+		return nil
+	}
+}
+
+// WithTags exposes the labels of the microvm as tags.
+func WithTags() InstanceDataOption {
+	return func(in *MetadataInput, data InstanceData) error {
+		tags := make(map[string]string, len(in.Labels))
+		for k, v := range in.Labels {
+			tags[k] = v
+		}
+
+		data[TagsKey] = tags
+
+		return nil
+	}
+}
+
+func instanceTypeName(spec *microvm.VMSpec) string {
+	if spec.InstanceType != "" {
+		return spec.InstanceType
+	}
+
+	return fmt.Sprintf("%dvcpu-%dmib", spec.VCPU, spec.MemoryMiB())
+}
+
+// WithScopeMetadata adds the custom keys from scopes which implement InstanceMetadataScope.
+func WithScopeMetadata() InstanceDataOption {
+	return func(in *MetadataInput, data InstanceData) error {
+		ms, ok := in.Scope.(InstanceMetadataScope)
+		if !ok {
+			return nil
+		}
+
+		for k, v := range ms.GetInstanceMetadata() {
+			data[k] = v
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,112 @@
+package microvm
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/fakes"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	"github.com/liquidmetal-dev/flintlock/client/cloudinit/instance"
+)
+
+type instanceMetadataScope struct {
+	*fakes.FakeScope
+}
+
+func (instanceMetadataScope) GetFailureDomain() string {
+	return "zone-a"
+}
+
+func (instanceMetadataScope) GetRegion() string {
+	return "eu-west"
+}
+
+func (instanceMetadataScope) GetInstanceMetadata() map[string]string {
+	return map[string]string{
+		"custom":                  "value",
+		instance.LocalHostnameKey: "ignored",
+	}
+}
+
+func Test_InstanceDataBuilder(t *testing.T) {
+	g := NewWithT(t)
+
+	var (
+		machineName = "foo"
+		hostID      = "host1"
+	)
+
+	tt := []struct {
+		name     string
+		scope    func(*fakes.FakeScope) Scope
+		builder  InstanceDataBuilder
+		expected func(*WithT, InstanceData)
+	}{
+		{
+			name: "default builder",
+			scope: func(s *fakes.FakeScope) Scope {
+				return s
+			},
+			builder: DefaultInstanceDataBuilder(),
+			expected: func(g *WithT, data InstanceData) {
+				g.Expect(data).To(Equal(InstanceData{
+					instance.LocalHostnameKey: machineName,
+					instance.PlatformKey:      platformLiquidMetal,
+					VMHostKey:                 hostID,
+					InstanceTypeKey:           "2vcpu-2048mib",
+					TagsKey:                   map[string]string{"team": "a"},
+				}))
+			},
+		},
+		{
+			name: "default builder with instance metadata scope",
+			scope: func(s *fakes.FakeScope) Scope {
+				return instanceMetadataScope{s}
+			},
+			builder: DefaultInstanceDataBuilder(),
+			expected: func(g *WithT, data InstanceData) {
+				g.Expect(data).To(HaveKeyWithValue(instance.LocalHostnameKey, machineName))
+				g.Expect(data).To(HaveKeyWithValue(AvailabilityZoneKey, "zone-a"))
+				g.Expect(data).To(HaveKeyWithValue(RegionKey, "eu-west"))
+				g.Expect(data).To(HaveKeyWithValue("custom", "value"))
+			},
+		},
+		{
+			name: "extended builder",
+			scope: func(s *fakes.FakeScope) Scope {
+				return s
+			},
+			builder: ExtendInstanceDataBuilder(NewInstanceDataBuilder(WithLocalHostname()),
+				WithInstanceDataKey("cluster_name", "bar"),
+			),
+			expected: func(g *WithT, data InstanceData) {
+				g.Expect(data).To(Equal(InstanceData{
+					instance.LocalHostnameKey: machineName,
+					"cluster_name":            "bar",
+				}))
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			in := &MetadataInput{
+				Scope:    tc.scope(new(fakes.FakeScope)),
+				HostID:   hostID,
+				Spec:     &microvm.VMSpec{VCPU: 2, MemoryMb: 2048},
+				Labels:   map[string]string{"team": "a"},
+				Hostname: machineName,
+			}
+
+			data, err := tc.builder.Build(in)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			tc.expected(g, data)
+
+			_, err = yaml.Marshal(data)
+			g.Expect(err).NotTo(HaveOccurred())
+		})
+	}
+}
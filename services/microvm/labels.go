@@ -0,0 +1,161 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+// DefaultManagedBy is the value of the managed-by label when WithManagedBy isn't used.
+const DefaultManagedBy = "controller-pkg"
+
+// DefaultOwnerKind is the owner kind recorded for owners without one, such as for
+// scopes which don't implement OwnershipScope, when WithOwnerKind isn't used.
+const DefaultOwnerKind = "MicroVM"
+
+// specHashLength is the number of hex characters of the spec hash label.
+const specHashLength = 16
+
+// ErrReservedLabel is returned when the scope labels a microvm with the reserved
+// microvm.LabelPrefix.
+var ErrReservedLabel = errors.New("label uses the reserved prefix " + microvm.LabelPrefix)
+
+// OwnershipScope is implemented by scopes which record the object owning the microvm,
+// and its cluster, in the ownership labels. Without it the owner is the name and
+// namespace of the scope, with the kind set by WithOwnerKind.
+type OwnershipScope interface {
+	// GetOwner returns the object owning the microvm.
+	GetOwner() microvm.Owner
+	// GetClusterName returns the name of the cluster the microvm belongs to.
+	GetClusterName() string
+}
+
+// WithManagedBy sets the value of the managed-by label, such as the name of the
+// controller creating the microvms.
+func WithManagedBy(name string) Option {
+	return func(s *Service) {
+		s.managedBy = name
+	}
+}
+
+// WithOwnerKind sets the kind of the owner recorded in the ownership labels when the
+// scope doesn't supply one, so microvm.OwnerFromLabels, and so the garbage collector
+// and drain, can find the owner.
+func WithOwnerKind(kind string) Option {
+	return func(s *Service) {
+		s.ownerKind = kind
+	}
+}
+
+// MergeLabels returns the labels of the spec overridden by the labels of the scope.
+// The service then adds the ownership labels, which take precedence over both and
+// over any set by spec mutators.
+func MergeLabels(specLabels, scopeLabels map[string]string) map[string]string {
+	if len(specLabels) == 0 && len(scopeLabels) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]string, len(specLabels)+len(scopeLabels))
+
+	for k, v := range specLabels {
+		merged[k] = v
+	}
+
+	for k, v := range scopeLabels {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// SpecHash returns a hash of the spec, which changes when the spec does.
+func SpecHash(spec *microvm.VMSpec) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("marshalling spec: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])[:specHashLength], nil
+}
+
+// checkReservedLabels returns an error if any of the labels use the reserved prefix.
+func checkReservedLabels(labels map[string]string) error {
+	reserved := []string{}
+
+	for key := range labels {
+		if microvm.IsReservedLabel(key) {
+			reserved = append(reserved, key)
+		}
+	}
+
+	if len(reserved) == 0 {
+		return nil
+	}
+
+	sort.Strings(reserved)
+
+	return fmt.Errorf("%w: %v", ErrReservedLabel, reserved)
+}
+
+// ownershipLabels returns the reserved labels tracing the microvm back to what
+// created it.
+func (s *Service) ownershipLabels(spec *microvm.VMSpec) (map[string]string, error) {
+	owner := microvm.Owner{Name: s.scope.Name(), Namespace: s.scope.Namespace()}
+	clusterName := ""
+
+	if ownership, ok := s.scope.(OwnershipScope); ok {
+		owner = ownership.GetOwner()
+		clusterName = ownership.GetClusterName()
+	}
+
+	if owner.Kind == "" {
+		owner.Kind = s.ownerKind
+	}
+
+	labels := owner.Labels()
+	labels[microvm.ManagedByLabel] = s.managedBy
+
+	if clusterName != "" {
+		labels[microvm.ClusterNameLabel] = clusterName
+	}
+
+	hash, err := SpecHash(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	labels[microvm.SpecHashLabel] = hash
+
+	return labels, nil
+}
+
+// addOwnershipLabels adds the ownership labels to the labels of the microvm,
+// replacing any with the same key.
+func (s *Service) addOwnershipLabels(spec *microvm.VMSpec, labels map[string]string) (map[string]string, error) {
+	ownership, err := s.ownershipLabels(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]string, len(labels)+len(ownership))
+
+	for k, v := range labels {
+		merged[k] = v
+	}
+
+	for k, v := range ownership {
+		merged[k] = v
+	}
+
+	return merged, nil
+}
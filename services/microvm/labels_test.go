@@ -0,0 +1,119 @@
+package microvm
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/fakes"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+type ownershipScope struct {
+	*fakes.FakeScope
+}
+
+func (ownershipScope) GetOwner() microvm.Owner {
+	return microvm.Owner{Kind: "MicrovmMachine", Namespace: "bar", Name: "foo-machine", UID: "1234"}
+}
+
+func (ownershipScope) GetClusterName() string {
+	return "mgmt"
+}
+
+func Test_MergeLabels(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(MergeLabels(nil, nil)).To(BeNil())
+	g.Expect(MergeLabels(
+		map[string]string{"app": "spec", "tier": "web"},
+		map[string]string{"app": "scope", "env": "prod"},
+	)).To(Equal(map[string]string{"app": "scope", "tier": "web", "env": "prod"}))
+}
+
+func Test_CreateLabels(t *testing.T) {
+	g := NewWithT(t)
+
+	mScope := validScope()
+	spec := mScope.GetMicrovmSpec()
+	spec.Labels = map[string]string{"app": "spec", "tier": "web"}
+	mScope.GetMicrovmSpecReturns(spec)
+	mScope.GetLabelsReturns(map[string]string{"app": "scope", "env": "prod"})
+
+	hash, err := SpecHash(&spec)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(hash).To(HaveLen(specHashLength))
+
+	rendered, err := New(mScope, nil, "host").Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rendered.Request.Microvm.Labels).To(Equal(map[string]string{
+		"app":                       "scope",
+		"tier":                      "web",
+		"env":                       "prod",
+		microvm.OwnerKindLabel:      DefaultOwnerKind,
+		microvm.OwnerNameLabel:      "foo",
+		microvm.OwnerNamespaceLabel: "bar",
+		microvm.ManagedByLabel:      DefaultManagedBy,
+		microvm.SpecHashLabel:       hash,
+	}))
+
+	// The owner of a plain scope can be found by the garbage collector and drain.
+	owner, ok := microvm.OwnerFromLabels(rendered.Request.Microvm.Labels)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(owner).To(Equal(microvm.Owner{Kind: DefaultOwnerKind, Namespace: "bar", Name: "foo"}))
+
+	rendered, err = New(mScope, nil, "host", WithOwnerKind("Microvm")).Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rendered.Request.Microvm.Labels).To(HaveKeyWithValue(microvm.OwnerKindLabel, "Microvm"))
+
+	// Ownership labels can't be replaced by spec mutators.
+	rendered, err = New(ownershipScope{mScope}, nil, "host",
+		WithManagedBy("capmvm"),
+		WithSpecMutators(ForceLabels(map[string]string{microvm.ManagedByLabel: "other", "env": "forced"})),
+	).Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rendered.Request.Microvm.Labels).To(Equal(map[string]string{
+		"app":                       "scope",
+		"tier":                      "web",
+		"env":                       "forced",
+		microvm.OwnerKindLabel:      "MicrovmMachine",
+		microvm.OwnerNameLabel:      "foo-machine",
+		microvm.OwnerNamespaceLabel: "bar",
+		microvm.OwnerUIDLabel:       "1234",
+		microvm.ClusterNameLabel:    "mgmt",
+		microvm.ManagedByLabel:      "capmvm",
+		microvm.SpecHashLabel:       hash,
+	}))
+
+	owner, ok = microvm.OwnerFromLabels(rendered.Request.Microvm.Labels)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(owner).To(Equal(ownershipScope{}.GetOwner()))
+
+	spec.VCPU = 4
+	mScope.GetMicrovmSpecReturns(spec)
+
+	rendered, err = New(mScope, nil, "host").Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rendered.Request.Microvm.Labels[microvm.SpecHashLabel]).NotTo(Equal(hash))
+}
+
+func Test_CreateRejectsReservedLabels(t *testing.T) {
+	g := NewWithT(t)
+
+	mScope := validScope()
+	mScope.GetLabelsReturns(map[string]string{microvm.OwnerNameLabel: "other", microvm.ManagedByLabel: "me"})
+
+	// The client is nil, so reaching the RPC would panic.
+	_, err := New(mScope, nil, "host").Create(context.TODO())
+	g.Expect(err).To(MatchError(ErrReservedLabel))
+	g.Expect(err).To(MatchError(ContainSubstring("[liquidmetal.dev/managed-by liquidmetal.dev/owner-name]")))
+
+	mScope = validScope()
+	spec := mScope.GetMicrovmSpec()
+	spec.Labels = map[string]string{microvm.SpecHashLabel: "1234"}
+	mScope.GetMicrovmSpecReturns(spec)
+
+	_, err = New(mScope, nil, "host").Create(context.TODO())
+	g.Expect(err).To(MatchError(ContainSubstring("spec.labels[liquidmetal.dev/spec-hash]: Forbidden")))
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrMetadataTooLarge is the error matched by MetadataSizeError.
+var ErrMetadataTooLarge = errors.New("metadata too large")
+
+// compressibleMetadataKeys are the metadata keys which cloud-init will decompress.
+var compressibleMetadataKeys = []string{"user-data", "vendor-data"}
+
+// MetadataLimits are the size limits, in bytes, of the encoded metadata sent to
+// flintlock. A limit of zero is not enforced.
+type MetadataLimits struct {
+	// PerKey is the maximum size of a single metadata value.
+	PerKey int
+	// Total is the maximum size of all the metadata keys and values combined.
+	Total int
+}
+
+// MetadataSizeError is returned when the metadata exceeds a configured limit.
+type MetadataSizeError struct {
+	// Key is the metadata key which exceeded the limit. When the total limit was
+	// exceeded this is the largest key.
+	Key string
+	// Size is the size of the key, or the total size if the total limit was exceeded.
+	Size int
+	// Limit is the limit which was exceeded.
+	Limit int
+	// Total is true if the total limit was exceeded.
+	Total bool
+}
+
+// Error fullfills the error interface.
+func (e *MetadataSizeError) Error() string {
+	if e.Total {
+		return fmt.Sprintf("metadata is %d bytes, exceeding the limit of %d bytes (largest key %s)", e.Size, e.Limit, e.Key)
+	}
+
+	return fmt.Sprintf("metadata key %s is %d bytes, exceeding the limit of %d bytes", e.Key, e.Size, e.Limit)
+}
+
+// Is allows the error to be matched with ErrMetadataTooLarge.
+func (e *MetadataSizeError) Is(target error) bool {
+	return target == ErrMetadataTooLarge
+}
+
+// MetadataSize returns the combined size of the metadata keys and values.
+func MetadataSize(metadata map[string]string) int {
+	size := 0
+
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+
+	return size
+}
+
+// WithMetadataLimits sets the size limits of the metadata sent to flintlock.
+func WithMetadataLimits(l MetadataLimits) Option {
+	return func(s *Service) {
+		s.metadataLimits = l
+	}
+}
+
+// WithMetadataCompression gzips the cloud-init user data and vendor data before
+// sending them to flintlock. Values are left as they are if compression doesn't
+// make them smaller.
+func WithMetadataCompression() Option {
+	return func(s *Service) {
+		s.compressMetadata = true
+	}
+}
+
+func checkMetadataSize(metadata map[string]string, limits MetadataLimits) error {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	largest := ""
+
+	for _, k := range keys {
+		v := metadata[k]
+
+		if limits.PerKey > 0 && len(v) > limits.PerKey {
+			return &MetadataSizeError{Key: k, Size: len(v), Limit: limits.PerKey}
+		}
+
+		if largest == "" || len(v) > len(metadata[largest]) {
+			largest = k
+		}
+	}
+
+	if total := MetadataSize(metadata); limits.Total > 0 && total > limits.Total {
+		return &MetadataSizeError{Key: largest, Size: total, Limit: limits.Total, Total: true}
+	}
+
+	return nil
+}
+
+func compressMetadata(metadata map[string]string) error {
+	for _, key := range compressibleMetadataKeys {
+		value, ok := metadata[key]
+		if !ok || value == "" {
+			continue
+		}
+
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || DetectUserDataFormat(data) == UserDataFormatGzip {
+			continue
+		}
+
+		buf := &bytes.Buffer{}
+		w := gzip.NewWriter(buf)
+
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("compressing %s: %w", key, err)
+		}
+
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("compressing %s: %w", key, err)
+		}
+
+		compressed := base64.StdEncoding.EncodeToString(buf.Bytes())
+		if len(compressed) < len(value) {
+			metadata[key] = compressed
+		}
+	}
+
+	return nil
+}
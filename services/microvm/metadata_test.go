@@ -0,0 +1,87 @@
+package microvm
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/fakes"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+func Test_MetadataSize(t *testing.T) {
+	g := NewWithT(t)
+
+	bootstrap := base64.StdEncoding.EncodeToString([]byte("#cloud-config\nruncmd:\n" + strings.Repeat("- echo hello\n", 500)))
+
+	tt := []struct {
+		name     string
+		opts     []Option
+		expected func(*WithT, map[string]string, error)
+	}{
+		{
+			name: "no limits",
+			expected: func(g *WithT, metadata map[string]string, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(metadata["user-data"]).To(Equal(bootstrap))
+			},
+		},
+		{
+			name: "per key limit exceeded",
+			opts: []Option{WithMetadataLimits(MetadataLimits{PerKey: 1024})},
+			expected: func(g *WithT, _ map[string]string, err error) {
+				g.Expect(err).To(MatchError(ErrMetadataTooLarge))
+
+				sizeErr := &MetadataSizeError{}
+				g.Expect(errors.As(err, &sizeErr)).To(BeTrue())
+				g.Expect(sizeErr.Key).To(Equal("user-data"))
+				g.Expect(sizeErr.Size).To(Equal(len(bootstrap)))
+				g.Expect(sizeErr.Total).To(BeFalse())
+			},
+		},
+		{
+			name: "total limit exceeded",
+			opts: []Option{WithMetadataLimits(MetadataLimits{PerKey: len(bootstrap), Total: len(bootstrap)})},
+			expected: func(g *WithT, _ map[string]string, err error) {
+				sizeErr := &MetadataSizeError{}
+				g.Expect(errors.As(err, &sizeErr)).To(BeTrue())
+				g.Expect(sizeErr.Key).To(Equal("user-data"))
+				g.Expect(sizeErr.Total).To(BeTrue())
+			},
+		},
+		{
+			name: "compression brings metadata under the limit",
+			opts: []Option{WithMetadataCompression(), WithMetadataLimits(MetadataLimits{PerKey: 1024})},
+			expected: func(g *WithT, metadata map[string]string, err error) {
+				g.Expect(err).NotTo(HaveOccurred())
+
+				data, err := base64.StdEncoding.DecodeString(metadata["user-data"])
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(DetectUserDataFormat(data)).To(Equal(UserDataFormatGzip))
+
+				data, err = base64.StdEncoding.DecodeString(metadata["meta-data"])
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(DetectUserDataFormat(data)).NotTo(Equal(UserDataFormatGzip))
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mScope := new(fakes.FakeScope)
+			mScope.NameReturns("foo")
+			mScope.GetRawBootstrapDataReturns(bootstrap)
+
+			svc := New(mScope, nil, "host", tc.opts...)
+			spec := &flintlocktypes.MicroVMSpec{Metadata: map[string]string{}}
+
+			err := svc.addMetadata(spec, &microvm.VMSpec{})
+
+			tc.expected(g, spec.Metadata, err)
+		})
+	}
+}
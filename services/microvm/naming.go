@@ -0,0 +1,140 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// MaxHostnameLength is the maximum length of a guest hostname, which is a
+	// single RFC 1123 label.
+	MaxHostnameLength = 63
+
+	// defaultHostname is used when nothing of the name is valid in a hostname.
+	defaultHostname = "microvm"
+
+	// nameHashLength is the number of hex characters of the hash added by HashedNaming.
+	nameHashLength = 8
+)
+
+// MicroVMName is the name of a microvm on a flintlock host and in its guest.
+type MicroVMName struct {
+	// ID is the flintlock id of the microvm.
+	ID string
+	// Namespace is the flintlock namespace of the microvm.
+	Namespace string
+	// Hostname is the hostname of the guest, a valid RFC 1123 label.
+	Hostname string
+}
+
+// NamingStrategy maps the name and namespace of a scope to the name of its microvm.
+// It must be deterministic, as the mapping is used to find microvms again, and
+// distinct scopes must map to distinct names.
+type NamingStrategy func(name, namespace string) MicroVMName
+
+// VerbatimNaming uses the name and namespace of the scope as the flintlock id and
+// namespace. This is the default.
+func VerbatimNaming() NamingStrategy {
+	return func(name, namespace string) MicroVMName {
+		return MicroVMName{
+			ID:        name,
+			Namespace: namespace,
+			Hostname:  SanitizeHostname(name),
+		}
+	}
+}
+
+// ClusterPrefixedNaming prefixes the flintlock namespace with the name of the
+// cluster, so microvms of clusters which share a host don't collide.
+func ClusterPrefixedNaming(clusterName string) NamingStrategy {
+	return func(name, namespace string) MicroVMName {
+		return MicroVMName{
+			ID:        name,
+			Namespace: clusterName + "-" + namespace,
+			Hostname:  SanitizeHostname(name),
+		}
+	}
+}
+
+// HashedNaming truncates ids from the base strategy which are longer than maxLength,
+// replacing the end with a hash of the namespace and name so they stay unique. If
+// base is nil VerbatimNaming is used. A maxLength too short to hold the hash, or
+// above MaxHostnameLength, uses MaxHostnameLength.
+func HashedNaming(maxLength int, base NamingStrategy) NamingStrategy {
+	if maxLength <= nameHashLength+1 || maxLength > MaxHostnameLength {
+		maxLength = MaxHostnameLength
+	}
+
+	if base == nil {
+		base = VerbatimNaming()
+	}
+
+	return func(name, namespace string) MicroVMName {
+		mapped := base(name, namespace)
+
+		if len(mapped.ID) > maxLength {
+			sum := sha256.Sum256([]byte(mapped.Namespace + "/" + mapped.ID))
+			prefix := strings.TrimRight(mapped.ID[:maxLength-nameHashLength-1], "-.")
+
+			mapped.ID = prefix + "-" + hex.EncodeToString(sum[:])[:nameHashLength]
+		}
+
+		mapped.Hostname = SanitizeHostname(mapped.ID)
+
+		return mapped
+	}
+}
+
+// SanitizeHostname returns the name as an RFC 1123 label: lower case alphanumerics
+// and '-', starting and ending with an alphanumeric, and at most MaxHostnameLength
+// characters. Other characters are replaced with '-'.
+func SanitizeHostname(name string) string {
+	var b strings.Builder
+
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+
+	hostname := strings.Trim(b.String(), "-")
+	if len(hostname) > MaxHostnameLength {
+		hostname = strings.TrimRight(hostname[:MaxHostnameLength], "-")
+	}
+
+	if hostname == "" {
+		return defaultHostname
+	}
+
+	return hostname
+}
+
+// WithNamingStrategy sets how the name and namespace of the scope are mapped to the
+// flintlock id and namespace of the microvm, and the hostname of its guest. The
+// default is VerbatimNaming.
+func WithNamingStrategy(strategy NamingStrategy) Option {
+	return func(s *Service) {
+		s.converter.naming = strategy
+	}
+}
+
+// MicroVMName returns the name of the microvm of the scope, as mapped by the naming
+// strategy. Use it to look up the microvm on the host, such as with ListMicroVMs.
+func (s *Service) MicroVMName() MicroVMName {
+	return s.converter.microvmName(s.scope)
+}
+
+func (c *Converter) microvmName(scope Scope) MicroVMName {
+	naming := c.naming
+	if naming == nil {
+		naming = VerbatimNaming()
+	}
+
+	return naming(scope.Name(), scope.Namespace())
+}
@@ -0,0 +1,73 @@
+package microvm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_SanitizeHostname(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		input    string
+		expected string
+	}{
+		{input: "foo", expected: "foo"},
+		{input: "Foo_Bar.baz", expected: "foo-bar-baz"},
+		{input: "-foo-", expected: "foo"},
+		{input: "___", expected: "microvm"},
+		{input: strings.Repeat("a", 62) + "-b", expected: strings.Repeat("a", 62)},
+	}
+
+	for _, tc := range tt {
+		g.Expect(SanitizeHostname(tc.input)).To(Equal(tc.expected), tc.input)
+	}
+}
+
+func Test_NamingStrategies(t *testing.T) {
+	g := NewWithT(t)
+
+	longName := "worker-" + strings.Repeat("x", 60)
+
+	g.Expect(VerbatimNaming()("Foo_1", "bar")).To(Equal(MicroVMName{ID: "Foo_1", Namespace: "bar", Hostname: "foo-1"}))
+	g.Expect(ClusterPrefixedNaming("mgmt")("foo", "bar")).To(Equal(MicroVMName{ID: "foo", Namespace: "mgmt-bar", Hostname: "foo"}))
+
+	hashed := HashedNaming(20, nil)
+	g.Expect(hashed("foo", "bar")).To(Equal(MicroVMName{ID: "foo", Namespace: "bar", Hostname: "foo"}))
+
+	name := hashed(longName, "bar")
+	g.Expect(name.ID).To(HaveLen(20))
+	g.Expect(name.ID).To(HavePrefix("worker-xxxx"))
+	g.Expect(name.Hostname).To(Equal(name.ID))
+	g.Expect(hashed(longName, "bar")).To(Equal(name), "deterministic")
+	g.Expect(hashed(longName, "other").ID).NotTo(Equal(name.ID))
+	g.Expect(hashed(longName+"y", "bar").ID).NotTo(Equal(name.ID))
+
+	prefixed := HashedNaming(0, ClusterPrefixedNaming("mgmt"))(longName, "bar")
+	g.Expect(prefixed.ID).To(HaveLen(MaxHostnameLength))
+	g.Expect(prefixed.Namespace).To(Equal("mgmt-bar"))
+}
+
+func Test_ServiceNamingStrategy(t *testing.T) {
+	g := NewWithT(t)
+
+	mScope := validScope()
+	mScope.NameReturns("Worker_" + strings.Repeat("x", 70))
+
+	svc := New(mScope, nil, "host", WithNamingStrategy(HashedNaming(30, ClusterPrefixedNaming("mgmt"))))
+
+	name := svc.MicroVMName()
+	g.Expect(name.ID).To(HaveLen(30))
+	g.Expect(name.Namespace).To(Equal("mgmt-bar"))
+
+	rendered, err := svc.Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(rendered.Request.Microvm.Id).To(Equal(name.ID))
+	g.Expect(rendered.Request.Microvm.Namespace).To(Equal(name.Namespace))
+	g.Expect(rendered.Metadata["meta-data"]).To(ContainSubstring("local_hostname: " + name.Hostname))
+	g.Expect(rendered.Metadata["vendor-data"]).To(ContainSubstring("hostname: " + name.Hostname))
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package placement
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+var (
+	errInsufficientVCPU    = errors.New("insufficient vcpu")
+	errInsufficientMemory  = errors.New("insufficient memory")
+	errUnsupportedProvider = errors.New("provider not supported")
+	errSelectorMismatch    = errors.New("host labels don't match the host selector")
+	errUntoleratedTaint    = errors.New("host has a taint the microvm doesn't tolerate")
+)
+
+type capacityFilter struct{}
+
+// CapacityFilter filters out hosts without enough free vcpu or memory for the
+// microvm. A host with no capacity or allocatable resources set has no limit.
+func CapacityFilter() Filter {
+	return capacityFilter{}
+}
+
+func (capacityFilter) Name() string {
+	return "Capacity"
+}
+
+func (capacityFilter) Filter(req *Request, host *HostInfo) error {
+	requested := ResourcesForSpec(&req.Spec)
+	used := host.Used()
+	capacity := host.Allocatable()
+
+	if capacity.VCPU > 0 && used.VCPU+requested.VCPU > capacity.VCPU {
+		return fmt.Errorf("%w: requested %d, %d of %d in use",
+			errInsufficientVCPU, requested.VCPU, used.VCPU, capacity.VCPU)
+	}
+
+	if capacity.MemoryMiB > 0 && used.MemoryMiB+requested.MemoryMiB > capacity.MemoryMiB {
+		return fmt.Errorf("%w: requested %dMi, %dMi of %dMi in use",
+			errInsufficientMemory, requested.MemoryMiB, used.MemoryMiB, capacity.MemoryMiB)
+	}
+
+	return nil
+}
+
+type providerFilter struct{}
+
+// ProviderFilter filters out hosts which don't support the provider of the microvm.
+func ProviderFilter() Filter {
+	return providerFilter{}
+}
+
+func (providerFilter) Name() string {
+	return "Provider"
+}
+
+func (providerFilter) Filter(req *Request, host *HostInfo) error {
+	if req.Spec.Provider == "" || len(host.Providers) == 0 {
+		return nil
+	}
+
+	for _, provider := range host.Providers {
+		if provider == req.Spec.Provider {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", errUnsupportedProvider, req.Spec.Provider)
+}
+
+type hostSelectorFilter struct{}
+
+// HostSelectorFilter filters out hosts whose labels don't match the host selector
+// of the request.
+func HostSelectorFilter() Filter {
+	return hostSelectorFilter{}
+}
+
+func (hostSelectorFilter) Name() string {
+	return "HostSelector"
+}
+
+func (hostSelectorFilter) Filter(req *Request, host *HostInfo) error {
+	if req.HostSelector == nil || req.HostSelector.Matches(labels.Set(host.Host.Labels)) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", errSelectorMismatch, req.HostSelector)
+}
+
+type taintFilter struct{}
+
+// TaintFilter filters out hosts with NoSchedule or NoExecute taints the microvm
+// doesn't tolerate.
+func TaintFilter() Filter {
+	return taintFilter{}
+}
+
+func (taintFilter) Name() string {
+	return "Taint"
+}
+
+func (taintFilter) Filter(req *Request, host *HostInfo) error {
+	for _, taint := range host.Host.Taints {
+		if !tolerated(req.Tolerations, taint) {
+			return fmt.Errorf("%w: %s", errUntoleratedTaint, taint)
+		}
+	}
+
+	return nil
+}
+
+func tolerated(tolerations []Toleration, taint microvm.Taint) bool {
+	for _, toleration := range tolerations {
+		if toleration.Tolerates(taint) {
+			return true
+		}
+	}
+
+	return false
+}
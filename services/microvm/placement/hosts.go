@@ -0,0 +1,50 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package placement
+
+import (
+	"context"
+	"fmt"
+
+	flclient "github.com/liquidmetal-dev/controller-pkg/client"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+)
+
+// ClientFunc returns a flintlock client for the host.
+type ClientFunc func(host microvm.Host) (flclient.Client, error)
+
+// Refresh lists the microvms on each of the hosts, in all namespaces, replacing
+// their MicroVMs. Hosts which can't be listed are returned as errors, keyed by name,
+// and have their microvms left unchanged, so callers can exclude them.
+func Refresh(ctx context.Context, hosts []HostInfo, clientFor ClientFunc) map[string]error {
+	errs := map[string]error{}
+
+	for i := range hosts {
+		host := &hosts[i]
+
+		if err := refreshHost(ctx, host, clientFor); err != nil {
+			errs[host.Name()] = err
+		}
+	}
+
+	return errs
+}
+
+func refreshHost(ctx context.Context, host *HostInfo, clientFor ClientFunc) error {
+	client, err := clientFor(host.Host)
+	if err != nil {
+		return fmt.Errorf("creating client for host %s: %w", host.Name(), err)
+	}
+	defer client.Close()
+
+	resp, err := client.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{})
+	if err != nil {
+		return fmt.Errorf("listing microvms on host %s: %w", host.Name(), err)
+	}
+
+	host.MicroVMs = resp.Microvm
+
+	return nil
+}
@@ -0,0 +1,301 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package placement chooses the flintlock host to create a microvm on. Hosts are
+// filtered on whether they can run the microvm, then scored by plugins.
+package placement
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// MaxScore is the highest score a Scorer can give a host.
+const MaxScore int64 = 100
+
+// ErrUnschedulable is returned when there is no host the microvm can be placed on.
+var ErrUnschedulable = errors.New("no host available")
+
+// Resources are an amount of vcpu and memory.
+type Resources struct {
+	// VCPU is the number of vcpus.
+	VCPU int64
+	// MemoryMiB is the memory in mebibytes.
+	MemoryMiB int64
+}
+
+// Add returns the sum of the resources.
+func (r Resources) Add(other Resources) Resources {
+	return Resources{VCPU: r.VCPU + other.VCPU, MemoryMiB: r.MemoryMiB + other.MemoryMiB}
+}
+
+// ResourcesForSpec returns the resources requested by the spec. The instance type of
+// the spec must already be resolved.
+func ResourcesForSpec(spec *microvm.VMSpec) Resources {
+	return Resources{VCPU: spec.VCPU, MemoryMiB: spec.MemoryMiB()}
+}
+
+// HostInfo is a flintlock host which microvms can be placed on.
+type HostInfo struct {
+	// Host is the flintlock host.
+	Host microvm.Host
+	// Capacity is the vcpu and memory of the host available to microvms. If not
+	// set the allocatable resources of the host are used.
+	Capacity Resources
+	// Providers are the microvm providers the host supports. If empty the host
+	// supports any provider.
+	Providers []string
+	// MicroVMs are the microvms on the host, as returned by ListMicroVMs.
+	MicroVMs []*flintlocktypes.MicroVM
+}
+
+// Name returns the name of the host, or its endpoint if it has no name.
+func (h *HostInfo) Name() string {
+	if h.Host.Name != "" {
+		return h.Host.Name
+	}
+
+	return h.Host.Endpoint
+}
+
+// Allocatable returns the vcpu and memory of the host available to microvms, from
+// Capacity or else the allocatable resources of the host. A zero value means no limit.
+func (h *HostInfo) Allocatable() Resources {
+	if h.Capacity != (Resources{}) || h.Host.Allocatable == nil {
+		return h.Capacity
+	}
+
+	return Resources{
+		VCPU:      h.Host.Allocatable.VCPU,
+		MemoryMiB: h.Host.Allocatable.MemoryMiB(),
+	}
+}
+
+// Used returns the resources used by the microvms on the host.
+func (h *HostInfo) Used() Resources {
+	used := Resources{}
+
+	for _, mvm := range h.MicroVMs {
+		if mvm.GetSpec() == nil {
+			continue
+		}
+
+		used = used.Add(Resources{
+			VCPU:      int64(mvm.Spec.Vcpu),
+			MemoryMiB: int64(mvm.Spec.MemoryInMb),
+		})
+	}
+
+	return used
+}
+
+// Request is a microvm to place.
+type Request struct {
+	// Spec is the spec of the microvm, with its instance type resolved.
+	Spec microvm.VMSpec
+	// Labels are the labels of the microvm, as sent to flintlock.
+	Labels map[string]string
+	// HostSelector selects the hosts the microvm may be placed on, by the labels
+	// of the host. If nil any host may be used.
+	HostSelector labels.Selector
+	// Tolerations allow the microvm to be placed on hosts with matching taints.
+	Tolerations []Toleration
+}
+
+// Toleration allows a microvm to be placed on hosts with a matching taint.
+type Toleration struct {
+	// Key is the key of the taints to tolerate. If empty all taints are tolerated.
+	Key string
+	// Effect is the effect of the taints to tolerate. If empty all effects are
+	// tolerated.
+	Effect microvm.TaintEffect
+}
+
+// Tolerates returns true if the toleration matches the taint.
+func (t Toleration) Tolerates(taint microvm.Taint) bool {
+	return (t.Key == "" || t.Key == taint.Key) && (t.Effect == "" || t.Effect == taint.Effect)
+}
+
+// Filter is a plugin which rules out hosts the microvm can't be placed on.
+type Filter interface {
+	// Name is the name of the plugin.
+	Name() string
+	// Filter returns an error describing why the microvm can't be placed on the
+	// host, or nil if it can.
+	Filter(req *Request, host *HostInfo) error
+}
+
+// Scorer is a plugin which ranks the hosts which passed the filters.
+type Scorer interface {
+	// Name is the name of the plugin.
+	Name() string
+	// Score returns the score of the host, between 0 and MaxScore. Higher is better.
+	Score(req *Request, host *HostInfo) int64
+}
+
+type weightedScorer struct {
+	Scorer
+	weight int64
+}
+
+// Scheduler chooses a host for a microvm.
+type Scheduler struct {
+	filters []Filter
+	scorers []weightedScorer
+}
+
+// Option is a func to add an option to the scheduler.
+type Option func(*Scheduler)
+
+// WithFilters adds filters which run after the default filters.
+func WithFilters(filters ...Filter) Option {
+	return func(s *Scheduler) {
+		s.filters = append(s.filters, filters...)
+	}
+}
+
+// WithScorer adds a scorer. The scores of each scorer are multiplied by its weight
+// and summed to rank the hosts.
+func WithScorer(scorer Scorer, weight int64) Option {
+	return func(s *Scheduler) {
+		s.scorers = append(s.scorers, weightedScorer{Scorer: scorer, weight: weight})
+	}
+}
+
+// New returns a scheduler with the default filters, CapacityFilter, ProviderFilter,
+// HostSelectorFilter and TaintFilter. If no scorers are supplied LeastAllocated is used.
+func New(opts ...Option) *Scheduler {
+	s := &Scheduler{
+		filters: []Filter{CapacityFilter(), ProviderFilter(), HostSelectorFilter(), TaintFilter()},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if len(s.scorers) == 0 {
+		s.scorers = []weightedScorer{{Scorer: LeastAllocated(), weight: 1}}
+	}
+
+	return s
+}
+
+// HostScore is the score of a host which the microvm can be placed on.
+type HostScore struct {
+	// Host is the host.
+	Host *HostInfo
+	// Score is the weighted sum of the scores.
+	Score int64
+	// Scores are the scores of each scorer, keyed by name, before weighting.
+	Scores map[string]int64
+}
+
+// Result is the outcome of placing a microvm.
+type Result struct {
+	// Host is the chosen host.
+	Host *HostInfo
+	// Scores are the scores of the hosts which passed the filters, best first.
+	Scores []HostScore
+	// Filtered are the reasons hosts were filtered out, keyed by host name.
+	Filtered map[string]error
+}
+
+// UnschedulableError is returned when every host was filtered out.
+type UnschedulableError struct {
+	// Filtered are the reasons each host was filtered out, keyed by host name.
+	Filtered map[string]error
+}
+
+func (e *UnschedulableError) Error() string {
+	names := make([]string, 0, len(e.Filtered))
+	for name := range e.Filtered {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	reasons := make([]string, 0, len(names))
+	for _, name := range names {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", name, e.Filtered[name]))
+	}
+
+	return fmt.Sprintf("%s: 0/%d hosts available: %s", ErrUnschedulable, len(names), strings.Join(reasons, "; "))
+}
+
+// Is returns true for ErrUnschedulable.
+func (e *UnschedulableError) Is(target error) bool {
+	return target == ErrUnschedulable
+}
+
+// Schedule chooses the host for the microvm. The topology spread constraints and
+// anti-affinity of the spec's placement are applied on top of the configured
+// plugins. Ties are broken by host name so the result is deterministic. If no host
+// passes the filters an *UnschedulableError is returned.
+func (s *Scheduler) Schedule(req *Request, hosts []HostInfo) (*Result, error) {
+	placementFilters, placementScorers, err := placementPlugins(req, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := append(append([]Filter{}, s.filters...), placementFilters...)
+	scorers := append(append([]weightedScorer{}, s.scorers...), placementScorers...)
+
+	result := &Result{Filtered: map[string]error{}}
+
+	for i := range hosts {
+		host := &hosts[i]
+
+		if err := filter(filters, req, host); err != nil {
+			result.Filtered[host.Name()] = err
+
+			continue
+		}
+
+		result.Scores = append(result.Scores, score(scorers, req, host))
+	}
+
+	if len(result.Scores) == 0 {
+		return nil, &UnschedulableError{Filtered: result.Filtered}
+	}
+
+	sort.SliceStable(result.Scores, func(i, j int) bool {
+		if result.Scores[i].Score != result.Scores[j].Score {
+			return result.Scores[i].Score > result.Scores[j].Score
+		}
+
+		return result.Scores[i].Host.Name() < result.Scores[j].Host.Name()
+	})
+
+	result.Host = result.Scores[0].Host
+
+	return result, nil
+}
+
+func filter(filters []Filter, req *Request, host *HostInfo) error {
+	for _, f := range filters {
+		if err := f.Filter(req, host); err != nil {
+			return fmt.Errorf("%s: %w", f.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func score(scorers []weightedScorer, req *Request, host *HostInfo) HostScore {
+	hostScore := HostScore{Host: host, Scores: map[string]int64{}}
+
+	for _, scorer := range scorers {
+		value := scorer.Score(req, host)
+
+		hostScore.Scores[scorer.Name()] += value
+		hostScore.Score += value * scorer.weight
+	}
+
+	return hostScore
+}
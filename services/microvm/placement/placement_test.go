@@ -0,0 +1,232 @@
+package placement
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+
+	flclient "github.com/liquidmetal-dev/controller-pkg/client"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+func mvm(id string, vcpu, mem int32, mvmLabels map[string]string) *flintlocktypes.MicroVM {
+	return &flintlocktypes.MicroVM{
+		Spec: &flintlocktypes.MicroVMSpec{Id: id, Namespace: "ns", Vcpu: vcpu, MemoryInMb: mem, Labels: mvmLabels},
+	}
+}
+
+func testHosts() []HostInfo {
+	return []HostInfo{
+		{
+			Host: microvm.Host{
+				Name:     "empty",
+				Endpoint: "10.0.0.1:9090",
+				Labels:   map[string]string{"tier": "gold", microvm.ZoneLabel: "a"},
+			},
+			Capacity:  Resources{VCPU: 16, MemoryMiB: 16384},
+			Providers: []string{microvm.ProviderFirecracker, microvm.ProviderCloudHypervisor},
+		},
+		{
+			Host: microvm.Host{
+				Name:     "busy",
+				Endpoint: "10.0.0.2:9090",
+				Labels:   map[string]string{"tier": "gold", microvm.ZoneLabel: "b"},
+			},
+			Capacity: Resources{VCPU: 16, MemoryMiB: 16384},
+			MicroVMs: []*flintlocktypes.MicroVM{
+				mvm("a-1", 8, 8192, map[string]string{"cluster": "a"}),
+				mvm("b-1", 2, 2048, map[string]string{"cluster": "b"}),
+			},
+		},
+		{
+			Host:      microvm.Host{Name: "full", Endpoint: "10.0.0.3:9090", Labels: map[string]string{microvm.ZoneLabel: "a"}},
+			Capacity:  Resources{VCPU: 4, MemoryMiB: 4096},
+			Providers: []string{microvm.ProviderFirecracker},
+			MicroVMs: []*flintlocktypes.MicroVM{
+				mvm("b-2", 2, 2048, map[string]string{"cluster": "b"}),
+			},
+		},
+	}
+}
+
+func Test_Schedule(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name      string
+		opts      []Option
+		req       Request
+		expected  string
+		filtered  []string
+		unplaced  bool
+		errSubstr string
+	}{
+		{
+			name:     "least allocated",
+			req:      Request{Spec: microvm.VMSpec{VCPU: 2, MemoryMb: 2048}},
+			expected: "empty",
+		},
+		{
+			name:     "most allocated bin packs",
+			opts:     []Option{WithScorer(MostAllocated(), 1)},
+			req:      Request{Spec: microvm.VMSpec{VCPU: 2, MemoryMb: 2048}},
+			expected: "full",
+		},
+		{
+			name:     "capacity",
+			opts:     []Option{WithScorer(MostAllocated(), 1)},
+			req:      Request{Spec: microvm.VMSpec{VCPU: 4, MemoryMb: 2048}},
+			expected: "busy",
+			filtered: []string{"full"},
+		},
+		{
+			name:     "provider",
+			req:      Request{Spec: microvm.VMSpec{VCPU: 2, MemoryMb: 2048, Provider: microvm.ProviderCloudHypervisor}},
+			expected: "empty",
+			filtered: []string{"full"},
+		},
+		{
+			name: "host selector",
+			opts: []Option{WithScorer(MostAllocated(), 1)},
+			req: Request{
+				Spec:         microvm.VMSpec{VCPU: 2, MemoryMb: 2048},
+				HostSelector: labels.SelectorFromSet(labels.Set{"tier": "gold"}),
+			},
+			expected: "busy",
+			filtered: []string{"full"},
+		},
+		{
+			name:     "spread",
+			opts:     []Option{WithScorer(Spread(labels.SelectorFromSet(labels.Set{"cluster": "a"})), 1)},
+			req:      Request{Spec: microvm.VMSpec{VCPU: 1, MemoryMb: 1024}},
+			expected: "empty",
+		},
+		{
+			name: "unschedulable",
+			req: Request{
+				Spec:         microvm.VMSpec{VCPU: 32, MemoryMb: 2048},
+				HostSelector: labels.SelectorFromSet(labels.Set{"tier": "gold"}),
+			},
+			unplaced:  true,
+			errSubstr: "0/3 hosts available: busy: Capacity: insufficient vcpu",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := New(tc.opts...).Schedule(&tc.req, testHosts())
+
+			if tc.unplaced {
+				g.Expect(err).To(MatchError(ErrUnschedulable))
+				g.Expect(err).To(MatchError(ContainSubstring(tc.errSubstr)))
+
+				var unschedulable *UnschedulableError
+				g.Expect(errors.As(err, &unschedulable)).To(BeTrue())
+				g.Expect(unschedulable.Filtered).To(HaveLen(3))
+
+				return
+			}
+
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(result.Host.Name()).To(Equal(tc.expected))
+
+			filtered := []string{}
+			for name := range result.Filtered {
+				filtered = append(filtered, name)
+			}
+
+			g.Expect(filtered).To(ConsistOf(tc.filtered))
+		})
+	}
+}
+
+func Test_ScheduleTaintsAndAllocatable(t *testing.T) {
+	g := NewWithT(t)
+
+	memory := resource.MustParse("4Gi")
+
+	hosts := testHosts()
+	hosts[0].Host.Taints = []microvm.Taint{{Key: "maintenance", Effect: microvm.TaintEffectNoSchedule}}
+	hosts[1].Capacity = Resources{}
+	hosts[1].Host.Allocatable = &microvm.HostResources{VCPU: 12, Memory: &memory}
+
+	req := &Request{Spec: microvm.VMSpec{VCPU: 2, MemoryMb: 2048}}
+
+	result, err := New().Schedule(req, hosts)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Host.Name()).To(Equal("full"))
+	g.Expect(result.Filtered["empty"]).To(MatchError(ContainSubstring("taint the microvm doesn't tolerate: maintenance:NoSchedule")))
+	g.Expect(result.Filtered["busy"]).To(MatchError(ContainSubstring("insufficient memory: requested 2048Mi, 10240Mi of 4096Mi in use")))
+
+	req.Tolerations = []Toleration{{Key: "maintenance"}}
+
+	result, err = New().Schedule(req, hosts)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Host.Name()).To(Equal("empty"))
+
+	hosts[0].Host.Taints[0].Effect = microvm.TaintEffectNoExecute
+	req.Tolerations = []Toleration{{Key: "maintenance", Effect: microvm.TaintEffectNoSchedule}}
+
+	result, err = New().Schedule(req, hosts)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Host.Name()).To(Equal("full"))
+}
+
+func Test_Scorers(t *testing.T) {
+	g := NewWithT(t)
+
+	hosts := testHosts()
+	req := &Request{Spec: microvm.VMSpec{VCPU: 2, MemoryMb: 2048}}
+
+	g.Expect(LeastAllocated().Score(req, &hosts[0])).To(Equal(int64(88)))
+	g.Expect(MostAllocated().Score(req, &hosts[1])).To(Equal(int64(75)))
+	g.Expect(MostAllocated().Score(req, &hosts[2])).To(Equal(int64(100)))
+	g.Expect(MostAllocated().Score(req, &HostInfo{})).To(Equal(int64(0)))
+	g.Expect(Spread(nil).Score(req, &hosts[1])).To(Equal(MaxScore / 3))
+}
+
+type fakeClient struct {
+	flintlockv1.MicroVMClient
+
+	microvms []*flintlocktypes.MicroVM
+	err      error
+	closed   bool
+}
+
+func (f *fakeClient) ListMicroVMs(
+	context.Context, *flintlockv1.ListMicroVMsRequest, ...grpc.CallOption,
+) (*flintlockv1.ListMicroVMsResponse, error) {
+	return &flintlockv1.ListMicroVMsResponse{Microvm: f.microvms}, f.err
+}
+
+func (f *fakeClient) Close() {
+	f.closed = true
+}
+
+func Test_Refresh(t *testing.T) {
+	g := NewWithT(t)
+
+	clients := map[string]*fakeClient{
+		"10.0.0.1:9090": {microvms: []*flintlocktypes.MicroVM{mvm("c-1", 2, 2048, nil)}},
+		"10.0.0.2:9090": {err: errors.New("unavailable")},
+	}
+
+	hosts := testHosts()[:2]
+
+	errs := Refresh(context.TODO(), hosts, func(host microvm.Host) (flclient.Client, error) {
+		return clients[host.Endpoint], nil
+	})
+
+	g.Expect(errs).To(HaveLen(1))
+	g.Expect(errs).To(HaveKey("busy"))
+	g.Expect(hosts[0].Used()).To(Equal(Resources{VCPU: 2, MemoryMiB: 2048}))
+	g.Expect(hosts[1].Used()).To(Equal(Resources{VCPU: 10, MemoryMiB: 10240}))
+	g.Expect(clients["10.0.0.1:9090"].closed).To(BeTrue())
+}
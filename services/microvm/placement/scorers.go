@@ -0,0 +1,105 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package placement
+
+import "k8s.io/apimachinery/pkg/labels"
+
+type leastAllocated struct{}
+
+// LeastAllocated scores hosts with more free resources, after placing the microvm,
+// higher. It spreads microvms across hosts.
+func LeastAllocated() Scorer {
+	return leastAllocated{}
+}
+
+func (leastAllocated) Name() string {
+	return "LeastAllocated"
+}
+
+func (leastAllocated) Score(req *Request, host *HostInfo) int64 {
+	return MaxScore - allocatedScore(req, host)
+}
+
+type mostAllocated struct{}
+
+// MostAllocated scores hosts with fewer free resources, after placing the microvm,
+// higher. It bin packs microvms onto as few hosts as possible.
+func MostAllocated() Scorer {
+	return mostAllocated{}
+}
+
+func (mostAllocated) Name() string {
+	return "MostAllocated"
+}
+
+func (mostAllocated) Score(req *Request, host *HostInfo) int64 {
+	return allocatedScore(req, host)
+}
+
+type spread struct {
+	selector labels.Selector
+}
+
+// Spread scores hosts with fewer microvms matching the selector higher. A nil
+// selector counts every microvm on the host.
+func Spread(selector labels.Selector) Scorer {
+	if selector == nil {
+		selector = labels.Everything()
+	}
+
+	return spread{selector: selector}
+}
+
+func (spread) Name() string {
+	return "Spread"
+}
+
+func (s spread) Score(_ *Request, host *HostInfo) int64 {
+	count := int64(0)
+
+	for _, mvm := range host.MicroVMs {
+		if s.selector.Matches(labels.Set(mvm.GetSpec().GetLabels())) {
+			count++
+		}
+	}
+
+	return MaxScore / (count + 1)
+}
+
+// allocatedScore returns the fraction of the capacity of the host which is allocated
+// once the microvm is placed, averaged over vcpu and memory, scaled to MaxScore.
+// Hosts with no capacity set score 0.
+func allocatedScore(req *Request, host *HostInfo) int64 {
+	allocated := host.Used().Add(ResourcesForSpec(&req.Spec))
+	capacity := host.Allocatable()
+
+	fractions := []int64{}
+
+	if capacity.VCPU > 0 {
+		fractions = append(fractions, fraction(allocated.VCPU, capacity.VCPU))
+	}
+
+	if capacity.MemoryMiB > 0 {
+		fractions = append(fractions, fraction(allocated.MemoryMiB, capacity.MemoryMiB))
+	}
+
+	if len(fractions) == 0 {
+		return 0
+	}
+
+	total := int64(0)
+	for _, f := range fractions {
+		total += f
+	}
+
+	return total / int64(len(fractions))
+}
+
+func fraction(allocated, capacity int64) int64 {
+	if allocated >= capacity {
+		return MaxScore
+	}
+
+	return allocated * MaxScore / capacity
+}
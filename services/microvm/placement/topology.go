@@ -0,0 +1,228 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package placement
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var (
+	errMissingTopologyLabel = errors.New("host has no topology label")
+	errMaxSkew              = errors.New("spread constraint not met")
+	errAntiAffinity         = errors.New("anti-affinity not met")
+)
+
+// placementPlugins returns the filters and scorers which apply the placement of
+// the spec. Spread constraints need to count microvms across every host, so the
+// plugins are built for each request.
+func placementPlugins(req *Request, hosts []HostInfo) ([]Filter, []weightedScorer, error) {
+	placement := req.Spec.Placement
+	if placement == nil {
+		return nil, nil, nil
+	}
+
+	filters := []Filter{}
+	scorers := []weightedScorer{}
+
+	for i, constraint := range placement.TopologySpreadConstraints {
+		selector, err := metav1.LabelSelectorAsSelector(constraint.LabelSelector)
+		if err != nil {
+			return nil, nil, fmt.Errorf("topology spread constraint %d: %w", i, err)
+		}
+
+		spread := newTopologySpread(constraint, selector, req, hosts)
+
+		if constraint.WhenUnsatisfiable == microvm.ScheduleAnyway {
+			scorers = append(scorers, weightedScorer{Scorer: spread, weight: 1})
+		} else {
+			filters = append(filters, spread)
+		}
+	}
+
+	for i, term := range placement.AntiAffinity {
+		selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+		if err != nil {
+			return nil, nil, fmt.Errorf("anti-affinity term %d: %w", i, err)
+		}
+
+		filters = append(filters, newAntiAffinity(term, selector, hosts))
+	}
+
+	return filters, scorers, nil
+}
+
+// topologyDomain returns the domain of the host in the topology, and whether the
+// host is in the topology. Each host is its own domain when the key is empty or
+// HostnameLabel, unless it has the label.
+func topologyDomain(host *HostInfo, key string) (string, bool) {
+	if value, ok := host.Host.Labels[key]; ok {
+		return value, true
+	}
+
+	if key == "" || key == microvm.HostnameLabel {
+		return host.Name(), true
+	}
+
+	return "", false
+}
+
+func countMatching(host *HostInfo, selector labels.Selector) int64 {
+	count := int64(0)
+
+	for _, mvm := range host.MicroVMs {
+		if selector.Matches(labels.Set(mvm.GetSpec().GetLabels())) {
+			count++
+		}
+	}
+
+	return count
+}
+
+type topologySpread struct {
+	constraint microvm.TopologySpreadConstraint
+	selector   labels.Selector
+	counts     map[string]int64
+	minCount   int64
+	selfMatch  int64
+}
+
+func newTopologySpread(
+	constraint microvm.TopologySpreadConstraint,
+	selector labels.Selector,
+	req *Request,
+	hosts []HostInfo,
+) *topologySpread {
+	spread := &topologySpread{
+		constraint: constraint,
+		selector:   selector,
+		counts:     map[string]int64{},
+	}
+
+	if selector.Matches(labels.Set(req.Labels)) {
+		spread.selfMatch = 1
+	}
+
+	for i := range hosts {
+		host := &hosts[i]
+
+		if req.HostSelector != nil && !req.HostSelector.Matches(labels.Set(host.Host.Labels)) {
+			continue
+		}
+
+		if domain, ok := topologyDomain(host, constraint.TopologyKey); ok {
+			spread.counts[domain] += countMatching(host, selector)
+		}
+	}
+
+	first := true
+
+	for _, count := range spread.counts {
+		if first || count < spread.minCount {
+			spread.minCount = count
+			first = false
+		}
+	}
+
+	return spread
+}
+
+func (t *topologySpread) Name() string {
+	return "TopologySpread"
+}
+
+// skew returns the skew of the domain of the host if the microvm is placed on it.
+func (t *topologySpread) skew(host *HostInfo) (int64, string, bool) {
+	domain, ok := topologyDomain(host, t.constraint.TopologyKey)
+	if !ok {
+		return 0, "", false
+	}
+
+	return t.counts[domain] + t.selfMatch - t.minCount, domain, true
+}
+
+func (t *topologySpread) Filter(_ *Request, host *HostInfo) error {
+	skew, domain, ok := t.skew(host)
+	if !ok {
+		return fmt.Errorf("%w %s", errMissingTopologyLabel, t.constraint.TopologyKey)
+	}
+
+	if skew > int64(t.constraint.MaxSkew) {
+		return fmt.Errorf("%w: %s=%s would have %d microvms matching %s, a skew of %d which exceeds the max of %d",
+			errMaxSkew, t.constraint.TopologyKey, domain, t.counts[domain]+t.selfMatch, t.selector, skew, t.constraint.MaxSkew)
+	}
+
+	return nil
+}
+
+func (t *topologySpread) Score(_ *Request, host *HostInfo) int64 {
+	skew, _, ok := t.skew(host)
+	if !ok {
+		return 0
+	}
+
+	if skew < 0 {
+		skew = 0
+	}
+
+	return MaxScore / (skew + 1)
+}
+
+type antiAffinity struct {
+	term     microvm.AntiAffinityTerm
+	selector labels.Selector
+	matches  map[string]string
+}
+
+func newAntiAffinity(term microvm.AntiAffinityTerm, selector labels.Selector, hosts []HostInfo) *antiAffinity {
+	affinity := &antiAffinity{
+		term:     term,
+		selector: selector,
+		matches:  map[string]string{},
+	}
+
+	for i := range hosts {
+		host := &hosts[i]
+
+		domain, ok := topologyDomain(host, term.TopologyKey)
+		if !ok {
+			continue
+		}
+
+		for _, mvm := range host.MicroVMs {
+			spec := mvm.GetSpec()
+			if _, found := affinity.matches[domain]; !found && selector.Matches(labels.Set(spec.GetLabels())) {
+				affinity.matches[domain] = fmt.Sprintf("%s/%s on host %s", spec.GetNamespace(), spec.GetId(), host.Name())
+			}
+		}
+	}
+
+	return affinity
+}
+
+func (a *antiAffinity) Name() string {
+	return "AntiAffinity"
+}
+
+func (a *antiAffinity) Filter(_ *Request, host *HostInfo) error {
+	domain, ok := topologyDomain(host, a.term.TopologyKey)
+	if !ok {
+		return nil
+	}
+
+	if match, found := a.matches[domain]; found {
+		key := a.term.TopologyKey
+		if key == "" {
+			key = microvm.HostnameLabel
+		}
+
+		return fmt.Errorf("%w: %s=%s already has microvm %s matching %s", errAntiAffinity, key, domain, match, a.selector)
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package placement
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+func clusterSelector(cluster string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{MatchLabels: map[string]string{"cluster": cluster}}
+}
+
+func Test_SchedulePlacement(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name      string
+		placement microvm.PlacementSpec
+		expected  string
+		filtered  []string
+		errSubstr []string
+	}{
+		{
+			name: "spread across zones",
+			placement: microvm.PlacementSpec{
+				TopologySpreadConstraints: []microvm.TopologySpreadConstraint{
+					{MaxSkew: 1, TopologyKey: microvm.ZoneLabel, LabelSelector: clusterSelector("a")},
+				},
+			},
+			expected: "empty",
+			filtered: []string{"busy"},
+		},
+		{
+			name: "anti-affinity per host",
+			placement: microvm.PlacementSpec{
+				AntiAffinity: []microvm.AntiAffinityTerm{{LabelSelector: clusterSelector("b")}},
+			},
+			expected: "empty",
+			filtered: []string{"busy", "full"},
+		},
+		{
+			name: "anti-affinity per zone",
+			placement: microvm.PlacementSpec{
+				AntiAffinity: []microvm.AntiAffinityTerm{
+					{TopologyKey: microvm.ZoneLabel, LabelSelector: clusterSelector("a")},
+				},
+			},
+			expected: "empty",
+			filtered: []string{"busy"},
+		},
+		{
+			name: "spread when unsatisfiable",
+			placement: microvm.PlacementSpec{
+				TopologySpreadConstraints: []microvm.TopologySpreadConstraint{
+					{
+						MaxSkew:           1,
+						TopologyKey:       microvm.ZoneLabel,
+						LabelSelector:     clusterSelector("a"),
+						WhenUnsatisfiable: microvm.ScheduleAnyway,
+					},
+				},
+			},
+			expected: "empty",
+			filtered: []string{},
+		},
+		{
+			name: "constraints can't be met",
+			placement: microvm.PlacementSpec{
+				AntiAffinity: []microvm.AntiAffinityTerm{
+					{TopologyKey: microvm.ZoneLabel, LabelSelector: clusterSelector("b")},
+				},
+			},
+			errSubstr: []string{
+				"busy: AntiAffinity: anti-affinity not met: topology.kubernetes.io/zone=b already has microvm ns/b-1 on host busy matching cluster=b",
+				"empty: AntiAffinity: anti-affinity not met: topology.kubernetes.io/zone=a already has microvm ns/b-2 on host full matching cluster=b",
+			},
+		},
+		{
+			name: "hosts without the topology label",
+			placement: microvm.PlacementSpec{
+				TopologySpreadConstraints: []microvm.TopologySpreadConstraint{
+					{MaxSkew: 1, TopologyKey: microvm.FailureDomainLabel, LabelSelector: clusterSelector("a")},
+				},
+			},
+			errSubstr: []string{
+				"full: TopologySpread: host has no topology label topology.liquidmetal.dev/failure-domain",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			placement := tc.placement
+			req := &Request{
+				Spec:   microvm.VMSpec{VCPU: 1, MemoryMb: 1024, Placement: &placement},
+				Labels: map[string]string{"cluster": "a"},
+			}
+
+			result, err := New().Schedule(req, testHosts())
+
+			if len(tc.errSubstr) > 0 {
+				g.Expect(err).To(MatchError(ErrUnschedulable))
+
+				for _, substr := range tc.errSubstr {
+					g.Expect(err.Error()).To(ContainSubstring(substr))
+				}
+
+				return
+			}
+
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(result.Host.Name()).To(Equal(tc.expected))
+
+			filtered := []string{}
+			for name := range result.Filtered {
+				filtered = append(filtered, name)
+			}
+
+			g.Expect(filtered).To(ConsistOf(tc.filtered))
+		})
+	}
+}
+
+func Test_TopologySpreadScore(t *testing.T) {
+	g := NewWithT(t)
+
+	hosts := testHosts()
+	req := &Request{
+		Spec:   microvm.VMSpec{VCPU: 1, MemoryMb: 1024},
+		Labels: map[string]string{"cluster": "a"},
+	}
+
+	constraint := microvm.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: microvm.ZoneLabel, LabelSelector: clusterSelector("a")}
+	req.Spec.Placement = &microvm.PlacementSpec{TopologySpreadConstraints: []microvm.TopologySpreadConstraint{constraint}}
+
+	_, scorers, err := placementPlugins(req, hosts)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(scorers).To(BeEmpty())
+
+	constraint.WhenUnsatisfiable = microvm.ScheduleAnyway
+	req.Spec.Placement = &microvm.PlacementSpec{TopologySpreadConstraints: []microvm.TopologySpreadConstraint{constraint}}
+
+	filters, scorers, err := placementPlugins(req, hosts)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(filters).To(BeEmpty())
+	g.Expect(scorers).To(HaveLen(1))
+
+	g.Expect(scorers[0].Score(req, &hosts[0])).To(Equal(MaxScore / 2))
+	g.Expect(scorers[0].Score(req, &hosts[1])).To(Equal(MaxScore / 3))
+}
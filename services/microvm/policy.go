@@ -0,0 +1,400 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+// policyCostLimit limits the cost of evaluating a policy, so an expensive expression
+// can't stall the creation of microvms.
+const policyCostLimit = 1000000
+
+// ErrPolicyDenied is returned when a microvm violates a policy with the Deny action.
+// The error is a *PolicyDeniedError, holding the violations.
+var ErrPolicyDenied = errors.New("denied by policy")
+
+var (
+	errUnsupportedPolicyAction = errors.New("unsupported policy action")
+	errPolicyNotBool           = errors.New("expression doesn't return a bool")
+)
+
+// PolicyAction is what happens when a microvm violates a policy.
+type PolicyAction string
+
+const (
+	// PolicyActionDeny stops the microvm being created.
+	PolicyActionDeny = PolicyAction("Deny")
+	// PolicyActionWarn lets the microvm be created, reporting the violation to scopes
+	// which implement PolicyWarningScope.
+	PolicyActionWarn = PolicyAction("Warn")
+)
+
+// Policy is a rule microvms must follow, written as a CEL expression. The expression
+// must evaluate to true for a microvm to be allowed, and can use the variables:
+//   - spec: the microvm spec, with its instance type resolved, using its JSON field names.
+//     The memory is also set as a number of mebibytes in spec.memoryMiB, as
+//     spec.memory is a quantity string such as "2Gi" and spec.memoryMb is deprecated.
+//   - scope: the name, namespace and labels of the scope, as scope.name, scope.namespace
+//     and scope.labels.
+//   - host: the host the microvm is created on, using its JSON field names.
+//
+// For example, to only allow kernels from an internal registry:
+//
+//	spec.kernel.image.startsWith('registry.internal/')
+//
+// Or to limit the memory of microvms to 8Gi:
+//
+//	spec.memoryMiB <= 8192
+type Policy struct {
+	// Name is the name of the policy.
+	Name string `json:"name"`
+	// Expression is the CEL expression, which must evaluate to a bool.
+	Expression string `json:"expression"`
+	// Message describes the violation. If not set the expression is used.
+	Message string `json:"message,omitempty"`
+	// Action is what happens when the policy is violated. It defaults to Deny.
+	Action PolicyAction `json:"action,omitempty"`
+}
+
+// PolicyConfig is the format of a policy config file:
+//
+//	policies:
+//	- name: max-vcpu
+//	  expression: spec.vcpu <= 8 || 'big' in scope.labels
+//	  message: microvms with more than 8 vcpus need the big label
+//	  action: Warn
+type PolicyConfig struct {
+	// Policies are the policies.
+	Policies []Policy `json:"policies"`
+}
+
+// PolicyInput is what policies are evaluated against.
+type PolicyInput struct {
+	// Spec is the microvm spec, with its instance type resolved.
+	Spec *microvm.VMSpec
+	// Name is the name of the scope.
+	Name string
+	// Namespace is the namespace of the scope.
+	Namespace string
+	// Labels are the labels of the scope.
+	Labels map[string]string
+	// Host is the host the microvm is created on.
+	Host microvm.Host
+}
+
+// PolicyViolation is a policy a microvm violates.
+type PolicyViolation struct {
+	// Policy is the name of the policy.
+	Policy string
+	// Action is the action of the policy.
+	Action PolicyAction
+	// Message describes the violation.
+	Message string
+}
+
+func (v PolicyViolation) String() string {
+	return v.Policy + ": " + v.Message
+}
+
+// PolicyWarningScope is implemented by scopes which report the violations of Warn
+// policies, such as by recording an event.
+type PolicyWarningScope interface {
+	// PolicyWarning reports a violation of a Warn policy.
+	PolicyWarning(violation PolicyViolation)
+}
+
+// PolicyDeniedError is returned when a microvm violates policies with the Deny action.
+type PolicyDeniedError struct {
+	// Violations are the Deny policies the microvm violates.
+	Violations []PolicyViolation
+}
+
+func (e *PolicyDeniedError) Error() string {
+	reasons := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		reasons = append(reasons, violation.String())
+	}
+
+	return fmt.Sprintf("%s: %s", ErrPolicyDenied, strings.Join(reasons, "; "))
+}
+
+// Is returns true for ErrPolicyDenied.
+func (e *PolicyDeniedError) Is(target error) bool {
+	return target == ErrPolicyDenied
+}
+
+type compiledPolicy struct {
+	Policy
+
+	program cel.Program
+}
+
+// PolicyEngine evaluates policies against microvms.
+type PolicyEngine struct {
+	policies []compiledPolicy
+}
+
+// NewPolicyEngine compiles the policies, returning an error if any expression is
+// invalid or doesn't evaluate to a bool.
+func NewPolicyEngine(policies ...Policy) (*PolicyEngine, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("spec", cel.DynType),
+		cel.Variable("scope", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("host", cel.DynType),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating policy environment: %w", err)
+	}
+
+	engine := &PolicyEngine{}
+
+	for _, policy := range policies {
+		compiled, err := compilePolicy(env, policy)
+		if err != nil {
+			return nil, fmt.Errorf("compiling policy %s: %w", policy.Name, err)
+		}
+
+		engine.policies = append(engine.policies, compiled)
+	}
+
+	return engine, nil
+}
+
+// LoadPolicies reads policies in the PolicyConfig format, as YAML or JSON.
+func LoadPolicies(r io.Reader) (*PolicyEngine, error) {
+	config := PolicyConfig{}
+
+	if err := yaml.NewYAMLOrJSONDecoder(r, 4096).Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decoding policies: %w", err)
+	}
+
+	return NewPolicyEngine(config.Policies...)
+}
+
+// LoadPoliciesFile reads policies from a config file, see LoadPolicies.
+func LoadPoliciesFile(path string) (*PolicyEngine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening policies file: %w", err)
+	}
+	defer file.Close()
+
+	return LoadPolicies(file)
+}
+
+func compilePolicy(env *cel.Env, policy Policy) (compiledPolicy, error) {
+	switch policy.Action {
+	case "":
+		policy.Action = PolicyActionDeny
+	case PolicyActionDeny, PolicyActionWarn:
+	default:
+		return compiledPolicy{}, fmt.Errorf("%w: %s", errUnsupportedPolicyAction, policy.Action)
+	}
+
+	ast, issues := env.Compile(policy.Expression)
+	if issues.Err() != nil {
+		return compiledPolicy{}, issues.Err()
+	}
+
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return compiledPolicy{}, fmt.Errorf("%w: returns %s", errPolicyNotBool, ast.OutputType())
+	}
+
+	program, err := env.Program(ast, cel.CostLimit(policyCostLimit))
+	if err != nil {
+		return compiledPolicy{}, err
+	}
+
+	if policy.Message == "" {
+		policy.Message = "failed expression: " + policy.Expression
+	}
+
+	return compiledPolicy{Policy: policy, program: program}, nil
+}
+
+// Evaluate returns the policies the input violates. A policy whose expression fails
+// to evaluate, such as by referring to a field the spec doesn't set, is violated.
+func (e *PolicyEngine) Evaluate(input *PolicyInput) ([]PolicyViolation, error) {
+	spec, err := specToCELValue(input.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("converting spec: %w", err)
+	}
+
+	host, err := toCELValue(input.Host)
+	if err != nil {
+		return nil, fmt.Errorf("converting host: %w", err)
+	}
+
+	labels := input.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	vars := map[string]interface{}{
+		"spec": spec,
+		"scope": map[string]interface{}{
+			"name":      input.Name,
+			"namespace": input.Namespace,
+			"labels":    labels,
+		},
+		"host": host,
+	}
+
+	violations := []PolicyViolation{}
+
+	for _, policy := range e.policies {
+		violation := PolicyViolation{Policy: policy.Name, Action: policy.Action, Message: policy.Message}
+
+		out, _, err := policy.program.Eval(vars)
+		if err != nil {
+			violation.Message = fmt.Sprintf("%s: evaluating expression: %s", policy.Message, err)
+			violations = append(violations, violation)
+
+			continue
+		}
+
+		if allowed, ok := out.Value().(bool); !ok || !allowed {
+			violations = append(violations, violation)
+		}
+	}
+
+	return violations, nil
+}
+
+// specToCELValue converts the spec with toCELValue, adding memoryMiB.
+func specToCELValue(spec *microvm.VMSpec) (interface{}, error) {
+	value, err := toCELValue(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if fields, ok := value.(map[string]interface{}); ok {
+		fields["memoryMiB"] = spec.MemoryMiB()
+	}
+
+	return value, nil
+}
+
+// toCELValue converts v to the JSON representation CEL expressions are written
+// against, with whole numbers as ints so they can be compared with int literals.
+func toCELValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return convertNumbers(value), nil
+}
+
+func convertNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+
+		f, _ := v.Float64()
+
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = convertNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertNumbers(item)
+		}
+	}
+
+	return value
+}
+
+// WithPolicies sets the policies microvms are checked against before they are created.
+func WithPolicies(engine *PolicyEngine) Option {
+	return func(s *Service) {
+		s.policies = engine
+	}
+}
+
+// WithHost sets the host the microvm is created on, which policies are evaluated
+// against. If not set the host only has the name hostID.
+func WithHost(host microvm.Host) Option {
+	return func(s *Service) {
+		s.host = &host
+	}
+}
+
+// checkPolicies evaluates the policies against the spec, returning the violations of
+// Warn policies, and a *PolicyDeniedError for Deny policies.
+func (s *Service) checkPolicies(spec *microvm.VMSpec) ([]PolicyViolation, error) {
+	if s.policies == nil {
+		return nil, nil
+	}
+
+	host := microvm.Host{Name: s.hostID}
+	if s.host != nil {
+		host = *s.host
+	}
+
+	violations, err := s.policies.Evaluate(&PolicyInput{
+		Spec:      spec,
+		Name:      s.scope.Name(),
+		Namespace: s.scope.Namespace(),
+		Labels:    s.scope.GetLabels(),
+		Host:      host,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	denied := &PolicyDeniedError{}
+	warnings := []PolicyViolation{}
+
+	for _, violation := range violations {
+		if violation.Action == PolicyActionDeny {
+			denied.Violations = append(denied.Violations, violation)
+		} else {
+			warnings = append(warnings, violation)
+		}
+	}
+
+	if len(denied.Violations) > 0 {
+		return warnings, denied
+	}
+
+	return warnings, nil
+}
+
+// reportPolicyWarnings reports the violations of Warn policies to scopes which
+// implement PolicyWarningScope.
+func (s *Service) reportPolicyWarnings(warnings []PolicyViolation) {
+	ws, ok := s.scope.(PolicyWarningScope)
+	if !ok {
+		return
+	}
+
+	for _, violation := range warnings {
+		ws.PolicyWarning(violation)
+	}
+}
@@ -0,0 +1,182 @@
+package microvm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/fakes"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+const testPolicies = `
+policies:
+- name: internal-kernels
+  expression: spec.kernel.image.startsWith('registry.internal/')
+  message: kernel images must come from registry.internal
+- name: big-microvms
+  expression: spec.vcpu <= 8 || 'big' in scope.labels
+  action: Warn
+- name: virtiofs
+  expression: "!has(spec.volumes) || spec.volumes.all(v, !has(v.virtiofsPath)) || scope.namespace == 'shared'"
+- name: hosts
+  expression: "!('legacy' in host.labels)"
+- name: memory
+  expression: spec.memoryMiB <= 8192
+  message: microvms are limited to 8Gi
+`
+
+type warningScope struct {
+	*fakes.FakeScope
+
+	warnings []PolicyViolation
+}
+
+func (s *warningScope) PolicyWarning(violation PolicyViolation) {
+	s.warnings = append(s.warnings, violation)
+}
+
+func Test_PolicyEngine(t *testing.T) {
+	g := NewWithT(t)
+
+	engine, err := LoadPolicies(strings.NewReader(testPolicies))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	tt := []struct {
+		name      string
+		mutate    func(*PolicyInput)
+		expected  []string
+		evalError bool
+	}{
+		{
+			name:     "allowed",
+			mutate:   func(*PolicyInput) {},
+			expected: []string{},
+		},
+		{
+			name:     "kernel registry",
+			mutate:   func(in *PolicyInput) { in.Spec.Kernel.Image = "docker.io/kernel:latest" },
+			expected: []string{"internal-kernels: kernel images must come from registry.internal"},
+		},
+		{
+			name:     "vcpu warning",
+			mutate:   func(in *PolicyInput) { in.Spec.VCPU = 16 },
+			expected: []string{"big-microvms: failed expression: spec.vcpu <= 8 || 'big' in scope.labels"},
+		},
+		{
+			name: "vcpu with label",
+			mutate: func(in *PolicyInput) {
+				in.Spec.VCPU = 16
+				in.Labels = map[string]string{"big": "true"}
+			},
+			expected: []string{},
+		},
+		{
+			name: "virtiofs",
+			mutate: func(in *PolicyInput) {
+				in.Spec.AdditionalVolumes = []microvm.Volume{{ID: "data", Image: "data:latest"}, {ID: "fs", VirtioFSPath: "/mnt"}}
+			},
+			expected: []string{"virtiofs: failed expression: " +
+				"!has(spec.volumes) || spec.volumes.all(v, !has(v.virtiofsPath)) || scope.namespace == 'shared'"},
+		},
+		{
+			name: "virtiofs in namespace",
+			mutate: func(in *PolicyInput) {
+				in.Spec.AdditionalVolumes = []microvm.Volume{{ID: "fs", VirtioFSPath: "/mnt"}}
+				in.Namespace = "shared"
+			},
+			expected: []string{},
+		},
+		{
+			name: "memory quantity",
+			mutate: func(in *PolicyInput) {
+				in.Spec.MemoryMb = 0
+				in.Spec.Memory = resource.NewQuantity(16*microvm.Mebibyte*1024, resource.BinarySI)
+			},
+			expected: []string{"memory: microvms are limited to 8Gi"},
+		},
+		{
+			name:      "host without labels",
+			mutate:    func(in *PolicyInput) { in.Host.Labels = nil },
+			expected:  []string{"hosts: failed expression: !('legacy' in host.labels): evaluating expression: no such key: labels"},
+			evalError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := microvm.VMSpec{VCPU: 2, MemoryMb: 2048, Kernel: microvm.ContainerFileSource{Image: "registry.internal/kernel:6.1"}}
+			input := &PolicyInput{
+				Spec:      &spec,
+				Name:      "foo",
+				Namespace: "bar",
+				Host:      microvm.Host{Name: "host1", Labels: map[string]string{microvm.ZoneLabel: "a"}},
+			}
+
+			tc.mutate(input)
+
+			violations, err := engine.Evaluate(input)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			messages := []string{}
+			for _, violation := range violations {
+				messages = append(messages, violation.String())
+			}
+
+			g.Expect(messages).To(Equal(tc.expected))
+		})
+	}
+}
+
+func Test_PolicyEngineInvalid(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewPolicyEngine(Policy{Name: "syntax", Expression: "spec.vcpu <="})
+	g.Expect(err).To(MatchError(ContainSubstring("compiling policy syntax")))
+
+	_, err = NewPolicyEngine(Policy{Name: "string", Expression: "scope.name + '-vm'"})
+	g.Expect(err).To(MatchError(errPolicyNotBool))
+
+	_, err = NewPolicyEngine(Policy{Name: "action", Expression: "true", Action: "Audit"})
+	g.Expect(err).To(MatchError(errUnsupportedPolicyAction))
+}
+
+func Test_CreateChecksPolicies(t *testing.T) {
+	g := NewWithT(t)
+
+	engine, err := NewPolicyEngine(
+		Policy{Name: "small", Expression: "spec.vcpu < 2", Action: PolicyActionWarn},
+		Policy{Name: "host", Expression: "host.name == 'host'"},
+		Policy{Name: "namespace", Expression: "scope.namespace != 'bar'", Message: "bar is reserved"},
+	)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	scope := &warningScope{FakeScope: validScope()}
+
+	// The client is nil, so reaching the RPC would panic.
+	_, err = New(scope, nil, "host", WithPolicies(engine)).Create(context.TODO())
+	g.Expect(err).To(MatchError(ErrPolicyDenied))
+	g.Expect(err).To(MatchError("checking policies: denied by policy: namespace: bar is reserved"))
+
+	var denied *PolicyDeniedError
+	g.Expect(errors.As(err, &denied)).To(BeTrue())
+	g.Expect(denied.Violations).To(HaveLen(1))
+
+	g.Expect(scope.warnings).To(HaveLen(1))
+	g.Expect(scope.warnings[0].Policy).To(Equal("small"))
+
+	scope.NamespaceReturns("baz")
+
+	rendered, err := New(scope, nil, "host", WithPolicies(engine)).Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rendered.Request.Microvm.Namespace).To(Equal("baz"))
+	g.Expect(rendered.Warnings).To(HaveLen(1))
+	g.Expect(scope.warnings).To(HaveLen(1), "render doesn't report warnings")
+
+	_, err = New(scope, nil, "host", WithPolicies(engine), WithHost(microvm.Host{Name: "other"})).Render(context.TODO())
+	g.Expect(err).To(MatchError(ContainSubstring("denied by policy: host: failed expression: host.name == 'host'")))
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package quota limits the vcpu, memory, volumes and microvms used by each
+// namespace across flintlock hosts.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	flclient "github.com/liquidmetal-dev/controller-pkg/client"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+// ErrQuotaExceeded is returned when creating a microvm would exceed the quota of its
+// namespace. The error is a *QuotaExceededError, holding the usage and limits.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ResourceName is a resource limited by a quota.
+type ResourceName string
+
+const (
+	// ResourceVCPU is the number of vcpus of the microvms.
+	ResourceVCPU = ResourceName("vcpu")
+	// ResourceMemory is the memory of the microvms, in mebibytes.
+	ResourceMemory = ResourceName("memory")
+	// ResourceVolumes is the number of volumes of the microvms, including the root volume.
+	ResourceVolumes = ResourceName("volumes")
+	// ResourceMicroVMs is the number of microvms.
+	ResourceMicroVMs = ResourceName("microvms")
+)
+
+// ResourceList is an amount of each resource.
+type ResourceList map[ResourceName]int64
+
+// Add returns the sum of the resource lists.
+func (l ResourceList) Add(other ResourceList) ResourceList {
+	sum := ResourceList{}
+
+	for name, value := range l {
+		sum[name] += value
+	}
+
+	for name, value := range other {
+		sum[name] += value
+	}
+
+	return sum
+}
+
+// Quotas are the limits of each namespace, keyed by the flintlock namespace of the
+// microvms, which is the namespace produced by the naming strategy of the service,
+// such as cluster-namespace with ClusterPrefixedNaming. Resources missing from the
+// limits of a namespace, and namespaces without quotas, aren't limited.
+type Quotas map[string]ResourceList
+
+// UsageFunc returns the resources used in each namespace, keyed by flintlock namespace.
+type UsageFunc func(ctx context.Context) (map[string]ResourceList, error)
+
+// ResourcesFor returns the resources used by a microvm.
+func ResourcesFor(spec *flintlocktypes.MicroVMSpec) ResourceList {
+	volumes := int64(len(spec.GetAdditionalVolumes()))
+	if spec.GetRootVolume() != nil {
+		volumes++
+	}
+
+	return ResourceList{
+		ResourceVCPU:     int64(spec.GetVcpu()),
+		ResourceMemory:   int64(spec.GetMemoryInMb()),
+		ResourceVolumes:  volumes,
+		ResourceMicroVMs: 1,
+	}
+}
+
+// Sum returns the resources used by the microvms in each namespace, keyed by namespace.
+// Failed microvms and microvms being deleted aren't counted.
+func Sum(microvms []*flintlocktypes.MicroVM) map[string]ResourceList {
+	usage := map[string]ResourceList{}
+
+	for _, mvm := range microvms {
+		if mvm.GetSpec() == nil {
+			continue
+		}
+
+		switch mvm.GetStatus().GetState() {
+		case flintlocktypes.MicroVMStatus_FAILED, flintlocktypes.MicroVMStatus_DELETING:
+			continue
+		}
+
+		namespace := mvm.Spec.GetNamespace()
+		usage[namespace] = usage[namespace].Add(ResourcesFor(mvm.Spec))
+	}
+
+	return usage
+}
+
+// HostsUsage returns a UsageFunc which lists the microvms on all the hosts, using
+// clientFor to connect to each host. If any host can't be listed the usage is
+// unknown, and an error is returned.
+func HostsUsage(hosts []microvm.Host, clientFor func(host microvm.Host) (flclient.Client, error)) UsageFunc {
+	return func(ctx context.Context) (map[string]ResourceList, error) {
+		microvms := []*flintlocktypes.MicroVM{}
+
+		for _, host := range hosts {
+			hostMicrovms, err := listHost(ctx, host, clientFor)
+			if err != nil {
+				return nil, err
+			}
+
+			microvms = append(microvms, hostMicrovms...)
+		}
+
+		return Sum(microvms), nil
+	}
+}
+
+func listHost(
+	ctx context.Context, host microvm.Host, clientFor func(host microvm.Host) (flclient.Client, error),
+) ([]*flintlocktypes.MicroVM, error) {
+	client, err := clientFor(host)
+	if err != nil {
+		return nil, fmt.Errorf("creating client for host %s: %w", host.Endpoint, err)
+	}
+	defer client.Close()
+
+	resp, err := client.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("listing microvms on host %s: %w", host.Endpoint, err)
+	}
+
+	return resp.GetMicrovm(), nil
+}
+
+// Enforcer checks microvms can be created without exceeding the quota of their
+// namespace. It doesn't reserve the resources, so microvms created at the same time
+// in a namespace can together exceed its quota.
+type Enforcer struct {
+	quotas Quotas
+	usage  UsageFunc
+}
+
+// NewEnforcer returns an enforcer of the quotas, with the current usage from usage.
+func NewEnforcer(quotas Quotas, usage UsageFunc) *Enforcer {
+	return &Enforcer{quotas: quotas, usage: usage}
+}
+
+// Check returns a *QuotaExceededError if creating the microvm would exceed the quota
+// of its namespace. The usage is only looked up for namespaces with a quota.
+func (e *Enforcer) Check(ctx context.Context, spec *flintlocktypes.MicroVMSpec) error {
+	namespace := spec.GetNamespace()
+
+	limits, ok := e.quotas[namespace]
+	if !ok || len(limits) == 0 {
+		return nil
+	}
+
+	usage, err := e.usage(ctx)
+	if err != nil {
+		return fmt.Errorf("getting quota usage: %w", err)
+	}
+
+	requested := ResourcesFor(spec)
+	used := usage[namespace]
+
+	exceeded := &QuotaExceededError{
+		Namespace: namespace,
+		Requested: ResourceList{},
+		Used:      ResourceList{},
+		Limit:     ResourceList{},
+	}
+
+	for name, limit := range limits {
+		if used[name]+requested[name] <= limit {
+			continue
+		}
+
+		exceeded.Requested[name] = requested[name]
+		exceeded.Used[name] = used[name]
+		exceeded.Limit[name] = limit
+	}
+
+	if len(exceeded.Limit) == 0 {
+		return nil
+	}
+
+	return exceeded
+}
+
+// QuotaExceededError is returned when creating a microvm would exceed the quota of
+// its namespace. It holds only the resources which would be exceeded.
+type QuotaExceededError struct {
+	// Namespace is the namespace of the microvm.
+	Namespace string
+	// Requested are the resources of the microvm.
+	Requested ResourceList
+	// Used are the resources already used in the namespace.
+	Used ResourceList
+	// Limit are the limits of the namespace.
+	Limit ResourceList
+}
+
+func (e *QuotaExceededError) Error() string {
+	names := make([]string, 0, len(e.Limit))
+	for name := range e.Limit {
+		names = append(names, string(name))
+	}
+
+	sort.Strings(names)
+
+	reasons := make([]string, 0, len(names))
+	for _, name := range names {
+		resource := ResourceName(name)
+		reasons = append(reasons, fmt.Sprintf("%s: requested %d, used %d, limited to %d",
+			resource, e.Requested[resource], e.Used[resource], e.Limit[resource]))
+	}
+
+	return fmt.Sprintf("%s in namespace %s: %s", ErrQuotaExceeded, e.Namespace, strings.Join(reasons, "; "))
+}
+
+// Is returns true for ErrQuotaExceeded.
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
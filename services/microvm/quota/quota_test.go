@@ -0,0 +1,159 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+
+	flclient "github.com/liquidmetal-dev/controller-pkg/client"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+type fakeClient struct {
+	flintlockv1.MicroVMClient
+
+	microvms []*flintlocktypes.MicroVM
+	err      error
+	listed   int
+}
+
+func (f *fakeClient) ListMicroVMs(
+	context.Context, *flintlockv1.ListMicroVMsRequest, ...grpc.CallOption,
+) (*flintlockv1.ListMicroVMsResponse, error) {
+	f.listed++
+
+	return &flintlockv1.ListMicroVMsResponse{Microvm: f.microvms}, f.err
+}
+
+func (f *fakeClient) Close() {}
+
+func mvmSpec(namespace string, vcpu, mem int32, volumes int) *flintlocktypes.MicroVMSpec {
+	spec := &flintlocktypes.MicroVMSpec{Namespace: namespace, Vcpu: vcpu, MemoryInMb: mem}
+
+	if volumes > 0 {
+		spec.RootVolume = &flintlocktypes.Volume{Id: "root"}
+	}
+
+	for i := 1; i < volumes; i++ {
+		spec.AdditionalVolumes = append(spec.AdditionalVolumes, &flintlocktypes.Volume{})
+	}
+
+	return spec
+}
+
+func testHosts() ([]microvm.Host, map[string]*fakeClient, func(microvm.Host) (flclient.Client, error)) {
+	hosts := []microvm.Host{{Endpoint: "10.0.0.1:9090"}, {Endpoint: "10.0.0.2:9090"}}
+	clients := map[string]*fakeClient{
+		"10.0.0.1:9090": {microvms: []*flintlocktypes.MicroVM{
+			{Spec: mvmSpec("team-a", 4, 4096, 2)},
+			{Spec: mvmSpec("team-b", 2, 2048, 1)},
+			{
+				Spec:   mvmSpec("team-b", 2, 2048, 1),
+				Status: &flintlocktypes.MicroVMStatus{State: flintlocktypes.MicroVMStatus_FAILED},
+			},
+			{
+				Spec:   mvmSpec("team-b", 2, 2048, 1),
+				Status: &flintlocktypes.MicroVMStatus{State: flintlocktypes.MicroVMStatus_DELETING},
+			},
+		}},
+		"10.0.0.2:9090": {microvms: []*flintlocktypes.MicroVM{
+			{Spec: mvmSpec("team-a", 2, 2048, 1)},
+			{},
+		}},
+	}
+
+	return hosts, clients, func(host microvm.Host) (flclient.Client, error) {
+		return clients[host.Endpoint], nil
+	}
+}
+
+func Test_HostsUsage(t *testing.T) {
+	g := NewWithT(t)
+
+	hosts, clients, clientFor := testHosts()
+
+	usage, err := HostsUsage(hosts, clientFor)(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(usage).To(Equal(map[string]ResourceList{
+		"team-a": {ResourceVCPU: 6, ResourceMemory: 6144, ResourceVolumes: 3, ResourceMicroVMs: 2},
+		"team-b": {ResourceVCPU: 2, ResourceMemory: 2048, ResourceVolumes: 1, ResourceMicroVMs: 1},
+	}))
+
+	clients["10.0.0.2:9090"].err = errors.New("unavailable")
+
+	_, err = HostsUsage(hosts, clientFor)(context.TODO())
+	g.Expect(err).To(MatchError(ContainSubstring("listing microvms on host 10.0.0.2:9090: unavailable")))
+}
+
+func Test_Check(t *testing.T) {
+	g := NewWithT(t)
+
+	hosts, clients, clientFor := testHosts()
+
+	quotas := Quotas{
+		"team-a": {ResourceVCPU: 8, ResourceMemory: 8192, ResourceMicroVMs: 3},
+		"team-b": {ResourceVolumes: 2},
+	}
+
+	enforcer := NewEnforcer(quotas, HostsUsage(hosts, clientFor))
+
+	tt := []struct {
+		name      string
+		spec      *flintlocktypes.MicroVMSpec
+		exceeded  ResourceList
+		errString string
+	}{
+		{
+			name: "within quota",
+			spec: mvmSpec("team-a", 2, 2048, 4),
+		},
+		{
+			name:     "vcpu and memory",
+			spec:     mvmSpec("team-a", 4, 4096, 1),
+			exceeded: ResourceList{ResourceVCPU: 8, ResourceMemory: 8192},
+			errString: "quota exceeded in namespace team-a: " +
+				"memory: requested 4096, used 6144, limited to 8192; vcpu: requested 4, used 6, limited to 8",
+		},
+		{
+			name:      "volumes",
+			spec:      mvmSpec("team-b", 1, 1024, 2),
+			exceeded:  ResourceList{ResourceVolumes: 2},
+			errString: "quota exceeded in namespace team-b: volumes: requested 2, used 1, limited to 2",
+		},
+		{
+			name: "no quota",
+			spec: mvmSpec("team-c", 64, 65536, 10),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := enforcer.Check(context.TODO(), tc.spec)
+
+			if tc.exceeded == nil {
+				g.Expect(err).NotTo(HaveOccurred())
+
+				return
+			}
+
+			g.Expect(err).To(MatchError(ErrQuotaExceeded))
+			g.Expect(err).To(MatchError(tc.errString))
+
+			var exceeded *QuotaExceededError
+			g.Expect(errors.As(err, &exceeded)).To(BeTrue())
+			g.Expect(exceeded.Limit).To(Equal(tc.exceeded))
+		})
+	}
+
+	listed := clients["10.0.0.1:9090"].listed
+	g.Expect(enforcer.Check(context.TODO(), mvmSpec("team-c", 1, 1024, 1))).To(Succeed())
+	g.Expect(clients["10.0.0.1:9090"].listed).To(Equal(listed))
+
+	clients["10.0.0.1:9090"].err = errors.New("unavailable")
+	g.Expect(enforcer.Check(context.TODO(), mvmSpec("team-a", 1, 1024, 1))).To(MatchError(ContainSubstring("getting quota usage")))
+}
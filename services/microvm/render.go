@@ -0,0 +1,215 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v2"
+)
+
+const redacted = "REDACTED"
+
+var (
+	privateKeyPattern = regexp.MustCompile(`(?s)(-----BEGIN [A-Z ]*PRIVATE KEY-----).*?(-----END [A-Z ]*PRIVATE KEY-----)`)
+	secretPattern     = regexp.MustCompile(`(?i)((?:token|password|secret|certificate-?key)["']?\s*[:=]\s*)("[^"\n]*"|'[^'\n]*'|[^\s,}]+)`)
+	bootstrapToken    = regexp.MustCompile(`\b[a-z0-9]{6}\.[a-z0-9]{16}\b`)
+	// encodedContent matches base64 content embedded in a document, such as the data
+	// URLs of Ignition files or cloud-init files with encoding: b64.
+	encodedContent = regexp.MustCompile(`[A-Za-z0-9+/]{16,}={0,2}`)
+)
+
+// RenderedRequest is the request the service would send to flintlock to create the
+// microvm, along with the decoded metadata documents.
+type RenderedRequest struct {
+	// Request is the request which would be sent to flintlock.
+	Request *flintlockv1.CreateMicroVMRequest
+	// Metadata are the decoded metadata documents, keyed by the metadata key
+	// (user-data, vendor-data, meta-data).
+	Metadata map[string]string
+	// Warnings are the violations of Warn policies, which Create would report to
+	// the scope.
+	Warnings []PolicyViolation
+	// QuotaChecked is true if the service has a quota checker.
+	QuotaChecked bool
+	// QuotaError is why Create would reject the microvm when checking the quota of
+	// its namespace, or nil if it wouldn't.
+	QuotaError error
+}
+
+// Render runs the full Create pipeline without calling flintlock. Policy warnings
+// aren't reported to the scope, and a failed quota check is recorded rather than
+// returned. MAC addresses which are generated will differ from those generated by a
+// subsequent Create.
+func (s *Service) Render(ctx context.Context) (*RenderedRequest, error) {
+	input, warnings, err := s.buildCreateRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := &RenderedRequest{
+		Request:      input,
+		Metadata:     map[string]string{},
+		Warnings:     warnings,
+		QuotaChecked: s.quota != nil,
+		QuotaError:   s.checkQuota(ctx, input.Microvm),
+	}
+
+	for k, v := range input.Microvm.Metadata {
+		decoded, err := decodeMetadata(v)
+		if err != nil {
+			return nil, fmt.Errorf("decoding metadata %s: %w", k, err)
+		}
+
+		rendered.Metadata[k] = decoded
+	}
+
+	return rendered, nil
+}
+
+// JSON marshals the rendered request to JSON, replacing the encoded metadata in the
+// microvm spec with the decoded documents, along with the policy warnings and the
+// result of the quota check. If redact is set, private keys, tokens and passwords in
+// the documents are redacted.
+func (r *RenderedRequest) JSON(redact bool) ([]byte, error) {
+	doc, err := r.document(redact)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// YAML marshals the rendered request to YAML, see JSON.
+func (r *RenderedRequest) YAML(redact bool) ([]byte, error) {
+	doc, err := r.document(redact)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(doc)
+}
+
+func (r *RenderedRequest) document(redact bool) (map[string]interface{}, error) {
+	spec, ok := proto.Clone(r.Request.Microvm).(*flintlocktypes.MicroVMSpec)
+	if !ok {
+		return nil, fmt.Errorf("cloning microvm spec") //nolint: goerr113 // there is no err to wrap
+	}
+
+	spec.Metadata = nil
+
+	specJSON, err := protojson.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling microvm spec: %w", err)
+	}
+
+	microvm := map[string]interface{}{}
+	if err := json.Unmarshal(specJSON, &microvm); err != nil {
+		return nil, fmt.Errorf("unmarshalling microvm spec: %w", err)
+	}
+
+	metadata := make(map[string]string, len(r.Metadata))
+	for k, v := range r.Metadata {
+		if redact {
+			v = Redact(v)
+		}
+
+		metadata[k] = v
+	}
+
+	doc := map[string]interface{}{
+		"microvm":  microvm,
+		"metadata": metadata,
+	}
+
+	if len(r.Warnings) > 0 {
+		warnings := make([]string, 0, len(r.Warnings))
+		for _, violation := range r.Warnings {
+			warnings = append(warnings, violation.String())
+		}
+
+		doc["warnings"] = warnings
+	}
+
+	if r.QuotaChecked {
+		quota := map[string]interface{}{"allowed": r.QuotaError == nil}
+		if r.QuotaError != nil {
+			quota["reason"] = r.QuotaError.Error()
+		}
+
+		doc["quota"] = quota
+	}
+
+	return doc, nil
+}
+
+// Redact replaces private keys, bootstrap tokens and the values of keys which look
+// like tokens or passwords in the document. Base64 content embedded in the document,
+// such as Ignition file contents, is decoded and redacted too. Content with secrets
+// is encoded again with them redacted, or replaced with REDACTED if it was gzipped.
+func Redact(doc string) string {
+	doc = encodedContent.ReplaceAllStringFunc(doc, redactEncoded)
+	doc = privateKeyPattern.ReplaceAllString(doc, "$1\n"+redacted+"\n$2")
+	doc = secretPattern.ReplaceAllString(doc, "${1}"+redacted)
+
+	return bootstrapToken.ReplaceAllString(doc, redacted)
+}
+
+// redactEncoded redacts base64 encoded content. Content which isn't text is returned
+// as it is.
+func redactEncoded(encoded string) string {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return encoded
+	}
+
+	gzipped := DetectUserDataFormat(data) == UserDataFormatGzip
+	if gzipped {
+		if data, err = gunzip(data); err != nil {
+			return encoded
+		}
+	}
+
+	if !utf8.Valid(data) {
+		return encoded
+	}
+
+	doc := Redact(string(data))
+	if doc == string(data) {
+		return encoded
+	}
+
+	if gzipped {
+		return redacted
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(doc))
+}
+
+// decodeMetadata decodes a metadata value. Values which aren't base64 encoded are
+// returned as they are.
+func decodeMetadata(value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return value, nil //nolint: nilerr // the value wasn't encoded
+	}
+
+	if DetectUserDataFormat(data) == UserDataFormatGzip {
+		data, err = gunzip(data)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return string(data), nil
+}
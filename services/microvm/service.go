@@ -10,13 +10,13 @@ import (
 
 	flclient "github.com/liquidmetal-dev/controller-pkg/client"
 	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm/validation"
 	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
 	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
-	"github.com/liquidmetal-dev/flintlock/client/cloudinit/instance"
-	"github.com/liquidmetal-dev/flintlock/client/cloudinit/userdata"
 	"github.com/yitsushi/macpot"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/utils/pointer"
 )
 
@@ -39,30 +39,203 @@ type Scope interface {
 	GetRawBootstrapData() (string, error)
 	// GetSSHPublicKeys returns the public keys to be added to the microvm.
 	GetSSHPublicKeys() []microvm.SSHPublicKey
-	// GetLabels returns the labels to apply to the microvm.
+	// GetLabels returns the labels to apply to the microvm, which override the labels
+	// of the spec. They must not use the reserved microvm.LabelPrefix.
 	GetLabels() map[string]string
 }
 
+// QuotaChecker checks a microvm can be created without exceeding the quota of its
+// namespace. It is implemented by quota.Enforcer. It isn't called for a microvm which
+// already exists on the host, such as when a create is retried, as the microvm is
+// already counted in the usage.
+type QuotaChecker interface {
+	// Check returns an error if creating the microvm would exceed the quota. The
+	// spec is the converted microvm, so its namespace is the one produced by the
+	// naming strategy, as used on the hosts.
+	Check(ctx context.Context, spec *flintlocktypes.MicroVMSpec) error
+}
+
 type Service struct {
 	scope Scope
 
 	client flclient.Client
 	hostID string
+
+	vendorData      VendorDataBuilder
+	instanceData    InstanceDataBuilder
+	userData        []UserDataPart
+	bootstrapFormat BootstrapFormat
+
+	metadataLimits   MetadataLimits
+	compressMetadata bool
+
+	converter         *Converter
+	instanceTypes     microvm.InstanceTypeGetter
+	preferredProvider string
+	quota             QuotaChecker
+	policies          *PolicyEngine
+	host              *microvm.Host
+	managedBy         string
+	ownerKind         string
+}
+
+// Option is a func to add an option to the microvm service.
+type Option func(*Service)
+
+// WithVendorDataBuilder replaces the builder used to create the vendor data.
+func WithVendorDataBuilder(b VendorDataBuilder) Option {
+	return func(s *Service) {
+		s.vendorData = b
+	}
+}
+
+// WithVendorDataOptions extends the configured vendor data builder with additional options.
+// The options are applied on top of the builder configured before this option.
+func WithVendorDataOptions(opts ...VendorDataOption) Option {
+	return func(s *Service) {
+		s.vendorData = ExtendVendorDataBuilder(s.vendorData, opts...)
+	}
+}
+
+// WithInstanceDataBuilder replaces the builder used to create the instance metadata.
+func WithInstanceDataBuilder(b InstanceDataBuilder) Option {
+	return func(s *Service) {
+		s.instanceData = b
+	}
+}
+
+// WithInstanceDataOptions extends the configured instance metadata builder with additional
+// options. The options are applied on top of the builder configured before this option.
+func WithInstanceDataOptions(opts ...InstanceDataOption) Option {
+	return func(s *Service) {
+		s.instanceData = ExtendInstanceDataBuilder(s.instanceData, opts...)
+	}
+}
+
+// WithUserData adds documents to layer on top of the bootstrap data returned by the scope.
+// When supplied the user data is sent to the microvm as a multipart document. When the
+// bootstrap format is Ignition the documents must be Ignition configs, which are merged.
+func WithUserData(parts ...UserDataPart) Option {
+	return func(s *Service) {
+		s.userData = append(s.userData, parts...)
+	}
 }
 
-func New(scope Scope, client flclient.Client, hostID string) *Service {
-	return &Service{
-		scope:  scope,
-		client: client,
-		hostID: hostID,
+// WithSpecMutators adds mutators to run after the microvm spec has been converted.
+func WithSpecMutators(mutators ...SpecMutator) Option {
+	return func(s *Service) {
+		s.converter.mutators = append(s.converter.mutators, mutators...)
 	}
 }
 
+// WithInstanceTypes sets where the instance types referred to by microvm specs are
+// looked up, such as a catalogue loaded from a config file.
+func WithInstanceTypes(instanceTypes microvm.InstanceTypeGetter) Option {
+	return func(s *Service) {
+		s.instanceTypes = instanceTypes
+	}
+}
+
+// WithPreferredProvider sets the provider of microvm specs which don't specify one,
+// such as the PreferredProvider of the host.
+func WithPreferredProvider(provider string) Option {
+	return func(s *Service) {
+		s.preferredProvider = provider
+	}
+}
+
+// WithQuota sets the checker run before a microvm is created, which rejects microvms
+// that would exceed the quota of their namespace.
+func WithQuota(quota QuotaChecker) Option {
+	return func(s *Service) {
+		s.quota = quota
+	}
+}
+
+// WithBootstrapFormat sets the format of the bootstrap data for scopes which don't
+// implement BootstrapFormatScope.
+func WithBootstrapFormat(f BootstrapFormat) Option {
+	return func(s *Service) {
+		s.bootstrapFormat = f
+	}
+}
+
+func New(scope Scope, client flclient.Client, hostID string, opts ...Option) *Service {
+	s := &Service{
+		scope:        scope,
+		client:       client,
+		hostID:       hostID,
+		vendorData:   DefaultVendorDataBuilder(),
+		instanceData: DefaultInstanceDataBuilder(),
+		converter:    NewConverter(),
+		managedBy:    DefaultManagedBy,
+		ownerKind:    DefaultOwnerKind,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
 func (s *Service) Create(ctx context.Context) (*flintlocktypes.MicroVM, error) {
-	apiMicroVM := convertToFlintlockAPI(s.scope)
+	input, warnings, err := s.buildCreateRequest(ctx)
+
+	s.reportPolicyWarnings(warnings)
 
-	if err := s.addMetadata(apiMicroVM); err != nil {
-		return nil, fmt.Errorf("adding metadata: %w", err)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkCreateQuota(ctx, input.Microvm); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.CreateMicroVM(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("creating microvm: %w", err)
+	}
+
+	return resp.Microvm, nil
+}
+
+// buildCreateRequest runs the full create pipeline, up to the point of calling flintlock.
+// It returns the violations of Warn policies without reporting them, even if a Deny
+// policy is violated.
+func (s *Service) buildCreateRequest(
+	_ context.Context,
+) (*flintlockv1.CreateMicroVMRequest, []PolicyViolation, error) {
+	spec, err := s.microvmSpec()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if errs := validation.ValidateVMSpec(&spec, field.NewPath("spec")); len(errs) > 0 {
+		return nil, nil, fmt.Errorf("validating microvm spec: %w", errs.ToAggregate())
+	}
+
+	if err := checkReservedLabels(s.scope.GetLabels()); err != nil {
+		return nil, nil, fmt.Errorf("checking scope labels: %w", err)
+	}
+
+	warnings, err := s.checkPolicies(&spec)
+	if err != nil {
+		return nil, warnings, fmt.Errorf("checking policies: %w", err)
+	}
+
+	apiMicroVM, err := s.converter.convert(s.scope, spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("converting microvm spec: %w", err)
+	}
+
+	apiMicroVM.Labels, err = s.addOwnershipLabels(&spec, apiMicroVM.Labels)
+	if err != nil {
+		return nil, nil, fmt.Errorf("adding ownership labels: %w", err)
+	}
+
+	if err := s.addMetadata(apiMicroVM, &spec); err != nil {
+		return nil, nil, fmt.Errorf("adding metadata: %w", err)
 	}
 
 	for i := range apiMicroVM.Interfaces {
@@ -71,23 +244,64 @@ func (s *Service) Create(ctx context.Context) (*flintlocktypes.MicroVM, error) {
 		if iface.GuestMac == nil || *iface.GuestMac == "" {
 			mac, err := macpot.New(macpot.AsLocal(), macpot.AsUnicast())
 			if err != nil {
-				return nil, fmt.Errorf("creating mac address client: %w", err)
+				return nil, nil, fmt.Errorf("creating mac address client: %w", err)
 			}
 
 			iface.GuestMac = pointer.String(mac.ToString())
 		}
 	}
 
-	input := &flintlockv1.CreateMicroVMRequest{
+	return &flintlockv1.CreateMicroVMRequest{
 		Microvm: apiMicroVM,
+	}, warnings, nil
+}
+
+// checkCreateQuota runs checkQuota unless the microvm already exists on the host,
+// such as when a create is retried, as it is then already counted in the usage.
+func (s *Service) checkCreateQuota(ctx context.Context, spec *flintlocktypes.MicroVMSpec) error {
+	if s.quota == nil {
+		return nil
 	}
 
-	resp, err := s.client.CreateMicroVM(ctx, input)
+	existing, err := s.lookup(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("creating microvm: %w", err)
+		return fmt.Errorf("checking quota: looking up microvm: %w", err)
 	}
 
-	return resp.Microvm, nil
+	if existing != nil {
+		return nil
+	}
+
+	return s.checkQuota(ctx, spec)
+}
+
+// checkQuota checks the microvm can be created without exceeding the quota of its
+// namespace, if the service has a quota checker.
+func (s *Service) checkQuota(ctx context.Context, spec *flintlocktypes.MicroVMSpec) error {
+	if s.quota == nil {
+		return nil
+	}
+
+	if err := s.quota.Check(ctx, spec); err != nil {
+		return fmt.Errorf("checking quota: %w", err)
+	}
+
+	return nil
+}
+
+// microvmSpec returns the spec of the scope with its instance type and provider resolved.
+func (s *Service) microvmSpec() (microvm.VMSpec, error) {
+	spec := s.scope.GetMicrovmSpec()
+
+	if spec.Provider == "" {
+		spec.Provider = s.preferredProvider
+	}
+
+	if err := microvm.ResolveInstanceType(&spec, s.instanceTypes); err != nil {
+		return spec, fmt.Errorf("resolving microvm spec: %w", err)
+	}
+
+	return spec, nil
 }
 
 func (s *Service) Get(ctx context.Context) (*flintlocktypes.MicroVM, error) {
@@ -115,48 +329,80 @@ func (s *Service) Close() {
 	s.client.Close()
 }
 
-func (s *Service) addMetadata(apiMicroVM *flintlocktypes.MicroVMSpec) error {
-	bootData, err := s.scope.GetRawBootstrapData()
+// addMetadata adds the user, vendor and instance metadata of the microvm. The spec
+// is the resolved spec the microvm was converted from.
+func (s *Service) addMetadata(apiMicroVM *flintlocktypes.MicroVMSpec, spec *microvm.VMSpec) error {
+	in := &MetadataInput{
+		Scope:    s.scope,
+		HostID:   s.hostID,
+		Spec:     spec,
+		Labels:   apiMicroVM.Labels,
+		Hostname: s.MicroVMName().Hostname,
+	}
+
+	instanceData, err := s.marshalInstanceData(in)
 	if err != nil {
-		return fmt.Errorf("getting user data for microvm: %w", err)
+		return fmt.Errorf("creating instance metadata: %w", err)
 	}
 
-	apiMicroVM.Metadata["user-data"] = bootData
+	if s.getBootstrapFormat() == BootstrapFormatIgnition {
+		ignitionData, err := s.createIgnitionData(instanceData)
+		if err != nil {
+			return fmt.Errorf("creating ignition config for microvm: %w", err)
+		}
 
-	vendorData, err := s.createVendorData()
-	if err != nil {
-		return fmt.Errorf("creating vendor data for microvm: %w", err)
+		apiMicroVM.Metadata["user-data"] = ignitionData
+	} else {
+		bootData, err := s.createUserData()
+		if err != nil {
+			return fmt.Errorf("creating user data for microvm: %w", err)
+		}
+
+		apiMicroVM.Metadata["user-data"] = bootData
+
+		vendorData, err := s.createVendorData(in)
+		if err != nil {
+			return fmt.Errorf("creating vendor data for microvm: %w", err)
+		}
+
+		apiMicroVM.Metadata["vendor-data"] = vendorData
 	}
 
-	apiMicroVM.Metadata["vendor-data"] = vendorData
+	apiMicroVM.Metadata["meta-data"] = base64.StdEncoding.EncodeToString(instanceData)
 
-	instanceData, err := s.createInstanceData()
+	if s.compressMetadata && s.getBootstrapFormat() != BootstrapFormatIgnition {
+		if err := compressMetadata(apiMicroVM.Metadata); err != nil {
+			return fmt.Errorf("compressing metadata: %w", err)
+		}
+	}
+
+	return checkMetadataSize(apiMicroVM.Metadata, s.metadataLimits)
+}
+
+func (s *Service) createUserData() (string, error) {
+	bootData, err := s.scope.GetRawBootstrapData()
 	if err != nil {
-		return fmt.Errorf("creating instance metadata: %w", err)
+		return "", fmt.Errorf("getting bootstrap data: %w", err)
 	}
 
-	apiMicroVM.Metadata["meta-data"] = instanceData
+	if len(s.userData) == 0 {
+		return bootData, nil
+	}
 
-	return nil
-}
+	parts := append([]UserDataPart{{Content: []byte(bootData), Filename: "bootstrap"}}, s.userData...)
 
-func (s *Service) createVendorData() (string, error) {
-	// TODO: remove the boot command temporary fix after image-builder change #89
-	vendorUserdata := &userdata.UserData{
-		HostName:     s.scope.Name(),
-		FinalMessage: "The Liquid Metal booted system is good to go after $UPTIME seconds",
-		BootCommands: []string{
-			"ln -sf /run/systemd/resolve/stub-resolv.conf /etc/resolv.conf",
-		},
+	combined, err := CombineUserData(parts...)
+	if err != nil {
+		return "", fmt.Errorf("combining user data: %w", err)
 	}
 
-	for _, key := range s.scope.GetSSHPublicKeys() {
-		user := userdata.User{
-			Name:              key.User,
-			SSHAuthorizedKeys: key.AuthorizedKeys,
-		}
+	return base64.StdEncoding.EncodeToString(combined), nil
+}
 
-		vendorUserdata.Users = append(vendorUserdata.Users, user)
+func (s *Service) createVendorData(in *MetadataInput) (string, error) {
+	vendorUserdata, err := s.vendorData.Build(in)
+	if err != nil {
+		return "", fmt.Errorf("building vendor data: %w", err)
 	}
 
 	data, err := yaml.Marshal(vendorUserdata)
@@ -169,17 +415,16 @@ func (s *Service) createVendorData() (string, error) {
 	return base64.StdEncoding.EncodeToString(dataWithHeader), nil
 }
 
-func (s *Service) createInstanceData() (string, error) {
-	userMetadata := instance.New(
-		instance.WithLocalHostname(s.scope.Name()),
-		instance.WithPlatform(platformLiquidMetal),
-		instance.WithKeyValue("vm_host", s.hostID),
-	)
+func (s *Service) marshalInstanceData(in *MetadataInput) ([]byte, error) {
+	userMetadata, err := s.instanceData.Build(in)
+	if err != nil {
+		return nil, fmt.Errorf("building instance metadata: %w", err)
+	}
 
 	userMeta, err := yaml.Marshal(userMetadata)
 	if err != nil {
-		return "", fmt.Errorf("unable to marshal metadata: %w", err)
+		return nil, fmt.Errorf("unable to marshal metadata: %w", err)
 	}
 
-	return base64.StdEncoding.EncodeToString(userMeta), nil
+	return userMeta, nil
 }
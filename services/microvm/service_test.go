@@ -0,0 +1,230 @@
+package microvm
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/fakes"
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/quota"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"github.com/liquidmetal-dev/flintlock/client/cloudinit/instance"
+)
+
+func Test_CreateValidatesSpec(t *testing.T) {
+	g := NewWithT(t)
+
+	mScope := new(fakes.FakeScope)
+	mScope.NameReturns("foo")
+	mScope.GetMicrovmSpecReturns(microvm.VMSpec{VCPU: 2, MemoryMb: 2048})
+
+	// The client is nil, so reaching the RPC would panic.
+	svc := New(mScope, nil, "host")
+
+	_, err := svc.Create(context.TODO())
+	g.Expect(err).To(MatchError(ContainSubstring("spec.rootVolume.id: Required value")))
+}
+
+func Test_CreateResolvesInstanceType(t *testing.T) {
+	g := NewWithT(t)
+
+	catalogue, err := microvm.NewInstanceTypeCatalogue(microvm.InstanceType{
+		Name: "small",
+		InstanceTypeSpec: microvm.InstanceTypeSpec{
+			VCPU:   2,
+			Memory: resource.MustParse("4Gi"),
+		},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	mScope := validScope()
+	spec := mScope.GetMicrovmSpec()
+	spec.InstanceType = "small"
+	spec.VCPU = 0
+	spec.MemoryMb = 0
+	mScope.GetMicrovmSpecReturns(spec)
+
+	_, err = New(mScope, nil, "host").Create(context.TODO())
+	g.Expect(err).To(MatchError(microvm.ErrInstanceTypeNotFound))
+
+	rendered, err := New(mScope, nil, "host", WithInstanceTypes(catalogue)).Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rendered.Request.Microvm.Vcpu).To(Equal(int32(2)))
+	g.Expect(rendered.Request.Microvm.MemoryInMb).To(Equal(int32(4096)))
+	g.Expect(rendered.Metadata["meta-data"]).To(ContainSubstring("instance_type: small"))
+
+	spec.VCPU = 4
+	mScope.GetMicrovmSpecReturns(spec)
+
+	rendered, err = New(mScope, nil, "host", WithInstanceTypes(catalogue)).Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rendered.Request.Microvm.Vcpu).To(Equal(int32(4)))
+	g.Expect(rendered.Request.Microvm.MemoryInMb).To(Equal(int32(4096)))
+}
+
+func Test_CreateUsesPreferredProvider(t *testing.T) {
+	g := NewWithT(t)
+
+	mScope := validScope()
+
+	rendered, err := New(mScope, nil, "host", WithPreferredProvider(microvm.ProviderCloudHypervisor)).Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rendered.Request.Microvm.GetProvider()).To(Equal(microvm.ProviderCloudHypervisor))
+
+	spec := mScope.GetMicrovmSpec()
+	spec.Provider = microvm.ProviderFirecracker
+	mScope.GetMicrovmSpecReturns(spec)
+
+	rendered, err = New(mScope, nil, "host", WithPreferredProvider(microvm.ProviderCloudHypervisor)).Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rendered.Request.Microvm.GetProvider()).To(Equal(microvm.ProviderFirecracker))
+}
+
+func Test_CreateChecksQuota(t *testing.T) {
+	g := NewWithT(t)
+
+	usage := func(context.Context) (map[string]quota.ResourceList, error) {
+		return map[string]quota.ResourceList{
+			"bar": {quota.ResourceMicroVMs: 4, quota.ResourceVCPU: 7},
+		}, nil
+	}
+
+	enforcer := quota.NewEnforcer(quota.Quotas{"bar": {quota.ResourceVCPU: 8}}, usage)
+
+	client := &fakeWaitClient{states: states(notFound)}
+
+	// The client can't create microvms, so reaching the RPC would panic.
+	_, err := New(validScope(), client, "host", WithQuota(enforcer)).Create(context.TODO())
+	g.Expect(err).To(MatchError(quota.ErrQuotaExceeded))
+	g.Expect(err).To(MatchError(ContainSubstring("checking quota: quota exceeded in namespace bar: vcpu: requested 2, used 7")))
+
+	var checked *flintlocktypes.MicroVMSpec
+
+	_, err = New(validScope(), client, "host", WithQuota(quotaFunc(func(_ context.Context, spec *flintlocktypes.MicroVMSpec) error {
+		checked = spec
+
+		return context.Canceled
+	}))).Create(context.TODO())
+	g.Expect(err).To(MatchError(context.Canceled))
+	g.Expect(checked.Id).To(Equal("foo"))
+	g.Expect(checked.Metadata).To(HaveKey("meta-data"))
+}
+
+func Test_CreateChecksQuotaOfNamingStrategyNamespace(t *testing.T) {
+	g := NewWithT(t)
+
+	usage := func(context.Context) (map[string]quota.ResourceList, error) {
+		return map[string]quota.ResourceList{
+			"mgmt-bar": {quota.ResourceMicroVMs: 2},
+		}, nil
+	}
+
+	quotas := quota.Quotas{
+		"bar":      {quota.ResourceMicroVMs: 10},
+		"mgmt-bar": {quota.ResourceMicroVMs: 2},
+	}
+
+	// The client can't create microvms, so reaching the RPC would panic.
+	_, err := New(validScope(), &fakeWaitClient{states: states(notFound)}, "host",
+		WithNamingStrategy(ClusterPrefixedNaming("mgmt")),
+		WithQuota(quota.NewEnforcer(quotas, usage)),
+	).Create(context.TODO())
+	g.Expect(err).To(MatchError(ContainSubstring("quota exceeded in namespace mgmt-bar: microvms: requested 1, used 2")))
+}
+
+type createClient struct {
+	*fakeWaitClient
+}
+
+func (createClient) CreateMicroVM(
+	_ context.Context, req *flintlockv1.CreateMicroVMRequest, _ ...grpc.CallOption,
+) (*flintlockv1.CreateMicroVMResponse, error) {
+	return &flintlockv1.CreateMicroVMResponse{Microvm: &flintlocktypes.MicroVM{Spec: req.Microvm}}, nil
+}
+
+func Test_CreateSkipsQuotaOfExistingMicroVM(t *testing.T) {
+	g := NewWithT(t)
+
+	checked := false
+	enforcer := quotaFunc(func(context.Context, *flintlocktypes.MicroVMSpec) error {
+		checked = true
+
+		return quota.ErrQuotaExceeded
+	})
+
+	client := createClient{&fakeWaitClient{states: states(flintlocktypes.MicroVMStatus_CREATED)}}
+
+	mvm, err := New(validScope(), client, "host", WithQuota(enforcer)).Create(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(mvm.Spec.Id).To(Equal("foo"))
+	g.Expect(checked).To(BeFalse())
+
+	client.states = states(notFound)
+
+	_, err = New(validScope(), client, "host", WithQuota(enforcer)).Create(context.TODO())
+	g.Expect(err).To(MatchError(quota.ErrQuotaExceeded))
+	g.Expect(checked).To(BeTrue())
+}
+
+type quotaFunc func(ctx context.Context, spec *flintlocktypes.MicroVMSpec) error
+
+func (f quotaFunc) Check(ctx context.Context, spec *flintlocktypes.MicroVMSpec) error {
+	return f(ctx, spec)
+}
+
+func Test_CreateInstanceDataMatchesMicroVM(t *testing.T) {
+	g := NewWithT(t)
+
+	mScope := validScope()
+	spec := mScope.GetMicrovmSpec()
+	spec.Labels = map[string]string{"app": "foo", "team": "a"}
+	mScope.GetMicrovmSpecReturns(spec)
+	mScope.GetLabelsReturns(map[string]string{"team": "b"})
+
+	rendered, err := New(mScope, nil, "host").Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	data := map[string]interface{}{}
+	g.Expect(yaml.Unmarshal([]byte(rendered.Metadata["meta-data"]), &data)).To(Succeed())
+
+	tags := map[string]string{}
+	for k, v := range data[TagsKey].(map[interface{}]interface{}) {
+		tags[k.(string)] = v.(string)
+	}
+
+	g.Expect(tags).To(Equal(rendered.Request.Microvm.Labels))
+	g.Expect(tags).To(HaveKeyWithValue("team", "b"))
+	g.Expect(tags).To(HaveKeyWithValue(microvm.ManagedByLabel, DefaultManagedBy))
+	g.Expect(data).To(HaveKeyWithValue(InstanceTypeKey, "2vcpu-2048mib"))
+}
+
+func Test_CreateKeepsCustomBuilderData(t *testing.T) {
+	g := NewWithT(t)
+
+	rendered, err := New(validScope(), nil, "host",
+		WithInstanceDataBuilder(NewInstanceDataBuilder(
+			WithInstanceDataKey(instance.LocalHostnameKey, "custom"),
+			WithInstanceDataKey(InstanceTypeKey, "small"),
+			WithInstanceDataKey(TagsKey, map[string]string{"team": "a"}),
+		)),
+		WithVendorDataBuilder(NewVendorDataBuilder(func(_ *MetadataInput, data *VendorData) error {
+			data.HostName = "custom"
+
+			return nil
+		})),
+	).Render(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	data := map[string]interface{}{}
+	g.Expect(yaml.Unmarshal([]byte(rendered.Metadata["meta-data"]), &data)).To(Succeed())
+	g.Expect(data).To(HaveKeyWithValue(instance.LocalHostnameKey, "custom"))
+	g.Expect(data).To(HaveKeyWithValue(InstanceTypeKey, "small"))
+	g.Expect(data).To(HaveKeyWithValue(TagsKey, HaveLen(1)))
+	g.Expect(rendered.Metadata["vendor-data"]).To(ContainSubstring("hostname: custom"))
+}
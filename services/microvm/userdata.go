@@ -0,0 +1,280 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// UserDataFormat is the format of a user data document.
+type UserDataFormat string
+
+const (
+	// UserDataFormatCloudConfig is a #cloud-config document.
+	UserDataFormatCloudConfig = UserDataFormat("cloud-config")
+	// UserDataFormatCloudConfigArchive is a #cloud-config-archive document.
+	UserDataFormatCloudConfigArchive = UserDataFormat("cloud-config-archive")
+	// UserDataFormatShellScript is a script starting with #!.
+	UserDataFormatShellScript = UserDataFormat("shell-script")
+	// UserDataFormatGzip is a gzip compressed document.
+	UserDataFormatGzip = UserDataFormat("gzip")
+	// UserDataFormatBase64 is a base64 encoded document.
+	UserDataFormatBase64 = UserDataFormat("base64")
+	// UserDataFormatMultipart is a MIME multipart document.
+	UserDataFormatMultipart = UserDataFormat("multipart")
+	// UserDataFormatUnknown is a document in a format which isn't supported.
+	UserDataFormatUnknown = UserDataFormat("unknown")
+)
+
+const (
+	// DefaultMergeType is the cloud-init merge type used for cloud-config parts which
+	// don't specify one. Lists are appended and existing keys are kept so that later
+	// parts add to, rather than replace, the bootstrap data.
+	DefaultMergeType = "list(append)+dict(no_replace,recurse_list)+str()"
+
+	cloudConfigArchiveHeader = "#cloud-config-archive"
+	mergeTypeHeader          = "Merge-Type"
+	maxDecodeDepth           = 5
+)
+
+var (
+	errUnknownUserDataFormat = errors.New("unknown user data format")
+	errUserDataTooDeep       = errors.New("user data is nested too deeply")
+
+	userDataContentTypes = map[UserDataFormat]string{
+		UserDataFormatCloudConfig:        "text/cloud-config",
+		UserDataFormatCloudConfigArchive: "text/cloud-config-archive",
+		UserDataFormatShellScript:        "text/x-shellscript",
+	}
+)
+
+// UserDataPart is a single document to combine into the user data of a microvm.
+type UserDataPart struct {
+	// Content is the document in any of the supported formats.
+	Content []byte
+	// Filename is an optional name for the part.
+	Filename string
+	// MergeType is the cloud-init merge type for cloud-config parts. If not
+	// supplied DefaultMergeType is used.
+	MergeType string
+}
+
+// DetectUserDataFormat returns the format of the user data document.
+func DetectUserDataFormat(data []byte) UserDataFormat {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return UserDataFormatGzip
+	case bytes.HasPrefix(data, []byte(cloudConfigArchiveHeader)):
+		return UserDataFormatCloudConfigArchive
+	case bytes.HasPrefix(data, []byte(cloudInitHeader[:len(cloudInitHeader)-1])):
+		return UserDataFormatCloudConfig
+	case bytes.HasPrefix(data, []byte("#!")):
+		return UserDataFormatShellScript
+	case isMultipart(data):
+		return UserDataFormatMultipart
+	case isBase64(data):
+		return UserDataFormatBase64
+	}
+
+	return UserDataFormatUnknown
+}
+
+// CombineUserData combines the parts into a single multipart/mixed document. Parts
+// which are encoded, compressed or are themselves multipart documents are unpacked
+// first, so the result only contains cloud-config, cloud-config-archive and shell
+// script documents. Empty parts, such as a scope without bootstrap data, are skipped.
+func CombineUserData(parts ...UserDataPart) ([]byte, error) {
+	docs := []UserDataPart{}
+
+	for i, part := range parts {
+		unpacked, err := unpackUserData(part, 0)
+		if err != nil {
+			return nil, fmt.Errorf("unpacking user data part %d: %w", i, err)
+		}
+
+		docs = append(docs, unpacked...)
+	}
+
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=%q\n", writer.Boundary())
+	fmt.Fprint(buf, "MIME-Version: 1.0\n\n")
+
+	for i, doc := range docs {
+		format := DetectUserDataFormat(doc.Content)
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", userDataContentTypes[format]+`; charset="utf-8"`)
+
+		filename := doc.Filename
+		if filename == "" {
+			filename = fmt.Sprintf("part-%03d", i)
+		}
+
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+		if format == UserDataFormatCloudConfig {
+			mergeType := doc.MergeType
+			if mergeType == "" {
+				mergeType = DefaultMergeType
+			}
+
+			header.Set(mergeTypeHeader, mergeType)
+		}
+
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("creating user data part %s: %w", filename, err)
+		}
+
+		if _, err := w.Write(doc.Content); err != nil {
+			return nil, fmt.Errorf("writing user data part %s: %w", filename, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("closing multipart user data: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func unpackUserData(part UserDataPart, depth int) ([]UserDataPart, error) {
+	if depth > maxDecodeDepth {
+		return nil, errUserDataTooDeep
+	}
+
+	if len(bytes.TrimSpace(part.Content)) == 0 {
+		return nil, nil
+	}
+
+	switch DetectUserDataFormat(part.Content) {
+	case UserDataFormatCloudConfig, UserDataFormatCloudConfigArchive, UserDataFormatShellScript:
+		return []UserDataPart{part}, nil
+	case UserDataFormatBase64:
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(part.Content)))
+		if err != nil {
+			return nil, fmt.Errorf("decoding base64 user data: %w", err)
+		}
+
+		part.Content = decoded
+
+		return unpackUserData(part, depth+1)
+	case UserDataFormatGzip:
+		decompressed, err := gunzip(part.Content)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing user data: %w", err)
+		}
+
+		part.Content = decompressed
+
+		return unpackUserData(part, depth+1)
+	case UserDataFormatMultipart:
+		return unpackMultipart(part.Content, depth)
+	case UserDataFormatUnknown:
+	}
+
+	return nil, errUnknownUserDataFormat
+}
+
+func unpackMultipart(data []byte, depth int) ([]UserDataPart, error) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("reading multipart header: %w", err)
+	}
+
+	_, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing multipart content type: %w", err)
+	}
+
+	mr := multipart.NewReader(reader.R, params["boundary"])
+	parts := []UserDataPart{}
+
+	for {
+		p, err := mr.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("reading multipart part: %w", err)
+		}
+
+		content, err := io.ReadAll(p)
+		if err != nil {
+			return nil, fmt.Errorf("reading multipart part: %w", err)
+		}
+
+		if strings.EqualFold(p.Header.Get("Content-Transfer-Encoding"), "base64") {
+			content, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(content)))
+			if err != nil {
+				return nil, fmt.Errorf("decoding multipart part: %w", err)
+			}
+		}
+
+		unpacked, err := unpackUserData(UserDataPart{
+			Content:   content,
+			Filename:  p.FileName(),
+			MergeType: p.Header.Get(mergeTypeHeader),
+		}, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, unpacked...)
+	}
+
+	return parts, nil
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func isMultipart(data []byte) bool {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return false
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != ""
+}
+
+func isBase64(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return false
+	}
+
+	_, err := base64.StdEncoding.DecodeString(string(trimmed))
+
+	return err == nil
+}
@@ -0,0 +1,195 @@
+package microvm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+const (
+	testCloudConfig = "#cloud-config\nruncmd:\n- echo hello\n"
+	testShellScript = "#!/bin/sh\necho hello\n"
+	testArchive     = "#cloud-config-archive\n- type: text/x-shellscript\n  content: echo hello\n"
+)
+
+func Test_DetectUserDataFormat(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name     string
+		input    []byte
+		expected UserDataFormat
+	}{
+		{
+			name:     "cloud-config",
+			input:    []byte(testCloudConfig),
+			expected: UserDataFormatCloudConfig,
+		},
+		{
+			name:     "cloud-config-archive",
+			input:    []byte(testArchive),
+			expected: UserDataFormatCloudConfigArchive,
+		},
+		{
+			name:     "shell script",
+			input:    []byte(testShellScript),
+			expected: UserDataFormatShellScript,
+		},
+		{
+			name:     "gzip",
+			input:    gzipped(g, testCloudConfig),
+			expected: UserDataFormatGzip,
+		},
+		{
+			name:     "base64",
+			input:    []byte(base64.StdEncoding.EncodeToString([]byte(testCloudConfig))),
+			expected: UserDataFormatBase64,
+		},
+		{
+			name:     "multipart",
+			input:    combined(g, UserDataPart{Content: []byte(testCloudConfig)}),
+			expected: UserDataFormatMultipart,
+		},
+		{
+			name:     "unknown",
+			input:    []byte("hello world"),
+			expected: UserDataFormatUnknown,
+		},
+		{
+			name:     "empty",
+			input:    []byte{},
+			expected: UserDataFormatUnknown,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			g.Expect(DetectUserDataFormat(tc.input)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func Test_CombineUserData(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name     string
+		input    []UserDataPart
+		expected []string
+	}{
+		{
+			name: "base64 bootstrap with a script",
+			input: []UserDataPart{
+				{Content: []byte(base64.StdEncoding.EncodeToString([]byte(testCloudConfig)))},
+				{Content: []byte(testShellScript)},
+			},
+			expected: []string{testCloudConfig, testShellScript},
+		},
+		{
+			name: "gzip compressed part",
+			input: []UserDataPart{
+				{Content: gzipped(g, testShellScript)},
+			},
+			expected: []string{testShellScript},
+		},
+		{
+			name: "existing multipart is flattened",
+			input: []UserDataPart{
+				{Content: combined(g, UserDataPart{Content: []byte(testCloudConfig)}, UserDataPart{Content: []byte(testShellScript)})},
+				{Content: []byte(testCloudConfig)},
+			},
+			expected: []string{testCloudConfig, testShellScript, testCloudConfig},
+		},
+		{
+			name: "empty bootstrap is skipped",
+			input: []UserDataPart{
+				{Content: []byte{}},
+				{Content: []byte(testShellScript)},
+			},
+			expected: []string{testShellScript},
+		},
+		{
+			name: "cloud-config-archive",
+			input: []UserDataPart{
+				{Content: []byte(testArchive)},
+			},
+			expected: []string{testArchive},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := CombineUserData(tc.input...)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			msg, err := mail.ReadMessage(bytes.NewReader(data))
+			g.Expect(err).NotTo(HaveOccurred())
+
+			mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(mediaType).To(Equal("multipart/mixed"))
+
+			reader := multipart.NewReader(msg.Body, params["boundary"])
+			contents := []string{}
+
+			for {
+				part, err := reader.NextPart()
+				if err == io.EOF {
+					break
+				}
+				g.Expect(err).NotTo(HaveOccurred())
+
+				content, err := io.ReadAll(part)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				switch DetectUserDataFormat(content) {
+				case UserDataFormatCloudConfig:
+					g.Expect(part.Header.Get(mergeTypeHeader)).To(Equal(DefaultMergeType))
+					g.Expect(part.Header.Get("Content-Type")).To(HavePrefix("text/cloud-config;"))
+				case UserDataFormatCloudConfigArchive:
+					g.Expect(part.Header.Get(mergeTypeHeader)).To(BeEmpty())
+					g.Expect(part.Header.Get("Content-Type")).To(HavePrefix("text/cloud-config-archive"))
+				default:
+					g.Expect(part.Header.Get(mergeTypeHeader)).To(BeEmpty())
+					g.Expect(part.Header.Get("Content-Type")).To(HavePrefix("text/x-shellscript"))
+				}
+
+				contents = append(contents, string(content))
+			}
+
+			g.Expect(contents).To(Equal(tc.expected))
+		})
+	}
+}
+
+func Test_CombineUserData_Unknown(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := CombineUserData(UserDataPart{Content: []byte("hello world")})
+	g.Expect(err).To(MatchError(errUnknownUserDataFormat))
+}
+
+func gzipped(g *WithT, data string) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+
+	_, err := w.Write([]byte(data))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(w.Close()).To(Succeed())
+
+	return buf.Bytes()
+}
+
+func combined(g *WithT, parts ...UserDataPart) []byte {
+	data, err := CombineUserData(parts...)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	return data
+}
@@ -0,0 +1,268 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/liquidmetal-dev/flintlock/client/cloudinit/userdata"
+)
+
+const (
+	defaultFinalMessage = "The Liquid Metal booted system is good to go after $UPTIME seconds"
+
+	proxyProfilePath  = "/etc/profile.d/proxy.sh"
+	proxySystemdPath  = "/etc/systemd/system.conf.d/proxy.conf"
+	defaultPermission = "0644"
+)
+
+// VendorData is the cloud-init vendor data supplied to a microvm. It extends the
+// flintlock userdata with the extra cloud-config modules images commonly need.
+type VendorData struct {
+	userdata.UserData `yaml:",inline"`
+
+	// CACerts are additional CA certificates to trust in the guest.
+	CACerts *CACerts `yaml:"ca_certs,omitempty"`
+	// NTP configures the time servers used by the guest.
+	NTP *NTP `yaml:"ntp,omitempty"`
+	// Timezone is the timezone to set in the guest.
+	Timezone string `yaml:"timezone,omitempty"`
+}
+
+// CACerts represents the cloud-init ca_certs module configuration.
+type CACerts struct {
+	RemoveDefaults bool     `yaml:"remove_defaults,omitempty"`
+	Trusted        []string `yaml:"trusted,omitempty"`
+}
+
+// NTP represents the cloud-init ntp module configuration.
+type NTP struct {
+	Enabled bool     `yaml:"enabled"`
+	Servers []string `yaml:"servers,omitempty"`
+	Pools   []string `yaml:"pools,omitempty"`
+}
+
+// VendorDataBuilder builds the cloud-init vendor data for a microvm.
+type VendorDataBuilder interface {
+	// Build returns the vendor data for the microvm described by the input.
+	Build(in *MetadataInput) (*VendorData, error)
+}
+
+// VendorDataOption is a func to add content to the vendor data of a microvm.
+type VendorDataOption func(in *MetadataInput, data *VendorData) error
+
+type vendorDataBuilder struct {
+	base VendorDataBuilder
+	opts []VendorDataOption
+}
+
+// NewVendorDataBuilder returns a VendorDataBuilder which applies the given
+// options, in order, to an empty vendor data document.
+func NewVendorDataBuilder(opts ...VendorDataOption) VendorDataBuilder {
+	return &vendorDataBuilder{opts: opts}
+}
+
+// ExtendVendorDataBuilder returns a VendorDataBuilder which applies the given
+// options on top of the vendor data produced by base.
+func ExtendVendorDataBuilder(base VendorDataBuilder, opts ...VendorDataOption) VendorDataBuilder {
+	return &vendorDataBuilder{base: base, opts: opts}
+}
+
+// DefaultVendorDataBuilder returns the builder used by the service when no other
+// builder is configured.
+func DefaultVendorDataBuilder() VendorDataBuilder {
+	return NewVendorDataBuilder(DefaultVendorDataOptions()...)
+}
+
+// DefaultVendorDataOptions returns the options used by DefaultVendorDataBuilder. They
+// can be used as the starting point for an OS specific builder.
+func DefaultVendorDataOptions() []VendorDataOption {
+	return []VendorDataOption{
+		WithHostname(),
+		WithSSHUsers(),
+		WithFinalMessage(defaultFinalMessage),
+		WithSystemdResolvedStub(),
+	}
+}
+
+// Build fullfills the VendorDataBuilder interface.
+func (b *vendorDataBuilder) Build(in *MetadataInput) (*VendorData, error) {
+	data := &VendorData{}
+
+	if b.base != nil {
+		var err error
+
+		data, err = b.base.Build(in)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, opt := range b.opts {
+		if err := opt(in, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// WithHostname sets the hostname of the guest.
+func WithHostname() VendorDataOption {
+	return func(in *MetadataInput, data *VendorData) error {
+		data.HostName = in.Hostname
+
+		return nil
+	}
+}
+
+// WithSSHUsers adds a user for each of the public keys returned by the scope.
+func WithSSHUsers() VendorDataOption {
+	return func(in *MetadataInput, data *VendorData) error {
+		for _, key := range in.Scope.GetSSHPublicKeys() {
+			user := userdata.User{
+				Name:              key.User,
+				SSHAuthorizedKeys: key.AuthorizedKeys,
+			}
+
+			data.Users = append(data.Users, user)
+		}
+
+		return nil
+	}
+}
+
+// WithFinalMessage sets the message cloud-init logs when it has finished.
+func WithFinalMessage(msg string) VendorDataOption {
+	return func(_ *MetadataInput, data *VendorData) error {
+		data.FinalMessage = msg
+
+		return nil
+	}
+}
+
+// WithBootCommands adds commands to run early in the boot process.
+func WithBootCommands(cmds ...string) VendorDataOption {
+	return func(_ *MetadataInput, data *VendorData) error {
+		data.BootCommands = append(data.BootCommands, cmds...)
+
+		return nil
+	}
+}
+
+// WithRunCommands adds commands to run late in the boot process.
+func WithRunCommands(cmds ...string) VendorDataOption {
+	return func(_ *MetadataInput, data *VendorData) error {
+		data.RunCommands = append(data.RunCommands, cmds...)
+
+		return nil
+	}
+}
+
+// WithWriteFiles adds files to write to the guest.
+func WithWriteFiles(files ...userdata.WriteFile) VendorDataOption {
+	return func(_ *MetadataInput, data *VendorData) error {
+		data.WriteFiles = append(data.WriteFiles, files...)
+
+		return nil
+	}
+}
+
+// WithSystemdResolvedStub links resolv.conf to the systemd-resolved stub resolver.
+// It is only needed by images which use systemd-resolved.
+func WithSystemdResolvedStub() VendorDataOption {
+	// TODO: remove the boot command temporary fix after image-builder change #89
+	return WithBootCommands("ln -sf /run/systemd/resolve/stub-resolv.conf /etc/resolv.conf")
+}
+
+// WithCACerts adds PEM encoded CA certificates to the trusted certificates of the guest.
+func WithCACerts(certs ...string) VendorDataOption {
+	return func(_ *MetadataInput, data *VendorData) error {
+		if data.CACerts == nil {
+			data.CACerts = &CACerts{}
+		}
+
+		data.CACerts.Trusted = append(data.CACerts.Trusted, certs...)
+
+		return nil
+	}
+}
+
+// WithNTPServers enables NTP in the guest using the given servers.
+func WithNTPServers(servers ...string) VendorDataOption {
+	return func(_ *MetadataInput, data *VendorData) error {
+		if data.NTP == nil {
+			data.NTP = &NTP{}
+		}
+
+		data.NTP.Enabled = true
+		data.NTP.Servers = append(data.NTP.Servers, servers...)
+
+		return nil
+	}
+}
+
+// WithTimezone sets the timezone of the guest.
+func WithTimezone(tz string) VendorDataOption {
+	return func(_ *MetadataInput, data *VendorData) error {
+		data.Timezone = tz
+
+		return nil
+	}
+}
+
+// WithProxyEnvironment configures the proxy environment variables for login shells
+// and for services started by systemd.
+func WithProxyEnvironment(httpProxy, httpsProxy, noProxy string) VendorDataOption {
+	return func(_ *MetadataInput, data *VendorData) error {
+		env := proxyEnv(httpProxy, httpsProxy, noProxy)
+		if len(env) == 0 {
+			return fmt.Errorf("no proxy configuration supplied") //nolint: goerr113 // there is no err to wrap
+		}
+
+		profile := &strings.Builder{}
+		systemd := &strings.Builder{}
+
+		systemd.WriteString("[Manager]\n")
+
+		for _, kv := range env {
+			fmt.Fprintf(profile, "export %s=%q\n", kv[0], kv[1])
+			fmt.Fprintf(systemd, "DefaultEnvironment=\"%s=%s\"\n", kv[0], kv[1])
+		}
+
+		data.WriteFiles = append(data.WriteFiles,
+			userdata.WriteFile{
+				Path:        proxyProfilePath,
+				Content:     profile.String(),
+				Permissions: defaultPermission,
+			},
+			userdata.WriteFile{
+				Path:        proxySystemdPath,
+				Content:     systemd.String(),
+				Permissions: defaultPermission,
+			},
+		)
+
+		return nil
+	}
+}
+
+func proxyEnv(httpProxy, httpsProxy, noProxy string) [][2]string {
+	env := [][2]string{}
+
+	for _, kv := range [][2]string{
+		{"HTTP_PROXY", httpProxy},
+		{"HTTPS_PROXY", httpsProxy},
+		{"NO_PROXY", noProxy},
+	} {
+		if kv[1] == "" {
+			continue
+		}
+
+		env = append(env, kv, [2]string{strings.ToLower(kv[0]), kv[1]})
+	}
+
+	return env
+}
@@ -0,0 +1,103 @@
+package microvm
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+
+	"github.com/liquidmetal-dev/controller-pkg/services/microvm/fakes"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	"github.com/liquidmetal-dev/flintlock/client/cloudinit/userdata"
+)
+
+func Test_VendorDataBuilder(t *testing.T) {
+	g := NewWithT(t)
+
+	var (
+		machineName = "foo"
+		user        = "ubuntu"
+		key         = "ssh-ed25519 AAAA"
+		cert        = "-----BEGIN CERTIFICATE-----"
+	)
+
+	tt := []struct {
+		name     string
+		builder  VendorDataBuilder
+		expected func(*WithT, *VendorData)
+	}{
+		{
+			name:    "default builder",
+			builder: DefaultVendorDataBuilder(),
+			expected: func(g *WithT, data *VendorData) {
+				g.Expect(data.HostName).To(Equal(machineName))
+				g.Expect(data.FinalMessage).To(Equal(defaultFinalMessage))
+				g.Expect(data.BootCommands).To(HaveLen(1))
+				g.Expect(data.Users).To(ConsistOf(userdata.User{Name: user, SSHAuthorizedKeys: []string{key}}))
+			},
+		},
+		{
+			name:    "empty builder",
+			builder: NewVendorDataBuilder(),
+			expected: func(g *WithT, data *VendorData) {
+				g.Expect(*data).To(BeZero())
+			},
+		},
+		{
+			name: "extended builder",
+			builder: ExtendVendorDataBuilder(NewVendorDataBuilder(WithHostname()),
+				WithCACerts(cert),
+				WithNTPServers("ntp1", "ntp2"),
+				WithTimezone("Europe/London"),
+				WithRunCommands("echo hi"),
+			),
+			expected: func(g *WithT, data *VendorData) {
+				g.Expect(data.HostName).To(Equal(machineName))
+				g.Expect(data.BootCommands).To(BeEmpty())
+				g.Expect(data.CACerts.Trusted).To(ConsistOf(cert))
+				g.Expect(data.NTP.Enabled).To(BeTrue())
+				g.Expect(data.NTP.Servers).To(ConsistOf("ntp1", "ntp2"))
+				g.Expect(data.Timezone).To(Equal("Europe/London"))
+				g.Expect(data.RunCommands).To(ConsistOf("echo hi"))
+			},
+		},
+		{
+			name:    "proxy environment",
+			builder: NewVendorDataBuilder(WithProxyEnvironment("http://proxy:3128", "", "10.0.0.0/8")),
+			expected: func(g *WithT, data *VendorData) {
+				g.Expect(data.WriteFiles).To(HaveLen(2))
+				g.Expect(data.WriteFiles[0].Path).To(Equal(proxyProfilePath))
+				g.Expect(data.WriteFiles[0].Content).To(ContainSubstring(`export HTTP_PROXY="http://proxy:3128"`))
+				g.Expect(data.WriteFiles[0].Content).To(ContainSubstring(`export no_proxy="10.0.0.0/8"`))
+				g.Expect(data.WriteFiles[0].Content).NotTo(ContainSubstring("HTTPS_PROXY"))
+				g.Expect(data.WriteFiles[1].Path).To(Equal(proxySystemdPath))
+				g.Expect(data.WriteFiles[1].Content).To(ContainSubstring(`DefaultEnvironment="NO_PROXY=10.0.0.0/8"`))
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mScope := new(fakes.FakeScope)
+			mScope.GetSSHPublicKeysReturns([]microvm.SSHPublicKey{{User: user, AuthorizedKeys: []string{key}}})
+
+			data, err := tc.builder.Build(&MetadataInput{Scope: mScope, Hostname: machineName})
+			g.Expect(err).NotTo(HaveOccurred())
+
+			tc.expected(g, data)
+		})
+	}
+}
+
+func Test_VendorDataMarshal(t *testing.T) {
+	g := NewWithT(t)
+
+	data := &VendorData{
+		UserData: userdata.UserData{HostName: "foo"},
+		Timezone: "UTC",
+	}
+
+	out, err := yaml.Marshal(data)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(out)).To(Equal("hostname: foo\ntimezone: UTC\n"))
+}
@@ -0,0 +1,256 @@
+// Copyright 2023 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package microvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultWaitInterval is the initial delay between checks of the microvm state.
+	DefaultWaitInterval = time.Second
+	// DefaultMaxWaitInterval is the longest delay between checks of the microvm state.
+	DefaultMaxWaitInterval = 15 * time.Second
+	// MinWaitInterval is the shortest delay between checks of the microvm state.
+	MinWaitInterval = 10 * time.Millisecond
+)
+
+var (
+	// ErrMicroVMFailed is returned when waiting for a state of a microvm which failed.
+	ErrMicroVMFailed = errors.New("microvm failed")
+	// ErrMicroVMNotFound is returned when waiting for a state of a microvm which
+	// doesn't exist.
+	ErrMicroVMNotFound = errors.New("microvm not found")
+)
+
+// MicroVMFailedError is returned by WaitForState when the microvm fails. Flintlock
+// doesn't report why, so the reason is the number of times it retried.
+type MicroVMFailedError struct {
+	// MicroVM is the failed microvm.
+	MicroVM *flintlocktypes.MicroVM
+}
+
+func (e *MicroVMFailedError) Error() string {
+	spec := e.MicroVM.GetSpec()
+
+	return fmt.Sprintf("%s: %s/%s after %d retries",
+		ErrMicroVMFailed, spec.GetNamespace(), spec.GetId(), e.MicroVM.GetStatus().GetRetry())
+}
+
+// Is returns true for ErrMicroVMFailed.
+func (e *MicroVMFailedError) Is(target error) bool {
+	return target == ErrMicroVMFailed
+}
+
+// WaitOption is a func to configure WaitForState and WaitForDeletion.
+type WaitOption func(*waitConfig)
+
+type waitConfig struct {
+	interval    time.Duration
+	maxInterval time.Duration
+}
+
+// WithWaitInterval sets the initial delay between checks of the microvm state, which
+// doubles after each check up to maxInterval. Delays shorter than MinWaitInterval are
+// raised to it, so flintlock isn't polled in a tight loop.
+func WithWaitInterval(interval, maxInterval time.Duration) WaitOption {
+	return func(c *waitConfig) {
+		c.interval = interval
+		c.maxInterval = maxInterval
+	}
+}
+
+// VMStateFor returns the state of the flintlock microvm. A microvm being deleted is
+// in VMStateUnknown.
+func VMStateFor(mvm *flintlocktypes.MicroVM) microvm.VMState {
+	switch mvm.GetStatus().GetState() {
+	case flintlocktypes.MicroVMStatus_PENDING:
+		return microvm.VMStatePending
+	case flintlocktypes.MicroVMStatus_CREATED:
+		return microvm.VMStateRunning
+	case flintlocktypes.MicroVMStatus_FAILED:
+		return microvm.VMStateFailed
+	default:
+		return microvm.VMStateUnknown
+	}
+}
+
+// WaitForState polls the microvm, backing off between checks, until it reaches the
+// state or the context is done. It returns a *MicroVMFailedError as soon as the
+// microvm fails, unless waiting for VMStateFailed, and ErrMicroVMNotFound if the
+// microvm doesn't exist. Waiting for VMStateDeleted is the same as WaitForDeletion.
+func (s *Service) WaitForState(
+	ctx context.Context,
+	state microvm.VMState,
+	opts ...WaitOption,
+) (*flintlocktypes.MicroVM, error) {
+	if state == microvm.VMStateDeleted {
+		return nil, s.WaitForDeletion(ctx, opts...)
+	}
+
+	return waitForState(ctx, s.lookup, state, opts)
+}
+
+// WaitForMicroVMState is WaitForState for the microvm with the uid, such as one
+// which wasn't created by a service.
+func WaitForMicroVMState(
+	ctx context.Context,
+	client flintlockv1.MicroVMClient,
+	uid string,
+	state microvm.VMState,
+	opts ...WaitOption,
+) (*flintlocktypes.MicroVM, error) {
+	lookup := func(ctx context.Context) (*flintlocktypes.MicroVM, error) {
+		return getByUID(ctx, client, uid)
+	}
+
+	if state == microvm.VMStateDeleted {
+		return nil, waitForDeletion(ctx, lookup, opts)
+	}
+
+	return waitForState(ctx, lookup, state, opts)
+}
+
+func waitForState(
+	ctx context.Context,
+	lookup func(context.Context) (*flintlocktypes.MicroVM, error),
+	state microvm.VMState,
+	opts []WaitOption,
+) (*flintlocktypes.MicroVM, error) {
+	var current *flintlocktypes.MicroVM
+
+	err := poll(ctx, opts, func() (bool, error) {
+		mvm, err := lookup(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		if mvm == nil {
+			return false, ErrMicroVMNotFound
+		}
+
+		current = mvm
+
+		switch VMStateFor(mvm) {
+		case state:
+			return true, nil
+		case microvm.VMStateFailed:
+			return false, &MicroVMFailedError{MicroVM: mvm}
+		default:
+			return false, nil
+		}
+	})
+	if err != nil {
+		return current, fmt.Errorf("waiting for microvm to be %s: %w", state, err)
+	}
+
+	return current, nil
+}
+
+// WaitForDeletion polls the microvm, backing off between checks, until it no longer
+// exists or the context is done.
+func (s *Service) WaitForDeletion(ctx context.Context, opts ...WaitOption) error {
+	return waitForDeletion(ctx, s.lookup, opts)
+}
+
+func waitForDeletion(
+	ctx context.Context,
+	lookup func(context.Context) (*flintlocktypes.MicroVM, error),
+	opts []WaitOption,
+) error {
+	err := poll(ctx, opts, func() (bool, error) {
+		mvm, err := lookup(ctx)
+
+		return mvm == nil && err == nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for microvm to be deleted: %w", err)
+	}
+
+	return nil
+}
+
+// lookup returns the microvm by the instance id of the scope, or by the name from the
+// naming strategy if it has no instance id yet. It returns nil if the microvm
+// doesn't exist.
+func (s *Service) lookup(ctx context.Context) (*flintlocktypes.MicroVM, error) {
+	if uid := s.scope.GetInstanceID(); uid != "" {
+		return getByUID(ctx, s.client, uid)
+	}
+
+	name := s.MicroVMName()
+
+	resp, err := s.client.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{
+		Namespace: name.Namespace,
+		Name:      &name.ID,
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mvm := range resp.GetMicrovm() {
+		if mvm.GetSpec().GetId() == name.ID && mvm.GetSpec().GetNamespace() == name.Namespace {
+			return mvm, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// getByUID returns the microvm with the uid, or nil if it doesn't exist.
+func getByUID(ctx context.Context, client flintlockv1.MicroVMClient, uid string) (*flintlocktypes.MicroVM, error) {
+	resp, err := client.GetMicroVM(ctx, &flintlockv1.GetMicroVMRequest{Uid: uid})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.GetMicrovm(), nil
+}
+
+// poll calls done until it returns true or an error, doubling the delay between calls.
+func poll(ctx context.Context, opts []WaitOption, done func() (bool, error)) error {
+	cfg := &waitConfig{interval: DefaultWaitInterval, maxInterval: DefaultMaxWaitInterval}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	interval := max(cfg.interval, MinWaitInterval)
+	maxInterval := max(cfg.maxInterval, interval)
+
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+
+		timer := time.NewTimer(interval)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		interval = min(interval*2, maxInterval)
+	}
+}
@@ -0,0 +1,244 @@
+package microvm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+// fakeWaitClient returns each of the states in turn from GetMicroVM and
+// ListMicroVMs, then the last state. A nil state means not found.
+type fakeWaitClient struct {
+	flintlockv1.MicroVMClient
+
+	states []*flintlocktypes.MicroVMStatus_MicroVMState
+	calls  int
+	err    error
+}
+
+func (f *fakeWaitClient) next() *flintlocktypes.MicroVM {
+	i := f.calls
+	if i >= len(f.states) {
+		i = len(f.states) - 1
+	}
+
+	f.calls++
+
+	if f.states[i] == nil {
+		return nil
+	}
+
+	return &flintlocktypes.MicroVM{
+		Spec:   &flintlocktypes.MicroVMSpec{Id: "foo", Namespace: "bar"},
+		Status: &flintlocktypes.MicroVMStatus{State: *f.states[i], Retry: 3},
+	}
+}
+
+func (f *fakeWaitClient) GetMicroVM(
+	context.Context, *flintlockv1.GetMicroVMRequest, ...grpc.CallOption,
+) (*flintlockv1.GetMicroVMResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	mvm := f.next()
+	if mvm == nil {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+
+	return &flintlockv1.GetMicroVMResponse{Microvm: mvm}, nil
+}
+
+func (f *fakeWaitClient) ListMicroVMs(
+	context.Context, *flintlockv1.ListMicroVMsRequest, ...grpc.CallOption,
+) (*flintlockv1.ListMicroVMsResponse, error) {
+	resp := &flintlockv1.ListMicroVMsResponse{}
+
+	if mvm := f.next(); mvm != nil {
+		resp.Microvm = append(resp.Microvm, mvm)
+	}
+
+	return resp, nil
+}
+
+func (f *fakeWaitClient) Close() {}
+
+func states(states ...flintlocktypes.MicroVMStatus_MicroVMState) []*flintlocktypes.MicroVMStatus_MicroVMState {
+	ptrs := []*flintlocktypes.MicroVMStatus_MicroVMState{}
+
+	for i := range states {
+		if states[i] < 0 {
+			ptrs = append(ptrs, nil)
+		} else {
+			ptrs = append(ptrs, &states[i])
+		}
+	}
+
+	return ptrs
+}
+
+const notFound = flintlocktypes.MicroVMStatus_MicroVMState(-1)
+
+func Test_WaitForState(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name       string
+		instanceID string
+		state      microvm.VMState
+		states     []*flintlocktypes.MicroVMStatus_MicroVMState
+		err        error
+		expected   error
+		calls      int
+	}{
+		{
+			name:       "running",
+			instanceID: "uid",
+			state:      microvm.VMStateRunning,
+			states:     states(flintlocktypes.MicroVMStatus_PENDING, flintlocktypes.MicroVMStatus_PENDING, flintlocktypes.MicroVMStatus_CREATED),
+			calls:      3,
+		},
+		{
+			name:   "running, looked up by name",
+			state:  microvm.VMStateRunning,
+			states: states(flintlocktypes.MicroVMStatus_PENDING, flintlocktypes.MicroVMStatus_CREATED),
+			calls:  2,
+		},
+		{
+			name:       "not found",
+			instanceID: "uid",
+			state:      microvm.VMStateRunning,
+			states:     states(notFound, flintlocktypes.MicroVMStatus_CREATED),
+			expected:   ErrMicroVMNotFound,
+			calls:      1,
+		},
+		{
+			name:       "failed",
+			instanceID: "uid",
+			state:      microvm.VMStateRunning,
+			states:     states(flintlocktypes.MicroVMStatus_PENDING, flintlocktypes.MicroVMStatus_FAILED),
+			expected:   ErrMicroVMFailed,
+			calls:      2,
+		},
+		{
+			name:       "waiting for failed",
+			instanceID: "uid",
+			state:      microvm.VMStateFailed,
+			states:     states(flintlocktypes.MicroVMStatus_FAILED),
+			calls:      1,
+		},
+		{
+			name:       "deleted",
+			instanceID: "uid",
+			state:      microvm.VMStateDeleted,
+			states:     states(flintlocktypes.MicroVMStatus_DELETING, notFound),
+			calls:      2,
+		},
+		{
+			name:       "client error",
+			instanceID: "uid",
+			state:      microvm.VMStateRunning,
+			states:     states(flintlocktypes.MicroVMStatus_PENDING),
+			err:        errUnavailable,
+			expected:   errUnavailable,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mScope := validScope()
+			mScope.GetInstanceIDReturns(tc.instanceID)
+
+			client := &fakeWaitClient{states: tc.states, err: tc.err}
+			svc := New(mScope, client, "host")
+
+			_, err := svc.WaitForState(context.TODO(), tc.state, WithWaitInterval(time.Millisecond, 2*time.Millisecond))
+			if tc.expected != nil {
+				g.Expect(err).To(MatchError(tc.expected))
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+
+			g.Expect(client.calls).To(Equal(tc.calls))
+		})
+	}
+}
+
+func Test_WaitForStateFailureReason(t *testing.T) {
+	g := NewWithT(t)
+
+	mScope := validScope()
+	mScope.GetInstanceIDReturns("uid")
+
+	svc := New(mScope, &fakeWaitClient{states: states(flintlocktypes.MicroVMStatus_FAILED)}, "host")
+
+	mvm, err := svc.WaitForState(context.TODO(), microvm.VMStateRunning)
+	g.Expect(err).To(MatchError(ContainSubstring("bar/foo after 3 retries")))
+	g.Expect(VMStateFor(mvm)).To(Equal(microvm.VMStateFailed))
+
+	var failed *MicroVMFailedError
+	g.Expect(errors.As(err, &failed)).To(BeTrue())
+	g.Expect(failed.MicroVM).To(Equal(mvm))
+}
+
+func Test_WaitForDeletion(t *testing.T) {
+	g := NewWithT(t)
+
+	mScope := validScope()
+
+	client := &fakeWaitClient{states: states(flintlocktypes.MicroVMStatus_DELETING)}
+	svc := New(mScope, client, "host")
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*MinWaitInterval)
+	defer cancel()
+
+	err := svc.WaitForDeletion(ctx, WithWaitInterval(MinWaitInterval, 2*MinWaitInterval))
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+	g.Expect(client.calls).To(BeNumerically(">", 1))
+
+	client.states = states(notFound)
+	g.Expect(svc.WaitForDeletion(context.TODO())).To(Succeed())
+}
+
+func Test_WaitIntervalIsClamped(t *testing.T) {
+	g := NewWithT(t)
+
+	client := &fakeWaitClient{states: states(flintlocktypes.MicroVMStatus_DELETING)}
+	svc := New(validScope(), client, "host")
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*MinWaitInterval)
+	defer cancel()
+
+	err := svc.WaitForDeletion(ctx, WithWaitInterval(0, -time.Second))
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+	g.Expect(client.calls).To(BeNumerically("<=", 6))
+}
+
+func Test_WaitForMicroVMState(t *testing.T) {
+	g := NewWithT(t)
+
+	client := &fakeWaitClient{states: states(flintlocktypes.MicroVMStatus_PENDING, flintlocktypes.MicroVMStatus_CREATED)}
+
+	mvm, err := WaitForMicroVMState(context.TODO(), client, "uid", microvm.VMStateRunning, WithWaitInterval(MinWaitInterval, MinWaitInterval))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(VMStateFor(mvm)).To(Equal(microvm.VMStateRunning))
+	g.Expect(client.calls).To(Equal(2))
+
+	client = &fakeWaitClient{states: states(notFound)}
+
+	_, err = WaitForMicroVMState(context.TODO(), client, "uid", microvm.VMStateRunning)
+	g.Expect(err).To(MatchError(ErrMicroVMNotFound))
+	g.Expect(WaitForMicroVMState(context.TODO(), client, "uid", microvm.VMStateDeleted)).Error().NotTo(HaveOccurred())
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2022 Weaveworks.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var errUnterminatedQuote = errors.New("unterminated quote")
+
+// KernelArg is a single kernel command line argument.
+type KernelArg struct {
+	// Name is the name of the argument.
+	Name string `json:"name"`
+	// Value is the value of the argument. If empty the argument is a bare flag,
+	// such as quiet or ro.
+	// +optional
+	Value string `json:"value,omitempty"`
+}
+
+// String returns the argument as it appears on the kernel command line. Values
+// containing whitespace are quoted.
+func (a KernelArg) String() string {
+	if a.Value == "" {
+		return a.Name
+	}
+
+	if strings.IndexFunc(a.Value, unicode.IsSpace) >= 0 {
+		return a.Name + `="` + a.Value + `"`
+	}
+
+	return a.Name + "=" + a.Value
+}
+
+// KernelCmdLine is an ordered kernel command line. Arguments may be repeated, for
+// example console=ttyS0 console=tty0.
+//
+// It is marshalled to JSON as a list of arguments, ["console=ttyS0", "quiet"]. For
+// backwards compatibility it can also be unmarshalled from a single string or from
+// the map of name to value used by earlier versions, in which case the arguments
+// are sorted by name.
+type KernelCmdLine []KernelArg
+
+// ParseKernelCmdLine parses a kernel command line such as
+// `console=ttyS0 quiet dyndbg="file foo.c +p"`. Arguments are separated by
+// whitespace and values may be double quoted.
+func ParseKernelCmdLine(cmdline string) (KernelCmdLine, error) {
+	args := KernelCmdLine{}
+
+	var (
+		current strings.Builder
+		quoted  bool
+		started bool
+	)
+
+	flush := func() {
+		if started {
+			args = append(args, parseKernelArg(current.String()))
+		}
+
+		current.Reset()
+
+		started = false
+	}
+
+	for _, r := range cmdline {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case unicode.IsSpace(r) && !quoted:
+			flush()
+		default:
+			current.WriteRune(r)
+
+			started = true
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("parsing kernel cmdline %q: %w", cmdline, errUnterminatedQuote)
+	}
+
+	flush()
+
+	return args, nil
+}
+
+// KernelCmdLineFromMap converts the map of name to value used by earlier versions of
+// the VMSpec, and by flintlock, into a KernelCmdLine. Each entry is parsed the way
+// the kernel would see it once rendered as name=value, so folded entries produced by
+// ToMap are split back into their arguments. The entries are sorted by name as the
+// map has no order.
+func KernelCmdLineFromMap(args map[string]string) KernelCmdLine {
+	if args == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	cmdline := make(KernelCmdLine, 0, len(names))
+
+	for _, name := range names {
+		raw := name
+		if args[name] != "" {
+			raw = name + "=" + args[name]
+		}
+
+		parsed, err := ParseKernelCmdLine(raw)
+		if err != nil || len(parsed) == 0 || parsed[0].Name != name {
+			parsed = KernelCmdLine{{Name: name, Value: args[name]}}
+		}
+
+		cmdline = append(cmdline, parsed...)
+	}
+
+	return cmdline
+}
+
+// MergeKernelCmdLine merges the cmdline with the defaults, such as those of the
+// microvm provider. Any argument in the cmdline replaces every argument of the same
+// name in the defaults. The remaining defaults come first, in their original order,
+// followed by the cmdline.
+func MergeKernelCmdLine(defaults, cmdline KernelCmdLine) KernelCmdLine {
+	overridden := map[string]bool{}
+	for _, arg := range cmdline {
+		overridden[arg.Name] = true
+	}
+
+	merged := make(KernelCmdLine, 0, len(defaults)+len(cmdline))
+
+	for _, arg := range defaults {
+		if !overridden[arg.Name] {
+			merged = append(merged, arg)
+		}
+	}
+
+	return append(merged, cmdline...)
+}
+
+// String returns the formatted kernel command line.
+func (c KernelCmdLine) String() string {
+	args := make([]string, 0, len(c))
+	for _, arg := range c {
+		args = append(args, arg.String())
+	}
+
+	return strings.Join(args, " ")
+}
+
+// Get returns the value of the last argument with the name, which is the one the
+// kernel uses, and whether the argument is present.
+func (c KernelCmdLine) Get(name string) (string, bool) {
+	for i := len(c) - 1; i >= 0; i-- {
+		if c[i].Name == name {
+			return c[i].Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Values returns the values of all the arguments with the name, in order.
+func (c KernelCmdLine) Values(name string) []string {
+	values := []string{}
+
+	for _, arg := range c {
+		if arg.Name == name {
+			values = append(values, arg.Value)
+		}
+	}
+
+	return values
+}
+
+// ToMap converts the cmdline to the map of name to value used by flintlock's
+// Kernel.Cmdline. The map can't hold repeated arguments, so all the arguments with
+// the same name are folded into the value of a single entry: console=ttyS0
+// console=tty0 becomes "console": "ttyS0 console=tty0", which flintlock renders as
+// the original arguments. The folded values aren't the values of the arguments, so
+// use Values rather than the map to read them. Exact duplicates are dropped, as are
+// bare flags repeated alongside values.
+func (c KernelCmdLine) ToMap() map[string]string {
+	if c == nil {
+		return nil
+	}
+
+	grouped := map[string][]KernelArg{}
+
+	for _, arg := range c {
+		grouped[arg.Name] = append(grouped[arg.Name], arg)
+	}
+
+	out := make(map[string]string, len(grouped))
+
+	for name, args := range grouped {
+		args = dedupeKernelArgs(args)
+
+		formatted := make([]string, 0, len(args))
+		for _, arg := range args {
+			formatted = append(formatted, arg.String())
+		}
+
+		out[name] = strings.TrimPrefix(strings.Join(formatted, " "), name+"=")
+		if len(args) == 1 && args[0].Value == "" {
+			out[name] = ""
+		}
+	}
+
+	return out
+}
+
+// MarshalJSON marshals the cmdline as a list of arguments.
+func (c KernelCmdLine) MarshalJSON() ([]byte, error) {
+	if c == nil {
+		return []byte("null"), nil
+	}
+
+	args := make([]string, 0, len(c))
+	for _, arg := range c {
+		args = append(args, arg.String())
+	}
+
+	return json.Marshal(args)
+}
+
+// UnmarshalJSON unmarshals the cmdline from a list of arguments, a single string or
+// the map of name to value used by earlier versions.
+func (c *KernelCmdLine) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*c = nil
+	case bytes.HasPrefix(data, []byte("{")):
+		args := map[string]string{}
+		if err := json.Unmarshal(data, &args); err != nil {
+			return fmt.Errorf("unmarshalling kernel cmdline map: %w", err)
+		}
+
+		*c = KernelCmdLineFromMap(args)
+	case bytes.HasPrefix(data, []byte(`"`)):
+		var cmdline string
+		if err := json.Unmarshal(data, &cmdline); err != nil {
+			return fmt.Errorf("unmarshalling kernel cmdline: %w", err)
+		}
+
+		parsed, err := ParseKernelCmdLine(cmdline)
+		if err != nil {
+			return err
+		}
+
+		*c = parsed
+	default:
+		args := []string{}
+		if err := json.Unmarshal(data, &args); err != nil {
+			return fmt.Errorf("unmarshalling kernel cmdline args: %w", err)
+		}
+
+		parsed := make(KernelCmdLine, 0, len(args))
+		for _, arg := range args {
+			parsed = append(parsed, parseKernelArg(strings.TrimSpace(arg)))
+		}
+
+		*c = parsed
+	}
+
+	return nil
+}
+
+// parseKernelArg splits an argument into its name and value, removing any quotes
+// around the value.
+func parseKernelArg(arg string) KernelArg {
+	name, value, _ := strings.Cut(arg, "=")
+
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		value = value[1 : len(value)-1]
+	}
+
+	return KernelArg{Name: name, Value: value}
+}
+
+func dedupeKernelArgs(args []KernelArg) []KernelArg {
+	seen := map[KernelArg]bool{}
+	hasValue := false
+
+	for _, arg := range args {
+		hasValue = hasValue || arg.Value != ""
+	}
+
+	deduped := make([]KernelArg, 0, len(args))
+
+	for _, arg := range args {
+		if seen[arg] || (hasValue && arg.Value == "") {
+			continue
+		}
+
+		seen[arg] = true
+		deduped = append(deduped, arg)
+	}
+
+	return deduped
+}
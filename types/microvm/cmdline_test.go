@@ -0,0 +1,134 @@
+package microvm_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+func Test_ParseKernelCmdLine(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name     string
+		input    string
+		expected microvm.KernelCmdLine
+	}{
+		{
+			name:     "empty",
+			input:    "  ",
+			expected: microvm.KernelCmdLine{},
+		},
+		{
+			name:  "repeated args and flags keep their order",
+			input: "console=ttyS0 quiet  console=tty0 ro",
+			expected: microvm.KernelCmdLine{
+				{Name: "console", Value: "ttyS0"},
+				{Name: "quiet"},
+				{Name: "console", Value: "tty0"},
+				{Name: "ro"},
+			},
+		},
+		{
+			name:  "quoted values",
+			input: `dyndbg="file foo.c +p" root=/dev/vda`,
+			expected: microvm.KernelCmdLine{
+				{Name: "dyndbg", Value: "file foo.c +p"},
+				{Name: "root", Value: "/dev/vda"},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cmdline, err := microvm.ParseKernelCmdLine(tc.input)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(cmdline).To(Equal(tc.expected))
+
+			reparsed, err := microvm.ParseKernelCmdLine(cmdline.String())
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(reparsed).To(Equal(tc.expected))
+		})
+	}
+
+	_, err := microvm.ParseKernelCmdLine(`dyndbg="file foo.c`)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func Test_KernelCmdLineJSON(t *testing.T) {
+	g := NewWithT(t)
+
+	expected := microvm.KernelCmdLine{
+		{Name: "console", Value: "ttyS0"},
+		{Name: "quiet"},
+	}
+
+	tt := []struct {
+		name  string
+		input string
+	}{
+		{name: "list", input: `["console=ttyS0", "quiet"]`},
+		{name: "string", input: `"console=ttyS0 quiet"`},
+		{name: "legacy map is sorted by name", input: `{"quiet": "", "console": "ttyS0"}`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := microvm.VMSpec{}
+			g.Expect(json.Unmarshal([]byte(`{"kernelCmdline": `+tc.input+`}`), &spec)).To(Succeed())
+
+			g.Expect(spec.KernelCmdLine).To(Equal(expected))
+		})
+	}
+
+	data, err := json.Marshal(expected)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(data)).To(Equal(`["console=ttyS0","quiet"]`))
+
+	data, err = json.Marshal(microvm.VMSpec{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(data)).NotTo(ContainSubstring("kernelCmdline"))
+}
+
+func Test_MergeKernelCmdLine(t *testing.T) {
+	g := NewWithT(t)
+
+	defaults, err := microvm.ParseKernelCmdLine("console=ttyS0 reboot=k panic=1 console=tty0 pci=off")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	cmdline, err := microvm.ParseKernelCmdLine("console=hvc0 quiet pci=on")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	merged := microvm.MergeKernelCmdLine(defaults, cmdline)
+	g.Expect(merged.String()).To(Equal("reboot=k panic=1 console=hvc0 quiet pci=on"))
+
+	value, ok := merged.Get("pci")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(value).To(Equal("on"))
+
+	_, ok = merged.Get("ro")
+	g.Expect(ok).To(BeFalse())
+
+	g.Expect(defaults.Values("console")).To(Equal([]string{"ttyS0", "tty0"}))
+}
+
+func Test_KernelCmdLineMap(t *testing.T) {
+	g := NewWithT(t)
+
+	cmdline, err := microvm.ParseKernelCmdLine(`console=ttyS0 quiet console=tty0 console=tty0 dyndbg="file foo.c" quiet`)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	args := cmdline.ToMap()
+	g.Expect(args).To(Equal(map[string]string{
+		"console": "ttyS0 console=tty0",
+		"quiet":   "",
+		"dyndbg":  `"file foo.c"`,
+	}))
+
+	g.Expect(microvm.KernelCmdLineFromMap(args).String()).To(Equal(`console=ttyS0 console=tty0 dyndbg="file foo.c" quiet`))
+	g.Expect(microvm.KernelCmdLine(nil).ToMap()).To(BeNil())
+	g.Expect(microvm.KernelCmdLineFromMap(nil)).To(BeNil())
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2022 Weaveworks.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microvm
+
+import "fmt"
+
+const (
+	// DefaultRootVolumeID is the ID given to the root volume if one isn't supplied.
+	DefaultRootVolumeID = "root"
+	// DefaultKernelFilename is the kernel filename used if one isn't supplied.
+	DefaultKernelFilename = "boot/vmlinux"
+	// DefaultGuestDeviceNamePrefix is the prefix of the guest device names given to
+	// network interfaces which don't supply one.
+	DefaultGuestDeviceNamePrefix = "eth"
+)
+
+// Default sets the default values of the spec. It is idempotent so it is safe to
+// call from defaulting webhooks. The defaults are:
+//   - the root volume ID is DefaultRootVolumeID.
+//   - the kernel filename is DefaultKernelFilename.
+//   - network interfaces are named eth0, eth1... skipping names already in use.
+//   - network interfaces are macvtap, or tap for the cloud-hypervisor provider which
+//     doesn't support macvtap.
+//
+// The provider isn't defaulted, as the default is chosen by the flintlock host.
+func Default(spec *VMSpec) {
+	if spec.RootVolume.ID == "" {
+		spec.RootVolume.ID = DefaultRootVolumeID
+	}
+
+	if spec.Kernel.Filename == "" {
+		spec.Kernel.Filename = DefaultKernelFilename
+	}
+
+	defaultNetworkInterfaces(spec)
+}
+
+// DefaultIfaceType returns the default network interface type for the provider.
+func DefaultIfaceType(provider string) IfaceType {
+	if provider == ProviderCloudHypervisor {
+		return IfaceTypeTap
+	}
+
+	return IfaceTypeMacvtap
+}
+
+func defaultNetworkInterfaces(spec *VMSpec) {
+	used := map[string]bool{}
+
+	for i := range spec.NetworkInterfaces {
+		used[spec.NetworkInterfaces[i].GuestDeviceName] = true
+	}
+
+	next := 0
+
+	for i := range spec.NetworkInterfaces {
+		iface := &spec.NetworkInterfaces[i]
+
+		if iface.GuestDeviceName == "" {
+			for used[fmt.Sprintf("%s%d", DefaultGuestDeviceNamePrefix, next)] {
+				next++
+			}
+
+			iface.GuestDeviceName = fmt.Sprintf("%s%d", DefaultGuestDeviceNamePrefix, next)
+			used[iface.GuestDeviceName] = true
+		}
+
+		if iface.Type == "" {
+			iface.Type = DefaultIfaceType(spec.Provider)
+		}
+	}
+}
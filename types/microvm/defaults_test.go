@@ -0,0 +1,79 @@
+package microvm_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+func Test_Default(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name     string
+		input    microvm.VMSpec
+		expected microvm.VMSpec
+	}{
+		{
+			name: "empty spec",
+			input: microvm.VMSpec{
+				NetworkInterfaces: []microvm.NetworkInterface{{}, {}},
+			},
+			expected: microvm.VMSpec{
+				RootVolume: microvm.Volume{ID: microvm.DefaultRootVolumeID},
+				Kernel:     microvm.ContainerFileSource{Filename: microvm.DefaultKernelFilename},
+				NetworkInterfaces: []microvm.NetworkInterface{
+					{GuestDeviceName: "eth0", Type: microvm.IfaceTypeMacvtap},
+					{GuestDeviceName: "eth1", Type: microvm.IfaceTypeMacvtap},
+				},
+			},
+		},
+		{
+			name: "cloud-hypervisor uses tap and names skip existing",
+			input: microvm.VMSpec{
+				Provider:          microvm.ProviderCloudHypervisor,
+				NetworkInterfaces: []microvm.NetworkInterface{{}, {GuestDeviceName: "eth0"}},
+			},
+			expected: microvm.VMSpec{
+				Provider:   microvm.ProviderCloudHypervisor,
+				RootVolume: microvm.Volume{ID: microvm.DefaultRootVolumeID},
+				Kernel:     microvm.ContainerFileSource{Filename: microvm.DefaultKernelFilename},
+				NetworkInterfaces: []microvm.NetworkInterface{
+					{GuestDeviceName: "eth1", Type: microvm.IfaceTypeTap},
+					{GuestDeviceName: "eth0", Type: microvm.IfaceTypeTap},
+				},
+			},
+		},
+		{
+			name: "supplied values are kept",
+			input: microvm.VMSpec{
+				RootVolume: microvm.Volume{ID: "os"},
+				Kernel:     microvm.ContainerFileSource{Filename: "vmlinuz"},
+				NetworkInterfaces: []microvm.NetworkInterface{
+					{GuestDeviceName: "ens1", Type: microvm.IfaceTypeTap},
+				},
+			},
+			expected: microvm.VMSpec{
+				RootVolume: microvm.Volume{ID: "os"},
+				Kernel:     microvm.ContainerFileSource{Filename: "vmlinuz"},
+				NetworkInterfaces: []microvm.NetworkInterface{
+					{GuestDeviceName: "ens1", Type: microvm.IfaceTypeTap},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := tc.input
+
+			microvm.Default(&spec)
+			g.Expect(spec).To(Equal(tc.expected))
+
+			microvm.Default(&spec)
+			g.Expect(spec).To(Equal(tc.expected))
+		})
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2022 Weaveworks.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microvm
+
+import "k8s.io/apimachinery/pkg/api/resource"
+
+const (
+	// AuthTokenSecretKey is the key of the basic auth token in the secret referred
+	// to by Host.AuthSecretRef.
+	AuthTokenSecretKey = "token"
+	// TLSCertSecretKey is the key of the client certificate in the secret referred
+	// to by Host.TLSSecretRef.
+	TLSCertSecretKey = "tls.crt"
+	// TLSKeySecretKey is the key of the client private key in the secret referred
+	// to by Host.TLSSecretRef.
+	TLSKeySecretKey = "tls.key"
+	// TLSCASecretKey is the key of the CA certificate in the secret referred to by
+	// Host.TLSSecretRef.
+	TLSCASecretKey = "ca.crt"
+)
+
+// TaintEffect is the effect of a taint on the microvms of a host.
+type TaintEffect string
+
+const (
+	// TaintEffectNoSchedule stops new microvms being placed on the host. Existing
+	// microvms are left running.
+	TaintEffectNoSchedule = TaintEffect("NoSchedule")
+	// TaintEffectNoExecute stops new microvms being placed on the host and marks
+	// existing microvms for eviction, such as when the host is drained.
+	TaintEffectNoExecute = TaintEffect("NoExecute")
+)
+
+// Host is a flintlock host which microvms can be created on.
+type Host struct {
+	// Name is an optional name for the host.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Endpoint is the API endpoint for the microvm service (i.e. flintlock)
+	// including the port.
+	// +kubebuilder:validation:Required
+	Endpoint string `json:"endpoint"`
+	// Labels are the labels of the host, such as ZoneLabel and FailureDomainLabel,
+	// used when choosing a host for a microvm.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// Taints mark the host as unavailable for microvms, such as during maintenance.
+	// +optional
+	Taints []Taint `json:"taints,omitempty"`
+	// Allocatable is the vcpu and memory of the host available to microvms. If not
+	// supplied the host has no limit.
+	// +optional
+	Allocatable *HostResources `json:"allocatable,omitempty"`
+	// PreferredProvider is the microvm provider to use on the host for microvms
+	// which don't specify one.
+	// +kubebuilder:validation:Enum=firecracker;cloudhypervisor
+	// +optional
+	PreferredProvider string `json:"preferredProvider,omitempty"`
+	// AuthSecretRef refers to a secret holding the basic auth token for the host,
+	// under the AuthTokenSecretKey key.
+	// +optional
+	AuthSecretRef *SecretReference `json:"authSecretRef,omitempty"`
+	// TLSSecretRef refers to a secret holding the client certificate, private key and
+	// CA certificate used to connect to the host over TLS, under the TLSCertSecretKey,
+	// TLSKeySecretKey and TLSCASecretKey keys.
+	// +optional
+	TLSSecretRef *SecretReference `json:"tlsSecretRef,omitempty"`
+}
+
+// Zone returns the zone of the host from its labels.
+func (h *Host) Zone() string {
+	return h.Labels[ZoneLabel]
+}
+
+// FailureDomain returns the failure domain of the host from its labels.
+func (h *Host) FailureDomain() string {
+	return h.Labels[FailureDomainLabel]
+}
+
+// HasTaintEffect returns true if the host has a taint with the effect. A
+// NoExecute taint also has the effect of NoSchedule.
+func (h *Host) HasTaintEffect(effect TaintEffect) bool {
+	for _, taint := range h.Taints {
+		if taint.Effect == effect || taint.Effect == TaintEffectNoExecute {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Taint marks a host as unavailable for microvms.
+type Taint struct {
+	// Key is the key of the taint.
+	// +kubebuilder:validation:Required
+	Key string `json:"key"`
+	// Value is an optional value of the taint.
+	// +optional
+	Value string `json:"value,omitempty"`
+	// Effect is the effect of the taint on the microvms of the host.
+	// +kubebuilder:validation:Enum=NoSchedule;NoExecute
+	// +kubebuilder:validation:Required
+	Effect TaintEffect `json:"effect"`
+}
+
+// String returns the taint in the form key=value:effect.
+func (t Taint) String() string {
+	if t.Value == "" {
+		return t.Key + ":" + string(t.Effect)
+	}
+
+	return t.Key + "=" + t.Value + ":" + string(t.Effect)
+}
+
+// HostResources is an amount of vcpu and memory on a host.
+type HostResources struct {
+	// VCPU is the number of virtual CPUs.
+	// +optional
+	VCPU int64 `json:"vcpu,omitempty"`
+	// Memory is the amount of memory, such as 64Gi.
+	// +optional
+	Memory *resource.Quantity `json:"memory,omitempty"`
+}
+
+// MemoryMiB returns the memory in mebibytes, rounded up. It is 0 when the memory
+// isn't set.
+func (r *HostResources) MemoryMiB() int64 {
+	if r.Memory == nil {
+		return 0
+	}
+
+	return quantityMiB(r.Memory)
+}
+
+// SecretReference refers to a secret by name and namespace.
+type SecretReference struct {
+	// Name is the name of the secret.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+	// Namespace is the namespace of the secret. If not supplied the namespace of
+	// the object referring to the secret is used.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
@@ -0,0 +1,39 @@
+package microvm_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+func Test_HostTaints(t *testing.T) {
+	g := NewWithT(t)
+
+	host := microvm.Host{}
+	g.Expect(host.HasTaintEffect(microvm.TaintEffectNoSchedule)).To(BeFalse())
+
+	host.Taints = []microvm.Taint{{Key: "maintenance", Value: "kernel", Effect: microvm.TaintEffectNoSchedule}}
+	g.Expect(host.HasTaintEffect(microvm.TaintEffectNoSchedule)).To(BeTrue())
+	g.Expect(host.HasTaintEffect(microvm.TaintEffectNoExecute)).To(BeFalse())
+	g.Expect(host.Taints[0].String()).To(Equal("maintenance=kernel:NoSchedule"))
+
+	host.Taints = []microvm.Taint{{Key: "drain", Effect: microvm.TaintEffectNoExecute}}
+	g.Expect(host.HasTaintEffect(microvm.TaintEffectNoSchedule)).To(BeTrue())
+	g.Expect(host.HasTaintEffect(microvm.TaintEffectNoExecute)).To(BeTrue())
+	g.Expect(host.Taints[0].String()).To(Equal("drain:NoExecute"))
+}
+
+func Test_HostResourcesMemoryMiB(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect((&microvm.HostResources{}).MemoryMiB()).To(Equal(int64(0)))
+
+	memory := resource.MustParse("64Gi")
+	g.Expect((&microvm.HostResources{Memory: &memory}).MemoryMiB()).To(Equal(int64(65536)))
+
+	memory = resource.MustParse("1G")
+	g.Expect((&microvm.HostResources{Memory: &memory}).MemoryMiB()).To(Equal(int64(954)))
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2022 Weaveworks.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microvm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+var (
+	// ErrInstanceTypeNotFound is returned when a spec refers to an instance type
+	// which isn't in the catalogue.
+	ErrInstanceTypeNotFound = errors.New("instance type not found")
+
+	errDuplicateInstanceType = errors.New("duplicate instance type")
+	errInvalidInstanceType   = errors.New("invalid instance type")
+)
+
+// InstanceTypeSpec is the size of an instance type. It can be embedded in the spec
+// of an instance type custom resource.
+type InstanceTypeSpec struct {
+	// VCPU is the number of vcpus of the instance type.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum:=1
+	VCPU int64 `json:"vcpu"`
+	// Memory is the amount of memory of the instance type.
+	// +kubebuilder:validation:Required
+	Memory resource.Quantity `json:"memory"`
+}
+
+// InstanceType is a named microvm size.
+type InstanceType struct {
+	// Name is the name of the instance type, used by VMSpec.InstanceType.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	InstanceTypeSpec `json:",inline"`
+}
+
+// InstanceTypeGetter gets instance types by name. It is implemented by
+// InstanceTypeCatalogue, and can be implemented by a lister of instance type
+// custom resources.
+type InstanceTypeGetter interface {
+	// GetInstanceType returns the named instance type and whether it exists.
+	GetInstanceType(name string) (InstanceType, bool)
+}
+
+// InstanceTypeCatalogue is a set of instance types keyed by name.
+type InstanceTypeCatalogue map[string]InstanceType
+
+// InstanceTypeConfig is the format of an instance type catalogue config file:
+//
+//	instanceTypes:
+//	- name: small
+//	  vcpu: 2
+//	  memory: 2Gi
+type InstanceTypeConfig struct {
+	// InstanceTypes are the instance types in the catalogue.
+	InstanceTypes []InstanceType `json:"instanceTypes"`
+}
+
+// NewInstanceTypeCatalogue returns a catalogue of the instance types. It is an error
+// for a name to be used twice, or for an instance type to have no vcpus or memory.
+func NewInstanceTypeCatalogue(instanceTypes ...InstanceType) (InstanceTypeCatalogue, error) {
+	catalogue := make(InstanceTypeCatalogue, len(instanceTypes))
+
+	for _, it := range instanceTypes {
+		switch {
+		case it.Name == "":
+			return nil, fmt.Errorf("instance type without a name: %w", errInvalidInstanceType)
+		case it.VCPU < 1 || it.Memory.Sign() <= 0:
+			return nil, fmt.Errorf("instance type %s must have vcpu and memory: %w", it.Name, errInvalidInstanceType)
+		}
+
+		if _, ok := catalogue[it.Name]; ok {
+			return nil, fmt.Errorf("instance type %s: %w", it.Name, errDuplicateInstanceType)
+		}
+
+		catalogue[it.Name] = it
+	}
+
+	return catalogue, nil
+}
+
+// LoadInstanceTypeCatalogue reads a catalogue in the InstanceTypeConfig format, as
+// YAML or JSON.
+func LoadInstanceTypeCatalogue(r io.Reader) (InstanceTypeCatalogue, error) {
+	config := InstanceTypeConfig{}
+
+	if err := yaml.NewYAMLOrJSONDecoder(r, 4096).Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decoding instance types: %w", err)
+	}
+
+	return NewInstanceTypeCatalogue(config.InstanceTypes...)
+}
+
+// LoadInstanceTypeCatalogueFile reads a catalogue from a config file, see
+// LoadInstanceTypeCatalogue.
+func LoadInstanceTypeCatalogueFile(path string) (InstanceTypeCatalogue, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening instance types file: %w", err)
+	}
+	defer file.Close()
+
+	return LoadInstanceTypeCatalogue(file)
+}
+
+// GetInstanceType returns the named instance type and whether it exists.
+func (c InstanceTypeCatalogue) GetInstanceType(name string) (InstanceType, bool) {
+	it, ok := c[name]
+
+	return it, ok
+}
+
+// Names returns the sorted names of the instance types.
+func (c InstanceTypeCatalogue) Names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// ResolveInstanceType sets the vcpu and memory of the spec from its instance type.
+// Values set explicitly in the spec take precedence over the instance type. Specs
+// without an instance type are left unchanged.
+func ResolveInstanceType(spec *VMSpec, getter InstanceTypeGetter) error {
+	if spec.InstanceType == "" {
+		return nil
+	}
+
+	if getter == nil {
+		return fmt.Errorf("instance type %s: %w", spec.InstanceType, ErrInstanceTypeNotFound)
+	}
+
+	it, ok := getter.GetInstanceType(spec.InstanceType)
+	if !ok {
+		return fmt.Errorf("instance type %s: %w", spec.InstanceType, ErrInstanceTypeNotFound)
+	}
+
+	if spec.VCPU == 0 {
+		spec.VCPU = it.VCPU
+	}
+
+	if spec.Memory == nil && spec.MemoryMb == 0 {
+		memory := it.Memory.DeepCopy()
+		spec.Memory = &memory
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package microvm_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+const instanceTypesConfig = `
+instanceTypes:
+- name: small
+  vcpu: 2
+  memory: 2Gi
+- name: large
+  vcpu: 8
+  memory: 16Gi
+`
+
+func Test_LoadInstanceTypeCatalogue(t *testing.T) {
+	g := NewWithT(t)
+
+	catalogue, err := microvm.LoadInstanceTypeCatalogue(strings.NewReader(instanceTypesConfig))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(catalogue.Names()).To(Equal([]string{"large", "small"}))
+
+	small, ok := catalogue.GetInstanceType("small")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(small.VCPU).To(Equal(int64(2)))
+	g.Expect(small.Memory.String()).To(Equal("2Gi"))
+
+	_, ok = catalogue.GetInstanceType("medium")
+	g.Expect(ok).To(BeFalse())
+
+	_, err = microvm.LoadInstanceTypeCatalogue(strings.NewReader(instanceTypesConfig + "- name: small\n  vcpu: 1\n  memory: 1Gi\n"))
+	g.Expect(err).To(MatchError(ContainSubstring("duplicate")))
+
+	_, err = microvm.LoadInstanceTypeCatalogue(strings.NewReader("instanceTypes:\n- name: empty\n"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func Test_ResolveInstanceType(t *testing.T) {
+	g := NewWithT(t)
+
+	catalogue, err := microvm.NewInstanceTypeCatalogue(microvm.InstanceType{
+		Name: "small",
+		InstanceTypeSpec: microvm.InstanceTypeSpec{
+			VCPU:   2,
+			Memory: resource.MustParse("2Gi"),
+		},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	tt := []struct {
+		name        string
+		spec        microvm.VMSpec
+		expectedCPU int64
+		expectedMiB int64
+		expectedErr error
+	}{
+		{
+			name:        "no instance type",
+			spec:        microvm.VMSpec{VCPU: 1, MemoryMb: 1024},
+			expectedCPU: 1,
+			expectedMiB: 1024,
+		},
+		{
+			name:        "resolved",
+			spec:        microvm.VMSpec{InstanceType: "small"},
+			expectedCPU: 2,
+			expectedMiB: 2048,
+		},
+		{
+			name:        "explicit values win",
+			spec:        microvm.VMSpec{InstanceType: "small", VCPU: 4, MemoryMb: 8192},
+			expectedCPU: 4,
+			expectedMiB: 8192,
+		},
+		{
+			name:        "unknown",
+			spec:        microvm.VMSpec{InstanceType: "huge"},
+			expectedErr: microvm.ErrInstanceTypeNotFound,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := tc.spec
+
+			err := microvm.ResolveInstanceType(&spec, catalogue)
+			if tc.expectedErr != nil {
+				g.Expect(err).To(MatchError(tc.expectedErr))
+
+				return
+			}
+
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(spec.VCPU).To(Equal(tc.expectedCPU))
+			g.Expect(spec.MemoryMiB()).To(Equal(tc.expectedMiB))
+		})
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2022 Weaveworks.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microvm
+
+import "strings"
+
+const (
+	// LabelPrefix is the prefix of the labels reserved for the microvms of liquid
+	// metal components, such as the ownership labels.
+	LabelPrefix = "liquidmetal.dev/"
+	// OwnerKindLabel is the label holding the kind of the object owning a microvm.
+	OwnerKindLabel = LabelPrefix + "owner-kind"
+	// OwnerNamespaceLabel is the label holding the namespace of the object owning a
+	// microvm.
+	OwnerNamespaceLabel = LabelPrefix + "owner-namespace"
+	// OwnerNameLabel is the label holding the name of the object owning a microvm.
+	OwnerNameLabel = LabelPrefix + "owner-name"
+	// OwnerUIDLabel is the label holding the UID of the object owning a microvm.
+	OwnerUIDLabel = LabelPrefix + "owner-uid"
+	// ManagedByLabel is the label holding the name of the component which created
+	// a microvm.
+	ManagedByLabel = LabelPrefix + "managed-by"
+	// ClusterNameLabel is the label holding the name of the cluster a microvm
+	// belongs to.
+	ClusterNameLabel = LabelPrefix + "cluster-name"
+	// SpecHashLabel is the label holding a hash of the spec a microvm was created
+	// from.
+	SpecHashLabel = LabelPrefix + "spec-hash"
+)
+
+// IsReservedLabel returns true if the label key uses the reserved LabelPrefix, so
+// can't be set by users.
+func IsReservedLabel(key string) bool {
+	return strings.HasPrefix(key, LabelPrefix)
+}
+
+// Owner is the object owning a microvm, such as a kubernetes resource, recorded in
+// the ownership labels of the microvm.
+type Owner struct {
+	// Kind is the kind of the owner.
+	Kind string
+	// Namespace is the namespace of the owner.
+	Namespace string
+	// Name is the name of the owner.
+	Name string
+	// UID is the unique ID of the owner. It is optional.
+	UID string
+}
+
+// OwnerFromLabels returns the owner recorded in the labels of a microvm. It returns
+// false if the labels don't have an owner kind and name.
+func OwnerFromLabels(labels map[string]string) (Owner, bool) {
+	owner := Owner{
+		Kind:      labels[OwnerKindLabel],
+		Namespace: labels[OwnerNamespaceLabel],
+		Name:      labels[OwnerNameLabel],
+		UID:       labels[OwnerUIDLabel],
+	}
+
+	return owner, owner.Kind != "" && owner.Name != ""
+}
+
+// Labels returns the ownership labels for the owner. Empty fields are left out.
+func (o Owner) Labels() map[string]string {
+	labels := map[string]string{}
+
+	for key, value := range map[string]string{
+		OwnerKindLabel:      o.Kind,
+		OwnerNamespaceLabel: o.Namespace,
+		OwnerNameLabel:      o.Name,
+		OwnerUIDLabel:       o.UID,
+	} {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+
+	return labels
+}
+
+// String returns the owner in the form kind/namespace/name, or kind/name if it has
+// no namespace.
+func (o Owner) String() string {
+	if o.Namespace == "" {
+		return o.Kind + "/" + o.Name
+	}
+
+	return strings.Join([]string{o.Kind, o.Namespace, o.Name}, "/")
+}
@@ -0,0 +1,44 @@
+package microvm_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+func Test_OwnerLabels(t *testing.T) {
+	g := NewWithT(t)
+
+	owner := microvm.Owner{Kind: "Machine", Namespace: "default", Name: "worker-0", UID: "1234"}
+
+	labels := owner.Labels()
+	g.Expect(labels).To(Equal(map[string]string{
+		microvm.OwnerKindLabel:      "Machine",
+		microvm.OwnerNamespaceLabel: "default",
+		microvm.OwnerNameLabel:      "worker-0",
+		microvm.OwnerUIDLabel:       "1234",
+	}))
+
+	parsed, ok := microvm.OwnerFromLabels(labels)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(parsed).To(Equal(owner))
+	g.Expect(parsed.String()).To(Equal("Machine/default/worker-0"))
+
+	cluster := microvm.Owner{Kind: "Cluster", Name: "mgmt"}
+	g.Expect(cluster.Labels()).To(HaveLen(2))
+	g.Expect(cluster.String()).To(Equal("Cluster/mgmt"))
+
+	_, ok = microvm.OwnerFromLabels(map[string]string{microvm.OwnerNameLabel: "worker-0"})
+	g.Expect(ok).To(BeFalse())
+}
+
+func Test_IsReservedLabel(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(microvm.IsReservedLabel(microvm.ManagedByLabel)).To(BeTrue())
+	g.Expect(microvm.IsReservedLabel("liquidmetal.dev/anything")).To(BeTrue())
+	g.Expect(microvm.IsReservedLabel("topology.liquidmetal.dev/failure-domain")).To(BeFalse())
+	g.Expect(microvm.IsReservedLabel("app")).To(BeFalse())
+}
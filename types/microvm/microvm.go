@@ -16,6 +16,8 @@ limitations under the License.
 
 package microvm
 
+import "k8s.io/apimachinery/pkg/api/resource"
+
 // VMSpec holds the configuration for the MicroVM which will be passed
 // directy to firecracker.
 type VMSpec struct {
@@ -26,15 +28,27 @@ type VMSpec struct {
 	// +optional
 	Provider string `json:"provider,omitempty"`
 
-	// VCPU specifies how many vcpu's the microvm will be allocated.
-	// +kubebuilder:validation:Required
+	// InstanceType is the name of an instance type which sets the vcpu and memory
+	// of the microvm. Setting vcpu or memory explicitly overrides the instance type.
+	// +optional
+	InstanceType string `json:"instanceType,omitempty"`
+
+	// VCPU specifies how many vcpu's the microvm will be allocated. It is required
+	// unless an instance type is set.
 	// +kubebuilder:validation:Minimum:=1
-	VCPU int64 `json:"vcpu"`
+	// +optional
+	VCPU int64 `json:"vcpu,omitempty"`
 
-	// MemoryMb is the amount of memory in megabytes that the microvm will be allocated.
-	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:Minimum:=1024
-	MemoryMb int64 `json:"memoryMb"`
+	// Memory is the amount of memory the microvm will be allocated, as a quantity
+	// such as 4Gi. It is rounded up to a whole number of mebibytes. Either memory
+	// or memoryMb must be set, unless an instance type is set.
+	// +optional
+	Memory *resource.Quantity `json:"memory,omitempty"`
+
+	// MemoryMb is the amount of memory in mebibytes that the microvm will be allocated.
+	// Deprecated: use Memory instead. If both are set they must be equal.
+	// +optional
+	MemoryMb int64 `json:"memoryMb,omitempty"`
 
 	// RootVolume specifies the volume to use for the root of the microvm.
 	// +kubebuilder:validation:Required
@@ -50,8 +64,13 @@ type VMSpec struct {
 
 	// KernelCmdLine are the additional args to use for the kernel cmdline.
 	// Each MicroVM provider has its own recommended list, they will be used
-	// automatically. This field is for additional values.
-	KernelCmdLine map[string]string `json:"kernelCmdline,omitempty"`
+	// automatically. This field is for additional values. It is a list of args,
+	// such as ["console=ttyS0", "quiet"], but the map of name to value used by
+	// earlier versions is still accepted.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	KernelCmdLine KernelCmdLine `json:"kernelCmdline,omitempty"`
 
 	// Initrd is an optional initial ramdisk to use.
 	// +optional
@@ -65,6 +84,10 @@ type VMSpec struct {
 	// Labels allow you to include extra data on the Microvm
 	// +optional
 	Labels map[string]string `json:"labels"`
+
+	// Placement controls which hosts the microvm may be placed on.
+	// +optional
+	Placement *PlacementSpec `json:"placement,omitempty"`
 }
 
 // ContainerFileSource represents a file coming from a container image.
@@ -98,6 +121,13 @@ type Volume struct {
 	VirtioFSPath string `json:"virtiofsPath,omitempty"`
 }
 
+const (
+	// ProviderFirecracker is the name of the firecracker microvm provider.
+	ProviderFirecracker = "firecracker"
+	// ProviderCloudHypervisor is the name of the cloud-hypervisor microvm provider.
+	ProviderCloudHypervisor = "cloudhypervisor"
+)
+
 // IfaceType is a type representing the network interface types.
 type IfaceType string
 
@@ -141,16 +171,6 @@ var (
 	VMStateUnknown = VMState("unknown")
 )
 
-type Host struct {
-	// Name is an optional name for the host.
-	// +optional
-	Name string `json:"name,omitempty"`
-	// Endpoint is the API endpoint for the microvm service (i.e. flintlock)
-	// including the port.
-	// +kubebuilder:validation:Required
-	Endpoint string `json:"endpoint"`
-}
-
 type SSHPublicKey struct {
 	// User is the name of the user to add keys for (eg root, ubuntu).
 	// +kubebuilder:validation:Required
@@ -0,0 +1,88 @@
+/*
+Copyright 2022 Weaveworks.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microvm
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+const (
+	// ZoneLabel is the host label holding the zone of the host.
+	ZoneLabel = "topology.kubernetes.io/zone"
+	// FailureDomainLabel is the host label holding the failure domain of the host,
+	// such as a rack or power domain.
+	FailureDomainLabel = "topology.liquidmetal.dev/failure-domain"
+	// HostnameLabel is a topology key which makes every host its own domain. Hosts
+	// don't need to set it.
+	HostnameLabel = "kubernetes.io/hostname"
+)
+
+// UnsatisfiableConstraintAction is what to do when a spread constraint can't be met.
+type UnsatisfiableConstraintAction string
+
+const (
+	// DoNotSchedule won't place the microvm if the constraint can't be met.
+	DoNotSchedule = UnsatisfiableConstraintAction("DoNotSchedule")
+	// ScheduleAnyway prefers hosts which best meet the constraint, but will place
+	// the microvm even if none do.
+	ScheduleAnyway = UnsatisfiableConstraintAction("ScheduleAnyway")
+)
+
+// PlacementSpec controls which hosts a microvm may be placed on. It is used when
+// choosing a host and isn't sent to flintlock.
+type PlacementSpec struct {
+	// TopologySpreadConstraints spread microvms matching a selector across the
+	// domains of a topology, such as zones.
+	// +optional
+	TopologySpreadConstraints []TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	// AntiAffinity prevents the microvm being placed in the same domain as other
+	// microvms.
+	// +optional
+	AntiAffinity []AntiAffinityTerm `json:"antiAffinity,omitempty"`
+}
+
+// TopologySpreadConstraint limits how unevenly the microvms matching the label
+// selector may be spread across the domains of a topology.
+type TopologySpreadConstraint struct {
+	// MaxSkew is the most the number of matching microvms in any domain may exceed
+	// the number in the domain with the fewest.
+	// +kubebuilder:validation:Minimum:=1
+	// +kubebuilder:default:=1
+	MaxSkew int32 `json:"maxSkew"`
+	// TopologyKey is the host label whose values are the domains, such as ZoneLabel.
+	// Hosts without the label can't be used.
+	// +kubebuilder:validation:Required
+	TopologyKey string `json:"topologyKey"`
+	// WhenUnsatisfiable is what to do when the constraint can't be met.
+	// +kubebuilder:validation:Enum=DoNotSchedule;ScheduleAnyway
+	// +kubebuilder:default:=DoNotSchedule
+	// +optional
+	WhenUnsatisfiable UnsatisfiableConstraintAction `json:"whenUnsatisfiable,omitempty"`
+	// LabelSelector selects the microvms to count, by the labels sent to flintlock.
+	// +kubebuilder:validation:Required
+	LabelSelector *metav1.LabelSelector `json:"labelSelector"`
+}
+
+// AntiAffinityTerm prevents a microvm being placed in a domain which already has a
+// microvm matching the label selector.
+type AntiAffinityTerm struct {
+	// TopologyKey is the host label whose values are the domains. If empty, or
+	// HostnameLabel, each host is a domain.
+	// +optional
+	TopologyKey string `json:"topologyKey,omitempty"`
+	// LabelSelector selects the microvms to avoid, by the labels sent to flintlock.
+	// +kubebuilder:validation:Required
+	LabelSelector *metav1.LabelSelector `json:"labelSelector"`
+}
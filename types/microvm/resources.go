@@ -0,0 +1,44 @@
+/*
+Copyright 2022 Weaveworks.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microvm
+
+import "k8s.io/apimachinery/pkg/api/resource"
+
+// Mebibyte is the number of bytes in a mebibyte, the unit flintlock uses for memory.
+const Mebibyte = 1 << 20
+
+// MemoryMiB returns the memory of the microvm in mebibytes. Memory takes precedence
+// over the deprecated MemoryMb, and is rounded up to a whole mebibyte.
+func (s *VMSpec) MemoryMiB() int64 {
+	if s.Memory == nil {
+		return s.MemoryMb
+	}
+
+	return quantityMiB(s.Memory)
+}
+
+// quantityMiB returns the quantity in mebibytes, rounded up.
+func quantityMiB(q *resource.Quantity) int64 {
+	bytes := q.Value()
+
+	mib := bytes / Mebibyte
+	if bytes%Mebibyte > 0 {
+		mib++
+	}
+
+	return mib
+}
@@ -0,0 +1,42 @@
+package microvm_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+func Test_MemoryMiB(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name     string
+		spec     microvm.VMSpec
+		expected int64
+	}{
+		{
+			name:     "memoryMb",
+			spec:     microvm.VMSpec{MemoryMb: 2048},
+			expected: 2048,
+		},
+		{
+			name:     "binary quantity",
+			spec:     microvm.VMSpec{MemoryMb: 2048, Memory: resource.NewQuantity(4*1024*microvm.Mebibyte, resource.BinarySI)},
+			expected: 4096,
+		},
+		{
+			name:     "decimal quantity is rounded up",
+			spec:     microvm.VMSpec{Memory: resource.NewQuantity(4*1000*1000*1000, resource.DecimalSI)},
+			expected: 3815,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			g.Expect(tc.spec.MemoryMiB()).To(Equal(tc.expected))
+		})
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2022 Weaveworks.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"net"
+
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	k8svalidation "k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+var (
+	supportedProviders    = []string{microvm.ProviderFirecracker, microvm.ProviderCloudHypervisor}
+	supportedTaintEffects = []string{string(microvm.TaintEffectNoSchedule), string(microvm.TaintEffectNoExecute)}
+)
+
+// ValidateHost validates the host, returning errors with paths relative to fldPath.
+func ValidateHost(host *microvm.Host, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if host.Endpoint == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("endpoint"), ""))
+	} else if _, _, err := net.SplitHostPort(host.Endpoint); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("endpoint"), host.Endpoint, err.Error()))
+	}
+
+	allErrs = append(allErrs, metav1validation.ValidateLabels(host.Labels, fldPath.Child("labels"))...)
+	allErrs = append(allErrs, validateTaints(host.Taints, fldPath.Child("taints"))...)
+
+	if host.Allocatable != nil {
+		allErrs = append(allErrs, validateHostResources(host.Allocatable, fldPath.Child("allocatable"))...)
+	}
+
+	if host.PreferredProvider != "" && !isSupportedProvider(host.PreferredProvider) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("preferredProvider"), host.PreferredProvider, supportedProviders))
+	}
+
+	if host.AuthSecretRef != nil {
+		allErrs = append(allErrs, validateSecretReference(host.AuthSecretRef, fldPath.Child("authSecretRef"))...)
+	}
+
+	if host.TLSSecretRef != nil {
+		allErrs = append(allErrs, validateSecretReference(host.TLSSecretRef, fldPath.Child("tlsSecretRef"))...)
+	}
+
+	return allErrs
+}
+
+func validateTaints(taints []microvm.Taint, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	seen := map[microvm.Taint]bool{}
+
+	for i, taint := range taints {
+		idxPath := fldPath.Index(i)
+
+		for _, msg := range k8svalidation.IsQualifiedName(taint.Key) {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("key"), taint.Key, msg))
+		}
+
+		for _, msg := range k8svalidation.IsValidLabelValue(taint.Value) {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("value"), taint.Value, msg))
+		}
+
+		switch taint.Effect {
+		case microvm.TaintEffectNoSchedule, microvm.TaintEffectNoExecute:
+		case "":
+			allErrs = append(allErrs, field.Required(idxPath.Child("effect"), ""))
+		default:
+			allErrs = append(allErrs, field.NotSupported(idxPath.Child("effect"), taint.Effect, supportedTaintEffects))
+		}
+
+		key := microvm.Taint{Key: taint.Key, Effect: taint.Effect}
+		if seen[key] {
+			allErrs = append(allErrs, field.Duplicate(idxPath, taint.String()))
+		}
+
+		seen[key] = true
+	}
+
+	return allErrs
+}
+
+func validateHostResources(res *microvm.HostResources, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if res.VCPU < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("vcpu"), res.VCPU, "must not be negative"))
+	}
+
+	if res.Memory != nil && res.Memory.Sign() < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("memory"), res.Memory.String(), "must not be negative"))
+	}
+
+	return allErrs
+}
+
+func validateSecretReference(ref *microvm.SecretReference, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if ref.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	} else {
+		for _, msg := range k8svalidation.IsDNS1123Subdomain(ref.Name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), ref.Name, msg))
+		}
+	}
+
+	if ref.Namespace != "" {
+		for _, msg := range k8svalidation.IsDNS1123Label(ref.Namespace) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("namespace"), ref.Namespace, msg))
+		}
+	}
+
+	return allErrs
+}
+
+func isSupportedProvider(provider string) bool {
+	for _, supported := range supportedProviders {
+		if provider == supported {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+package validation
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+func validHost() microvm.Host {
+	memory := resource.MustParse("64Gi")
+
+	return microvm.Host{
+		Name:              "host1",
+		Endpoint:          "10.0.0.1:9090",
+		Labels:            map[string]string{microvm.ZoneLabel: "a"},
+		Taints:            []microvm.Taint{{Key: "maintenance", Effect: microvm.TaintEffectNoSchedule}},
+		Allocatable:       &microvm.HostResources{VCPU: 32, Memory: &memory},
+		PreferredProvider: microvm.ProviderCloudHypervisor,
+		AuthSecretRef:     &microvm.SecretReference{Name: "host1-auth"},
+		TLSSecretRef:      &microvm.SecretReference{Name: "host1-tls", Namespace: "infra"},
+	}
+}
+
+func Test_ValidateHost(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name     string
+		mutate   func(*microvm.Host)
+		expected []string
+	}{
+		{
+			name:     "valid",
+			mutate:   func(*microvm.Host) {},
+			expected: []string{},
+		},
+		{
+			name:     "missing endpoint",
+			mutate:   func(h *microvm.Host) { h.Endpoint = "" },
+			expected: []string{"host.endpoint"},
+		},
+		{
+			name:     "endpoint without port",
+			mutate:   func(h *microvm.Host) { h.Endpoint = "10.0.0.1" },
+			expected: []string{"host.endpoint"},
+		},
+		{
+			name: "taints",
+			mutate: func(h *microvm.Host) {
+				h.Taints = []microvm.Taint{
+					{Key: "maintenance", Effect: microvm.TaintEffectNoExecute},
+					{Key: "maintenance", Value: "kernel", Effect: microvm.TaintEffectNoExecute},
+					{Key: "bad key", Effect: "Evict"},
+					{Key: "noeffect"},
+				}
+			},
+			expected: []string{"host.taints[1]", "host.taints[2].key", "host.taints[2].effect", "host.taints[3].effect"},
+		},
+		{
+			name: "allocatable",
+			mutate: func(h *microvm.Host) {
+				memory := resource.MustParse("-1Gi")
+				h.Allocatable = &microvm.HostResources{VCPU: -1, Memory: &memory}
+			},
+			expected: []string{"host.allocatable.vcpu", "host.allocatable.memory"},
+		},
+		{
+			name:     "provider",
+			mutate:   func(h *microvm.Host) { h.PreferredProvider = "qemu" },
+			expected: []string{"host.preferredProvider"},
+		},
+		{
+			name: "secret references",
+			mutate: func(h *microvm.Host) {
+				h.AuthSecretRef = &microvm.SecretReference{}
+				h.TLSSecretRef = &microvm.SecretReference{Name: "Host1", Namespace: "infra.ns"}
+			},
+			expected: []string{"host.authSecretRef.name", "host.tlsSecretRef.name", "host.tlsSecretRef.namespace"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			host := validHost()
+			tc.mutate(&host)
+
+			errs := ValidateHost(&host, field.NewPath("host"))
+
+			g.Expect(fields(errs)).To(Equal(tc.expected))
+		})
+	}
+}
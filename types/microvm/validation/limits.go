@@ -0,0 +1,86 @@
+/*
+Copyright 2022 Weaveworks.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+// ErrUnsupportedProvider is returned for a microvm provider flintlock doesn't support.
+var ErrUnsupportedProvider = errors.New("unsupported provider")
+
+// ProviderLimits are the resources a microvm provider supports.
+type ProviderLimits struct {
+	// MinMemoryMiB is the minimum memory of a microvm, in mebibytes.
+	MinMemoryMiB int64
+	// MaxVCPU is the maximum number of vcpus of a microvm.
+	MaxVCPU int64
+}
+
+// DefaultProviderLimits returns the limits of the providers flintlock supports.
+func DefaultProviderLimits() map[string]ProviderLimits {
+	return map[string]ProviderLimits{
+		microvm.ProviderFirecracker: {
+			MinMemoryMiB: 1024,
+			MaxVCPU:      32,
+		},
+		microvm.ProviderCloudHypervisor: {
+			MinMemoryMiB: 1024,
+			MaxVCPU:      254,
+		},
+	}
+}
+
+// LimitsForProvider returns the limits of the provider. A spec without a provider
+// uses the host's default provider, which isn't known, so the limits are the
+// strictest of all the providers. Unknown providers return ErrUnsupportedProvider
+// along with the strictest limits.
+func LimitsForProvider(provider string) (ProviderLimits, error) {
+	providerLimits := DefaultProviderLimits()
+
+	if provider == "" {
+		return strictestLimits(providerLimits), nil
+	}
+
+	if limits, ok := providerLimits[provider]; ok {
+		return limits, nil
+	}
+
+	return strictestLimits(providerLimits), fmt.Errorf("%w: %q", ErrUnsupportedProvider, provider)
+}
+
+// strictestLimits returns the highest minimum memory and lowest maximum vcpus of the
+// limits.
+func strictestLimits(providerLimits map[string]ProviderLimits) ProviderLimits {
+	limits := ProviderLimits{MinMemoryMiB: 1, MaxVCPU: math.MaxInt32}
+
+	for _, providerLimit := range providerLimits {
+		if providerLimit.MinMemoryMiB > limits.MinMemoryMiB {
+			limits.MinMemoryMiB = providerLimit.MinMemoryMiB
+		}
+
+		if providerLimit.MaxVCPU < limits.MaxVCPU {
+			limits.MaxVCPU = providerLimit.MaxVCPU
+		}
+	}
+
+	return limits
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 Weaveworks.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+// UpdateAction is how a change to a field of a VMSpec can be applied.
+type UpdateAction string
+
+const (
+	// UpdateActionInPlace is a change which can be applied to the existing microvm.
+	UpdateActionInPlace = UpdateAction("InPlace")
+	// UpdateActionRecreate is a change which can only be applied by replacing the microvm.
+	UpdateActionRecreate = UpdateAction("Recreate")
+	// UpdateActionForbidden is a change which can't be applied.
+	UpdateActionForbidden = UpdateAction("Forbidden")
+)
+
+// FieldChange is a change to a field of a VMSpec.
+type FieldChange struct {
+	// Path is the path of the changed field.
+	Path *field.Path
+	// Action is how the change can be applied.
+	Action UpdateAction
+	// Old is the previous value of the field.
+	Old interface{}
+	// New is the updated value of the field.
+	New interface{}
+}
+
+// UpdateChanges are the changes between two VMSpecs.
+type UpdateChanges []FieldChange
+
+// ValidateUpdate compares the old and new specs and classifies each changed field.
+// Flintlock can't update a microvm, so apart from the labels, and the placement which
+// only applies when choosing a host, every change requires the microvm to be
+// recreated. Changing the provider is forbidden. An unset provider is chosen by the
+// flintlock host, so it may differ from any provider, and setting or clearing the
+// provider is also forbidden.
+func ValidateUpdate(oldSpec, newSpec *microvm.VMSpec, fldPath *field.Path) UpdateChanges {
+	changes := UpdateChanges{}
+
+	add := func(path *field.Path, action UpdateAction, oldVal, newVal interface{}) {
+		if !equality.Semantic.DeepEqual(oldVal, newVal) {
+			changes = append(changes, FieldChange{Path: path, Action: action, Old: oldVal, New: newVal})
+		}
+	}
+
+	add(fldPath.Child("provider"), UpdateActionForbidden, oldSpec.Provider, newSpec.Provider)
+	add(fldPath.Child("instanceType"), UpdateActionRecreate, oldSpec.InstanceType, newSpec.InstanceType)
+	add(fldPath.Child("vcpu"), UpdateActionRecreate, oldSpec.VCPU, newSpec.VCPU)
+
+	// Compare the memory in mebibytes, so switching between memoryMb and memory
+	// isn't a change.
+	memPath := fldPath.Child("memoryMb")
+	if oldSpec.Memory != nil || newSpec.Memory != nil {
+		memPath = fldPath.Child("memory")
+	}
+
+	add(memPath, UpdateActionRecreate, oldSpec.MemoryMiB(), newSpec.MemoryMiB())
+
+	add(fldPath.Child("rootVolume"), UpdateActionRecreate, oldSpec.RootVolume, newSpec.RootVolume)
+
+	volPath := fldPath.Child("volumes")
+	if len(oldSpec.AdditionalVolumes) != len(newSpec.AdditionalVolumes) {
+		add(volPath, UpdateActionRecreate, oldSpec.AdditionalVolumes, newSpec.AdditionalVolumes)
+	} else {
+		for i := range newSpec.AdditionalVolumes {
+			add(volPath.Index(i), UpdateActionRecreate, oldSpec.AdditionalVolumes[i], newSpec.AdditionalVolumes[i])
+		}
+	}
+
+	add(fldPath.Child("kernel"), UpdateActionRecreate, oldSpec.Kernel, newSpec.Kernel)
+	add(fldPath.Child("kernelCmdline"), UpdateActionRecreate, oldSpec.KernelCmdLine, newSpec.KernelCmdLine)
+	add(fldPath.Child("initrd"), UpdateActionRecreate, oldSpec.Initrd, newSpec.Initrd)
+
+	ifacePath := fldPath.Child("networkInterfaces")
+	if len(oldSpec.NetworkInterfaces) != len(newSpec.NetworkInterfaces) {
+		add(ifacePath, UpdateActionRecreate, oldSpec.NetworkInterfaces, newSpec.NetworkInterfaces)
+	} else {
+		for i := range newSpec.NetworkInterfaces {
+			add(ifacePath.Index(i), UpdateActionRecreate, oldSpec.NetworkInterfaces[i], newSpec.NetworkInterfaces[i])
+		}
+	}
+
+	add(fldPath.Child("labels"), UpdateActionInPlace, oldSpec.Labels, newSpec.Labels)
+	add(fldPath.Child("placement"), UpdateActionInPlace, oldSpec.Placement, newSpec.Placement)
+
+	return changes
+}
+
+// WithAction returns the changes which have the action.
+func (c UpdateChanges) WithAction(action UpdateAction) UpdateChanges {
+	filtered := UpdateChanges{}
+
+	for _, change := range c {
+		if change.Action == action {
+			filtered = append(filtered, change)
+		}
+	}
+
+	return filtered
+}
+
+// RequiresRecreate returns true if any of the changes require the microvm to be recreated.
+func (c UpdateChanges) RequiresRecreate() bool {
+	return len(c.WithAction(UpdateActionRecreate)) > 0
+}
+
+// Forbidden returns true if any of the changes are forbidden.
+func (c UpdateChanges) Forbidden() bool {
+	return len(c.WithAction(UpdateActionForbidden)) > 0
+}
+
+// ToErrorList returns an error for each forbidden change, and for each change
+// requiring a recreate unless allowRecreate is set. It is intended for use by
+// admission webhooks.
+func (c UpdateChanges) ToErrorList(allowRecreate bool) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	for _, change := range c {
+		switch change.Action {
+		case UpdateActionForbidden:
+			allErrs = append(allErrs, field.Forbidden(change.Path, "field is immutable"))
+		case UpdateActionRecreate:
+			if !allowRecreate {
+				allErrs = append(allErrs, field.Forbidden(change.Path, "field can only be changed by recreating the microvm"))
+			}
+		case UpdateActionInPlace:
+		}
+	}
+
+	return allErrs
+}
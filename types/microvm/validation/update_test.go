@@ -0,0 +1,141 @@
+package validation
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+func Test_ValidateUpdate(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name      string
+		mutate    func(*microvm.VMSpec)
+		expected  map[string]UpdateAction
+		recreate  bool
+		forbidden bool
+	}{
+		{
+			name:     "no changes",
+			mutate:   func(*microvm.VMSpec) {},
+			expected: map[string]UpdateAction{},
+		},
+		{
+			name: "nil and empty are equal",
+			mutate: func(s *microvm.VMSpec) {
+				s.KernelCmdLine = microvm.KernelCmdLine{}
+			},
+			expected: map[string]UpdateAction{},
+		},
+		{
+			name: "setting an unset provider",
+			mutate: func(s *microvm.VMSpec) {
+				s.Provider = microvm.ProviderFirecracker
+			},
+			expected:  map[string]UpdateAction{"spec.provider": UpdateActionForbidden},
+			forbidden: true,
+		},
+		{
+			name: "switching to the memory quantity",
+			mutate: func(s *microvm.VMSpec) {
+				s.MemoryMb = 0
+				s.Memory = resource.NewQuantity(2*microvm.Mebibyte*1024, resource.BinarySI)
+			},
+			expected: map[string]UpdateAction{},
+		},
+		{
+			name: "memory quantity",
+			mutate: func(s *microvm.VMSpec) {
+				s.Memory = resource.NewQuantity(3*microvm.Mebibyte*1024, resource.BinarySI)
+			},
+			expected: map[string]UpdateAction{"spec.memory": UpdateActionRecreate},
+			recreate: true,
+		},
+		{
+			name: "labels",
+			mutate: func(s *microvm.VMSpec) {
+				s.Labels = map[string]string{"app": "bar"}
+			},
+			expected: map[string]UpdateAction{"spec.labels": UpdateActionInPlace},
+		},
+		{
+			name: "placement",
+			mutate: func(s *microvm.VMSpec) {
+				s.Placement = &microvm.PlacementSpec{
+					AntiAffinity: []microvm.AntiAffinityTerm{{LabelSelector: &metav1.LabelSelector{}}},
+				}
+			},
+			expected: map[string]UpdateAction{"spec.placement": UpdateActionInPlace},
+		},
+		{
+			name: "resources and kernel",
+			mutate: func(s *microvm.VMSpec) {
+				s.VCPU = 4
+				s.MemoryMb = 4096
+				s.Kernel.Image = "docker.io/kernel:v2"
+				s.KernelCmdLine = microvm.KernelCmdLine{{Name: "quiet"}}
+			},
+			expected: map[string]UpdateAction{
+				"spec.vcpu":          UpdateActionRecreate,
+				"spec.memoryMb":      UpdateActionRecreate,
+				"spec.kernel":        UpdateActionRecreate,
+				"spec.kernelCmdline": UpdateActionRecreate,
+			},
+			recreate: true,
+		},
+		{
+			name: "volumes and interfaces",
+			mutate: func(s *microvm.VMSpec) {
+				s.AdditionalVolumes[1].ReadOnly = true
+				s.NetworkInterfaces = s.NetworkInterfaces[:1]
+			},
+			expected: map[string]UpdateAction{
+				"spec.volumes[1]":        UpdateActionRecreate,
+				"spec.networkInterfaces": UpdateActionRecreate,
+			},
+			recreate: true,
+		},
+		{
+			name: "provider",
+			mutate: func(s *microvm.VMSpec) {
+				s.Provider = microvm.ProviderCloudHypervisor
+			},
+			expected:  map[string]UpdateAction{"spec.provider": UpdateActionForbidden},
+			forbidden: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			oldSpec := validSpec()
+			newSpec := validSpec()
+			tc.mutate(&newSpec)
+
+			changes := ValidateUpdate(&oldSpec, &newSpec, field.NewPath("spec"))
+
+			actions := map[string]UpdateAction{}
+			for _, change := range changes {
+				actions[change.Path.String()] = change.Action
+			}
+
+			g.Expect(actions).To(Equal(tc.expected))
+			g.Expect(changes.RequiresRecreate()).To(Equal(tc.recreate))
+			g.Expect(changes.Forbidden()).To(Equal(tc.forbidden))
+
+			if tc.forbidden {
+				g.Expect(changes.ToErrorList(true)).NotTo(BeEmpty())
+			}
+
+			if tc.recreate {
+				g.Expect(changes.ToErrorList(true)).To(BeEmpty())
+				g.Expect(changes.ToErrorList(false)).To(HaveLen(len(tc.expected)))
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+/*
+Copyright 2022 Weaveworks.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	k8svalidation "k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+const (
+	minVCPU   = 1
+	macLength = 6
+)
+
+var supportedIfaceTypes = []string{microvm.IfaceTypeMacvtap, microvm.IfaceTypeTap}
+
+// ValidateVMSpec validates the spec, returning errors with paths relative to fldPath.
+func ValidateVMSpec(spec *microvm.VMSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	allErrs = append(allErrs, validateResources(spec, fldPath)...)
+	allErrs = append(allErrs, validateRootVolume(&spec.RootVolume, fldPath.Child("rootVolume"))...)
+	allErrs = append(allErrs, validateAdditionalVolumes(spec, fldPath.Child("volumes"))...)
+	allErrs = append(allErrs, validateContainerFileSource(&spec.Kernel, fldPath.Child("kernel"))...)
+	allErrs = append(allErrs, validateKernelCmdLine(spec.KernelCmdLine, fldPath.Child("kernelCmdline"))...)
+
+	if spec.Initrd != nil {
+		allErrs = append(allErrs, validateContainerFileSource(spec.Initrd, fldPath.Child("initrd"))...)
+	}
+
+	allErrs = append(allErrs, validateNetworkInterfaces(spec.NetworkInterfaces, fldPath.Child("networkInterfaces"))...)
+	allErrs = append(allErrs, validateLabels(spec.Labels, fldPath.Child("labels"))...)
+
+	if spec.Placement != nil {
+		allErrs = append(allErrs, validatePlacement(spec.Placement, fldPath.Child("placement"))...)
+	}
+
+	return allErrs
+}
+
+func validateResources(spec *microvm.VMSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	limits, err := LimitsForProvider(spec.Provider)
+	if err != nil {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("provider"), spec.Provider, supportedProviders))
+	}
+
+	if spec.InstanceType != "" {
+		for _, msg := range k8svalidation.IsDNS1123Subdomain(spec.InstanceType) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("instanceType"), spec.InstanceType, msg))
+		}
+	}
+
+	// Resources left unset are supplied by the instance type once it is resolved.
+	resolvedVCPU := spec.InstanceType == "" || spec.VCPU != 0
+	resolvedMemory := spec.InstanceType == "" || spec.Memory != nil || spec.MemoryMb != 0
+
+	switch {
+	case !resolvedVCPU:
+	case spec.VCPU < minVCPU:
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("vcpu"), spec.VCPU, "must be at least 1"))
+	case spec.VCPU > limits.MaxVCPU:
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("vcpu"), spec.VCPU,
+			fmt.Sprintf("must be at most %d for the provider", limits.MaxVCPU)))
+	}
+
+	memPath := fldPath.Child("memoryMb")
+	memValue := interface{}(spec.MemoryMb)
+
+	if spec.Memory != nil {
+		memPath = fldPath.Child("memory")
+		memValue = spec.Memory.String()
+
+		if spec.MemoryMb != 0 && spec.MemoryMb != spec.MemoryMiB() {
+			allErrs = append(allErrs, field.Invalid(memPath, memValue, "must equal memoryMb when both are set"))
+		}
+	}
+
+	switch memory := spec.MemoryMiB(); {
+	case !resolvedMemory:
+	case memory < limits.MinMemoryMiB:
+		allErrs = append(allErrs, field.Invalid(memPath, memValue,
+			fmt.Sprintf("must be at least %dMi for the provider", limits.MinMemoryMiB)))
+	case memory > math.MaxInt32:
+		allErrs = append(allErrs, field.Invalid(memPath, memValue,
+			fmt.Sprintf("must be at most %dMi", math.MaxInt32)))
+	}
+
+	return allErrs
+}
+
+func validateRootVolume(vol *microvm.Volume, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if vol.ID == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("id"), ""))
+	}
+
+	if vol.Image == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("image"), ""))
+	}
+
+	if vol.MountPoint != "" {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("mountPoint"), "only supported on additional volumes"))
+	}
+
+	if vol.VirtioFSPath != "" {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("virtiofsPath"), "only supported on additional volumes"))
+	}
+
+	return allErrs
+}
+
+func validateAdditionalVolumes(spec *microvm.VMSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	ids := map[string]bool{spec.RootVolume.ID: true}
+
+	for i := range spec.AdditionalVolumes {
+		vol := spec.AdditionalVolumes[i]
+		idxPath := fldPath.Index(i)
+
+		switch {
+		case vol.ID == "":
+			allErrs = append(allErrs, field.Required(idxPath.Child("id"), ""))
+		case ids[vol.ID]:
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("id"), vol.ID))
+		default:
+			ids[vol.ID] = true
+		}
+
+		switch {
+		case vol.Image == "" && vol.VirtioFSPath == "":
+			allErrs = append(allErrs, field.Required(idxPath, "one of image or virtiofsPath must be set"))
+		case vol.Image != "" && vol.VirtioFSPath != "":
+			allErrs = append(allErrs, field.Forbidden(idxPath.Child("virtiofsPath"), "may not be set with image"))
+		}
+	}
+
+	return allErrs
+}
+
+func validateContainerFileSource(src *microvm.ContainerFileSource, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if src.Image == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("image"), ""))
+	}
+
+	return allErrs
+}
+
+func validateKernelCmdLine(cmdline microvm.KernelCmdLine, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	for i, arg := range cmdline {
+		idxPath := fldPath.Index(i)
+
+		switch {
+		case arg.Name == "":
+			allErrs = append(allErrs, field.Required(idxPath, "name must not be empty"))
+		case strings.ContainsAny(arg.Name, "=\" \t\n"):
+			allErrs = append(allErrs, field.Invalid(idxPath, arg.String(), "name must not contain whitespace, '=' or '\"'"))
+		}
+
+		if strings.Contains(arg.Value, `"`) {
+			allErrs = append(allErrs, field.Invalid(idxPath, arg.String(), "value must not contain '\"'"))
+		}
+	}
+
+	return allErrs
+}
+
+func validateNetworkInterfaces(ifaces []microvm.NetworkInterface, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if len(ifaces) == 0 {
+		return append(allErrs, field.Required(fldPath, "at least one network interface is required"))
+	}
+
+	names := map[string]bool{}
+	macs := map[string]bool{}
+
+	for i := range ifaces {
+		iface := ifaces[i]
+		idxPath := fldPath.Index(i)
+
+		switch {
+		case iface.GuestDeviceName == "":
+			allErrs = append(allErrs, field.Required(idxPath.Child("guestDeviceName"), ""))
+		case names[iface.GuestDeviceName]:
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("guestDeviceName"), iface.GuestDeviceName))
+		default:
+			names[iface.GuestDeviceName] = true
+		}
+
+		if iface.GuestMAC != "" {
+			mac, err := net.ParseMAC(iface.GuestMAC)
+
+			switch {
+			case err != nil || len(mac) != macLength:
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("guestMac"), iface.GuestMAC, "must be a valid MAC address"))
+			case mac[0]&1 == 1:
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("guestMac"), iface.GuestMAC, "must be a unicast MAC address"))
+			case macs[mac.String()]:
+				allErrs = append(allErrs, field.Duplicate(idxPath.Child("guestMac"), iface.GuestMAC))
+			default:
+				macs[mac.String()] = true
+			}
+		}
+
+		if !isSupportedIfaceType(iface.Type) {
+			allErrs = append(allErrs, field.NotSupported(idxPath.Child("type"), iface.Type, supportedIfaceTypes))
+		}
+
+		if iface.Address != "" {
+			if _, _, err := net.ParseCIDR(iface.Address); err != nil {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("address"), iface.Address, "must be an IP address in CIDR form"))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+func isSupportedIfaceType(t microvm.IfaceType) bool {
+	for _, supported := range supportedIfaceTypes {
+		if string(t) == supported {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateLabels(labels map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := metav1validation.ValidateLabels(labels, fldPath)
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if microvm.IsReservedLabel(key) {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Key(key),
+				fmt.Sprintf("the %s prefix is reserved", microvm.LabelPrefix)))
+		}
+	}
+
+	return allErrs
+}
+
+func validatePlacement(placement *microvm.PlacementSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	selectorOpts := metav1validation.LabelSelectorValidationOptions{}
+
+	for i, constraint := range placement.TopologySpreadConstraints {
+		idxPath := fldPath.Child("topologySpreadConstraints").Index(i)
+
+		if constraint.MaxSkew < 1 {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("maxSkew"), constraint.MaxSkew, "must be at least 1"))
+		}
+
+		if constraint.TopologyKey == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("topologyKey"), ""))
+		}
+
+		switch constraint.WhenUnsatisfiable {
+		case "", microvm.DoNotSchedule, microvm.ScheduleAnyway:
+		default:
+			allErrs = append(allErrs, field.NotSupported(idxPath.Child("whenUnsatisfiable"), constraint.WhenUnsatisfiable,
+				[]string{string(microvm.DoNotSchedule), string(microvm.ScheduleAnyway)}))
+		}
+
+		allErrs = append(allErrs, validateLabelSelector(constraint.LabelSelector, selectorOpts, idxPath.Child("labelSelector"))...)
+	}
+
+	for i, term := range placement.AntiAffinity {
+		idxPath := fldPath.Child("antiAffinity").Index(i)
+
+		allErrs = append(allErrs, validateLabelSelector(term.LabelSelector, selectorOpts, idxPath.Child("labelSelector"))...)
+	}
+
+	return allErrs
+}
+
+func validateLabelSelector(
+	selector *metav1.LabelSelector,
+	opts metav1validation.LabelSelectorValidationOptions,
+	fldPath *field.Path,
+) field.ErrorList {
+	if selector == nil {
+		return field.ErrorList{field.Required(fldPath, "")}
+	}
+
+	return metav1validation.ValidateLabelSelector(selector, opts, fldPath)
+}
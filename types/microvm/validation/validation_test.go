@@ -0,0 +1,269 @@
+package validation
+
+import (
+	"math"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+func validSpec() microvm.VMSpec {
+	return microvm.VMSpec{
+		VCPU:       2,
+		MemoryMb:   2048,
+		RootVolume: microvm.Volume{ID: "root", Image: "docker.io/os:latest"},
+		AdditionalVolumes: []microvm.Volume{
+			{ID: "data", Image: "docker.io/data:latest", MountPoint: "/data"},
+			{ID: "shared", VirtioFSPath: "/mnt/shared"},
+		},
+		Kernel: microvm.ContainerFileSource{Image: "docker.io/kernel:latest", Filename: "boot/vmlinux"},
+		NetworkInterfaces: []microvm.NetworkInterface{
+			{GuestDeviceName: "eth0", Type: microvm.IfaceTypeMacvtap},
+			{GuestDeviceName: "eth1", Type: microvm.IfaceTypeTap, GuestMAC: "02:00:00:00:00:01", Address: "10.0.0.2/24"},
+		},
+		Labels: map[string]string{"app": "foo"},
+	}
+}
+
+func Test_ValidateVMSpec(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		name     string
+		mutate   func(*microvm.VMSpec)
+		expected []string
+	}{
+		{
+			name:     "valid",
+			mutate:   func(*microvm.VMSpec) {},
+			expected: []string{},
+		},
+		{
+			name: "resources",
+			mutate: func(s *microvm.VMSpec) {
+				s.VCPU = 0
+				s.MemoryMb = 512
+			},
+			expected: []string{"spec.vcpu", "spec.memoryMb"},
+		},
+		{
+			name: "memory quantity",
+			mutate: func(s *microvm.VMSpec) {
+				s.Memory = resource.NewQuantity(4*1024*microvm.Mebibyte, resource.BinarySI)
+			},
+			expected: []string{"spec.memory"},
+		},
+		{
+			name: "memory quantity replaces memoryMb",
+			mutate: func(s *microvm.VMSpec) {
+				s.MemoryMb = 0
+				s.Memory = resource.NewQuantity(4*1000*1000*1000, resource.DecimalSI)
+			},
+			expected: []string{},
+		},
+		{
+			name: "memory quantity too small",
+			mutate: func(s *microvm.VMSpec) {
+				s.MemoryMb = 0
+				s.Memory = resource.NewQuantity(512*microvm.Mebibyte, resource.BinarySI)
+			},
+			expected: []string{"spec.memory"},
+		},
+		{
+			name: "memory overflows the flintlock api",
+			mutate: func(s *microvm.VMSpec) {
+				s.MemoryMb = math.MaxInt32 + 1
+			},
+			expected: []string{"spec.memoryMb"},
+		},
+		{
+			name: "resources from the instance type",
+			mutate: func(s *microvm.VMSpec) {
+				s.InstanceType = "small"
+				s.VCPU = 0
+				s.MemoryMb = 0
+			},
+			expected: []string{},
+		},
+		{
+			name: "instance type with invalid overrides",
+			mutate: func(s *microvm.VMSpec) {
+				s.InstanceType = "Not_Valid"
+				s.VCPU = -1
+				s.MemoryMb = 512
+			},
+			expected: []string{"spec.instanceType", "spec.vcpu", "spec.memoryMb"},
+		},
+		{
+			name: "firecracker vcpu limit",
+			mutate: func(s *microvm.VMSpec) {
+				s.Provider = microvm.ProviderFirecracker
+				s.VCPU = 64
+			},
+			expected: []string{"spec.vcpu"},
+		},
+		{
+			name: "cloud-hypervisor vcpu limit",
+			mutate: func(s *microvm.VMSpec) {
+				s.Provider = microvm.ProviderCloudHypervisor
+				s.VCPU = 64
+			},
+			expected: []string{},
+		},
+		{
+			name: "strictest limits without a provider",
+			mutate: func(s *microvm.VMSpec) {
+				s.VCPU = 64
+			},
+			expected: []string{"spec.vcpu"},
+		},
+		{
+			name: "unknown provider uses the strictest limits",
+			mutate: func(s *microvm.VMSpec) {
+				s.Provider = "firecraker"
+				s.MemoryMb = 1
+			},
+			expected: []string{"spec.provider", "spec.memoryMb"},
+		},
+		{
+			name: "root volume",
+			mutate: func(s *microvm.VMSpec) {
+				s.RootVolume = microvm.Volume{MountPoint: "/", VirtioFSPath: "/mnt"}
+			},
+			expected: []string{"spec.rootVolume.id", "spec.rootVolume.image", "spec.rootVolume.mountPoint", "spec.rootVolume.virtiofsPath"},
+		},
+		{
+			name: "additional volumes",
+			mutate: func(s *microvm.VMSpec) {
+				s.AdditionalVolumes = append(s.AdditionalVolumes,
+					microvm.Volume{ID: "root", Image: "foo"},
+					microvm.Volume{ID: "data", Image: "foo"},
+					microvm.Volume{Image: "foo", VirtioFSPath: "/mnt"},
+					microvm.Volume{ID: "nosource"},
+				)
+			},
+			expected: []string{"spec.volumes[2].id", "spec.volumes[3].id", "spec.volumes[4].id", "spec.volumes[4].virtiofsPath", "spec.volumes[5]"},
+		},
+		{
+			name: "kernel and initrd",
+			mutate: func(s *microvm.VMSpec) {
+				s.Kernel.Image = ""
+				s.Initrd = &microvm.ContainerFileSource{}
+			},
+			expected: []string{"spec.kernel.image", "spec.initrd.image"},
+		},
+		{
+			name: "kernel cmdline",
+			mutate: func(s *microvm.VMSpec) {
+				s.KernelCmdLine = microvm.KernelCmdLine{
+					{Name: "console", Value: "ttyS0"},
+					{Name: "console", Value: "tty0"},
+					{Name: "quiet"},
+					{Value: "foo"},
+					{Name: "a b"},
+					{Name: "dyndbg", Value: `"file foo.c"`},
+				}
+			},
+			expected: []string{"spec.kernelCmdline[3]", "spec.kernelCmdline[4]", "spec.kernelCmdline[5]"},
+		},
+		{
+			name: "no network interfaces",
+			mutate: func(s *microvm.VMSpec) {
+				s.NetworkInterfaces = nil
+			},
+			expected: []string{"spec.networkInterfaces"},
+		},
+		{
+			name: "network interfaces",
+			mutate: func(s *microvm.VMSpec) {
+				s.NetworkInterfaces = append(s.NetworkInterfaces,
+					microvm.NetworkInterface{GuestDeviceName: "eth0", Type: microvm.IfaceTypeTap},
+					microvm.NetworkInterface{GuestDeviceName: "eth2", Type: "bridge", GuestMAC: "not-a-mac"},
+					microvm.NetworkInterface{GuestDeviceName: "eth3", Type: microvm.IfaceTypeTap, GuestMAC: "01:00:00:00:00:01"},
+					microvm.NetworkInterface{GuestDeviceName: "eth4", Type: microvm.IfaceTypeTap, GuestMAC: "02:00:00:00:00:01"},
+					microvm.NetworkInterface{GuestDeviceName: "eth5", Type: microvm.IfaceTypeTap, Address: "10.0.0.2"},
+					microvm.NetworkInterface{Type: microvm.IfaceTypeTap},
+				)
+			},
+			expected: []string{
+				"spec.networkInterfaces[2].guestDeviceName",
+				"spec.networkInterfaces[3].guestMac",
+				"spec.networkInterfaces[3].type",
+				"spec.networkInterfaces[4].guestMac",
+				"spec.networkInterfaces[5].guestMac",
+				"spec.networkInterfaces[6].address",
+				"spec.networkInterfaces[7].guestDeviceName",
+			},
+		},
+		{
+			name: "labels",
+			mutate: func(s *microvm.VMSpec) {
+				s.Labels = map[string]string{"not valid": "foo"}
+			},
+			expected: []string{"spec.labels"},
+		},
+		{
+			name: "reserved labels",
+			mutate: func(s *microvm.VMSpec) {
+				s.Labels = map[string]string{
+					"app":                  "foo",
+					microvm.OwnerNameLabel: "foo",
+					microvm.SpecHashLabel:  "1234",
+				}
+			},
+			expected: []string{"spec.labels[liquidmetal.dev/owner-name]", "spec.labels[liquidmetal.dev/spec-hash]"},
+		},
+		{
+			name: "placement",
+			mutate: func(s *microvm.VMSpec) {
+				s.Placement = &microvm.PlacementSpec{
+					TopologySpreadConstraints: []microvm.TopologySpreadConstraint{
+						{
+							MaxSkew:       1,
+							TopologyKey:   microvm.ZoneLabel,
+							LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"cluster": "a"}},
+						},
+						{WhenUnsatisfiable: "Sometimes"},
+					},
+					AntiAffinity: []microvm.AntiAffinityTerm{
+						{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"cluster": "a"}}},
+						{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"not valid": "a"}}},
+					},
+				}
+			},
+			expected: []string{
+				"spec.placement.topologySpreadConstraints[1].maxSkew",
+				"spec.placement.topologySpreadConstraints[1].topologyKey",
+				"spec.placement.topologySpreadConstraints[1].whenUnsatisfiable",
+				"spec.placement.topologySpreadConstraints[1].labelSelector",
+				"spec.placement.antiAffinity[1].labelSelector.matchLabels",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := validSpec()
+			tc.mutate(&spec)
+
+			errs := ValidateVMSpec(&spec, field.NewPath("spec"))
+
+			g.Expect(fields(errs)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func fields(errs field.ErrorList) []string {
+	paths := []string{}
+
+	for _, err := range errs {
+		paths = append(paths, err.Field)
+	}
+
+	return paths
+}